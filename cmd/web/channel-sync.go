@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/eldicela/bookings/internal/channels"
+	"github.com/eldicela/bookings/internal/repository"
+)
+
+// syncChannels syncs every property with each channel in the background, once at start and then every
+// interval. An interval of 0 turns syncing off
+func syncChannels(repo repository.DatabaseRepo, list []channels.Channel, interval time.Duration) {
+	if interval <= 0 || len(list) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			pollChannels(repo, list)
+			<-ticker.C
+		}
+	}()
+}
+
+// pollChannels pulls the bookings made on each channel and pushes availability and rates back
+func pollChannels(repo repository.DatabaseRepo, list []channels.Channel) {
+	properties, err := repo.AllProperties()
+	if err != nil {
+		errorLog.Println(err)
+		return
+	}
+
+	for _, property := range properties {
+		for _, ch := range list {
+			result, err := channels.Run(repo, ch, property)
+			if err != nil {
+				errorLog.Printf("cannot sync %s with %s: %s", property.Name, ch.Name(), err)
+				continue
+			}
+			if result.Accepted > 0 {
+				infoLog.Printf("booked %d reservation(s) from %s for %s", result.Accepted, ch.Name(), property.Name)
+			}
+			for _, ack := range result.Rejected {
+				errorLog.Printf("refused %s booking %s for %s: %s", ch.Name(), ack.Ref, property.Name, ack.Reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/eldicela/bookings/internal/integrity"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/repository"
+	"github.com/eldicela/bookings/internal/repository/dbrepo"
+)
+
+// check runs `bookings check`, which looks for reservations and room calendars that disagree and
+// optionally repairs the safe cases. It returns 1 while issues are left, for use from cron or CI
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	dbConf := dbFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fix := fs.Bool("fix", false, "Repair orphaned calendar rows and put reservations back on free calendars")
+	fs.Parse(args)
+
+	if !dbConf.complete() {
+		fmt.Fprintln(os.Stderr, "Missing required flags")
+		return 2
+	}
+
+	db, err := dbConf.connect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		return 2
+	}
+	defer db.SQL.Close()
+
+	report, err := runCheck(dbrepo.NewMysqlRepo(db.SQL, &app), *fix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printReport(os.Stdout, report)
+	}
+
+	if report.Unfixed() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runCheck checks the database and, when fix is set, repairs what can safely be repaired
+func runCheck(repo repository.DatabaseRepo, fix bool) (integrity.Report, error) {
+	reservations, err := repo.AllReservationStays()
+	if err != nil {
+		return integrity.Report{}, err
+	}
+
+	restrictions, err := repo.AllRoomRestrictions()
+	if err != nil {
+		return integrity.Report{}, err
+	}
+
+	report := integrity.Check(reservations, restrictions)
+	if !fix {
+		return report, nil
+	}
+
+	byId := make(map[int]models.Reservation, len(reservations))
+	for _, res := range reservations {
+		byId[res.ID] = res
+	}
+
+	for i, issue := range report.Issues {
+		if !issue.Fixable {
+			continue
+		}
+
+		switch issue.Kind {
+		case integrity.KindOrphanedRestriction:
+			err = repo.DeleteRoomRestriction(issue.RestrictionIds[0])
+			if err != nil {
+				return report, err
+			}
+			report.Issues[i].Fixed = true
+
+		case integrity.KindMissingRestriction:
+			res := byId[issue.ReservationId]
+
+			// an earlier fix may have taken the room, two missing stays can overlap each other
+			available, err := repo.SearchAvailabilityByDatesByRoomID(res.Stay(), res.RoomId)
+			if err != nil {
+				return report, err
+			}
+			if !available {
+				continue
+			}
+
+			err = repo.InsertRoomRestriction(models.RoomRestriction{
+				RoomId:        res.RoomId,
+				StartDate:     res.StartDate,
+				EndDate:       res.EndDate,
+				ReservationId: res.ID,
+				RestrictionId: integrity.RestrictionReservation,
+			})
+			if err != nil {
+				return report, err
+			}
+			report.Issues[i].Fixed = true
+		}
+	}
+
+	return report, nil
+}
+
+// printReport writes the report for people
+func printReport(w io.Writer, report integrity.Report) {
+	fmt.Fprintf(w, "Checked %d reservations and %d calendar rows\n", report.Reservations, report.Restrictions)
+
+	fixed := 0
+	for _, issue := range report.Issues {
+		status := ""
+		switch {
+		case issue.Fixed:
+			status = " (fixed)"
+			fixed++
+		case issue.Fixable:
+			status = " (fixable with -fix)"
+		}
+		fmt.Fprintf(w, "%s: %s%s\n", issue.Kind, issue.Message, status)
+	}
+
+	fmt.Fprintf(w, "%d issue(s), %d fixed\n", len(report.Issues), fixed)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/eldicela/bookings/internal/driver"
+)
+
+// dbConfig holds the database flags the server and the commands share
+type dbConfig struct {
+	host *string
+	name *string
+	user *string
+	pass *string
+	port *string
+}
+
+// dbFlags adds the database flags to fs
+func dbFlags(fs *flag.FlagSet) dbConfig {
+	return dbConfig{
+		host: fs.String("dbhost", "localhost", "Database Host"),
+		name: fs.String("dbname", "", "Database name"),
+		user: fs.String("dbuser", "", "Database user"),
+		pass: fs.String("dbpass", "", "Database password"),
+		port: fs.String("dbport", "3306", "Database port"),
+	}
+}
+
+// complete reports whether the database flags without a default were given
+func (c dbConfig) complete() bool {
+	return *c.name != "" && *c.user != "" && *c.pass != ""
+}
+
+// connect opens the database the flags point to
+func (c dbConfig) connect() (*driver.DB, error) {
+	// username:password@protocol(address)/dbname?param=value
+	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", *c.user, *c.pass, *c.host, *c.port, *c.name)
+	return driver.ConnectSQL(connectionString)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"github.com/eldicela/bookings/internal/housekeeping"
+	"github.com/eldicela/bookings/internal/repository"
+)
+
+// planHousekeeping plans the cleaning tasks of the day for every property in the background, once at
+// start and then every interval. An interval of 0 turns planning off
+func planHousekeeping(repo repository.DatabaseRepo, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			planToday(repo)
+			<-ticker.C
+		}
+	}()
+}
+
+// planToday plans the tasks of today for each property, today being the day where the property is
+func planToday(repo repository.DatabaseRepo) {
+	properties, err := repo.AllProperties()
+	if err != nil {
+		errorLog.Println(err)
+		return
+	}
+
+	for _, property := range properties {
+		today := property.Today()
+
+		err = housekeeping.Run(repo, property.ID, today, today)
+		if err != nil {
+			errorLog.Printf("cannot plan housekeeping for %s: %s", property.Name, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/eldicela/bookings/internal/calsync"
+	"github.com/eldicela/bookings/internal/ical"
+	"github.com/eldicela/bookings/internal/repository"
+)
+
+// syncCalendars polls the outside calendars of every property in the background, once at start and then
+// every interval. An interval of 0 turns polling off
+func syncCalendars(repo repository.DatabaseRepo, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			pollCalendars(repo)
+			<-ticker.C
+		}
+	}()
+}
+
+// pollCalendars syncs every outside calendar that has a URL. The outcome of each is in the sync log
+func pollCalendars(repo repository.DatabaseRepo) {
+	properties, err := repo.AllProperties()
+	if err != nil {
+		errorLog.Println(err)
+		return
+	}
+
+	for _, property := range properties {
+		sources, err := repo.AllICalSources(property.ID)
+		if err != nil {
+			errorLog.Println(err)
+			continue
+		}
+
+		for _, source := range sources {
+			if source.URL == "" {
+				continue
+			}
+
+			entry, err := calsync.Run(repo, source, property.Today(), func() ([]ical.Event, error) {
+				return calsync.Fetch(calsync.Client, source.URL)
+			})
+			if err != nil {
+				errorLog.Println(err)
+				continue
+			}
+			if entry.Status == calsync.StatusError {
+				errorLog.Printf("cannot sync calendar %d of %s: %s", source.ID, source.Room.RoomName, entry.Message)
+			}
+		}
+	}
+}
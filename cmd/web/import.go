@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/eldicela/bookings/internal/importer"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/repository/dbrepo"
+	"github.com/eldicela/bookings/internal/timeline"
+)
+
+// importBookings runs `bookings import file.csv`, which loads reservations and blocks from a CSV file.
+// It only reports what it would do unless -commit is given. It returns 1 when some rows cannot be imported
+func importBookings(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbConf := dbFlags(fs)
+	slug := fs.String("property", "", "Slug of the property to import into, the default property if empty")
+	commit := fs.Bool("commit", false, "Import the valid rows instead of only checking the file")
+	fs.Parse(args)
+
+	if !dbConf.complete() || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Missing required flags or import file")
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	db, err := dbConf.connect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot connect to database:", err)
+		return 2
+	}
+	defer db.SQL.Close()
+
+	repo := dbrepo.NewMysqlRepo(db.SQL, &app)
+
+	var property models.Property
+	if *slug == "" {
+		property, err = repo.GetDefaultProperty()
+	} else {
+		property, err = repo.GetPropertyBySlug(*slug)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot find the property:", err)
+		return 2
+	}
+
+	result, err := importer.Run(repo, property.ID, f, *commit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	for _, res := range result.Reservations {
+		err = repo.InsertReservationEvent(models.ReservationEvent{
+			ReservationId: res.ID,
+			EventType:     timeline.EventCreated,
+			Description:   fmt.Sprintf("Imported from %s", fs.Arg(0)),
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	printImport(os.Stdout, result)
+
+	if result.Invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printImport writes the outcome of an import for people
+func printImport(w io.Writer, result importer.Result) {
+	for _, row := range result.Rows {
+		for _, msg := range row.Errors {
+			fmt.Fprintf(w, "line %d: %s\n", row.Line, msg)
+		}
+	}
+
+	if result.Imported {
+		fmt.Fprintf(w, "Imported %d reservation(s) and %d blocked night(s), skipped %d invalid row(s)\n",
+			len(result.Reservations), len(result.Blocks), result.Invalid)
+		return
+	}
+	fmt.Fprintf(w, "%d row(s) can be imported, %d cannot. Nothing was imported, run with -commit to import\n",
+		result.Valid, result.Invalid)
+}
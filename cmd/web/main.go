@@ -7,14 +7,18 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
+	_ "time/tzdata" // property timezones must resolve on hosts without a zoneinfo database
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/eldicela/bookings/internal/channels"
 	"github.com/eldicela/bookings/internal/config"
 	"github.com/eldicela/bookings/internal/driver"
 	"github.com/eldicela/bookings/internal/handlers"
 	"github.com/eldicela/bookings/internal/helpers"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/payments"
 	"github.com/eldicela/bookings/internal/render"
 )
 
@@ -25,7 +29,17 @@ var session *scs.SessionManager
 var infoLog *log.Logger
 var errorLog *log.Logger
 
+// channelList holds the channels properties are sold on. A connector to another channel manager or travel
+// agency only needs adding here
+var channelList []channels.Channel
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(check(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(importBookings(os.Args[2:]))
+	}
 
 	db, err := run()
 	if err != nil {
@@ -38,6 +52,15 @@ func main() {
 	fmt.Println("Starting mail listener...")
 	listenForMail()
 
+	fmt.Println("Starting calendar sync...")
+	syncCalendars(handlers.Repo.DB, app.ICalSync)
+
+	fmt.Println("Starting channel sync...")
+	syncChannels(handlers.Repo.DB, channelList, app.ChannelSync)
+
+	fmt.Println("Starting housekeeping planning...")
+	planHousekeeping(handlers.Repo.DB, app.HousekeepingPlan)
+
 	// http.HandleFunc("/", handlers.Repo.Home)
 	// http.HandleFunc("/about", handlers.Repo.About)
 
@@ -61,6 +84,7 @@ func run() (*driver.DB, error) {
 	// what am i going to put in the session
 
 	gob.Register(models.Reservation{})
+	gob.Register([]models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
@@ -69,16 +93,22 @@ func run() (*driver.DB, error) {
 	// read flags
 	inProduction := flag.Bool("production", true, "Application is in production")
 	useCache := flag.Bool("cache", true, "Use template cache")
-	dbHost := flag.String("dbhost", "localhost", "Database Host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "3306", "Database port")
+	dbConf := dbFlags(flag.CommandLine)
+	currency := flag.String("currency", "USD", "Currency for prices and payments")
+	depositPercent := flag.Int("deposit", 20, "Percentage of the stay collected as deposit when booking")
+	paymentGateway := flag.String("gateway", "fake", "Payment gateway, fake approves every card and is refused in production")
+	paymentSecret := flag.String("paymentsecret", "", "Secret used to verify payment gateway webhooks")
+	baseURL := flag.String("baseurl", "http://localhost:8080", "Public URL of the site, used in links sent by email")
+	attachInvoice := flag.Bool("attachinvoice", false, "Attach the invoice PDF to confirmation emails")
+	icalSync := flag.Duration("icalsync", 15*time.Minute, "How often outside calendars are synced, 0 turns syncing off")
+	channelDir := flag.String("channeldir", "", "Directory the file channel exchanges availability and bookings in, empty turns it off")
+	channelSync := flag.Duration("channelsync", 5*time.Minute, "How often channels are synced, 0 turns syncing off")
+	housekeepingPlan := flag.Duration("housekeepingplan", time.Hour, "How often the housekeeping tasks of the day are planned, 0 turns planning off")
 	// dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require)")
 
 	flag.Parse()
 
-	if *dbName == "" || *dbUser == "" || *dbPass == "" {
+	if !dbConf.complete() {
 		fmt.Println("Missing required flags")
 		os.Exit(1)
 	}
@@ -86,6 +116,33 @@ func run() (*driver.DB, error) {
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
 
+	if *inProduction && *paymentSecret == "" {
+		fmt.Println("A payment secret is required in production")
+		os.Exit(1)
+	}
+	switch *paymentGateway {
+	case "fake":
+		if *inProduction {
+			fmt.Println("The fake payment gateway cannot be used in production")
+			os.Exit(1)
+		}
+		app.Payments = payments.NewFakeGateway(*paymentSecret)
+	default:
+		fmt.Printf("Unknown payment gateway %s\n", *paymentGateway)
+		os.Exit(1)
+	}
+	app.Currency = *currency
+	app.DepositPercent = *depositPercent
+	app.BaseURL = strings.TrimSuffix(*baseURL, "/")
+	app.AttachInvoice = *attachInvoice
+	app.ICalSync = *icalSync
+	app.ChannelSync = *channelSync
+	app.HousekeepingPlan = *housekeepingPlan
+
+	if *channelDir != "" {
+		channelList = append(channelList, channels.NewFileChannel(*channelDir))
+	}
+
 	//Change this to true in production
 	app.InProduction = *inProduction
 	app.UseCache = *useCache
@@ -105,9 +162,7 @@ func run() (*driver.DB, error) {
 	app.Session = session
 
 	// Connect to database
-	// username:password@protocol(address)/dbname?param=value
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", *dbUser, *dbPass, *dbHost, *dbPort, *dbName)
-	db, err := driver.ConnectSQL(connectionString)
+	db, err := dbConf.connect()
 	if err != nil {
 		log.Fatal("cannot connect to database! Dying...")
 	}
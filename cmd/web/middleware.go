@@ -2,7 +2,10 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/eldicela/bookings/internal/api"
+	"github.com/eldicela/bookings/internal/apikeys"
 	"github.com/eldicela/bookings/internal/helpers"
 	"github.com/justinas/nosurf"
 )
@@ -17,6 +20,22 @@ func NoSurf(next http.Handler) http.Handler {
 		Secure:   app.InProduction,
 		SameSite: http.SameSiteLaxMode,
 	})
+
+	// the payment gateway posts webhooks without a csrf token, they are verified by signature instead
+	csrfHandler.ExemptPath("/payments/webhook")
+	// API clients send a key instead of a csrf token. The key is checked by the API routes, so a request
+	// with a made up key is turned away there
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, api.Prefix+"/") && apikeys.FromRequest(r) != ""
+	})
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, api.Prefix+"/") {
+			api.Fail(w, api.KeyRequired())
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	}))
+
 	return csrfHandler
 }
 
@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 
+	"github.com/eldicela/bookings/internal/api"
+	"github.com/eldicela/bookings/internal/apikeys"
 	"github.com/eldicela/bookings/internal/config"
 	"github.com/eldicela/bookings/internal/handlers"
 	"github.com/go-chi/chi/v5"
@@ -16,21 +18,31 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Group(func(mux chi.Router) {
+		mux.Use(handlers.Repo.DefaultProperty)
 
-	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJson)
-	mux.Get("/contact", handlers.Repo.Contact)
-	mux.Get("/chose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room", handlers.Repo.BookRoom)
+		mux.Get("/", handlers.Repo.Home)
+		mux.Get("/about", handlers.Repo.About)
+		mux.Get("/generals-quarters", handlers.Repo.Generals)
+		mux.Get("/majors-suite", handlers.Repo.Majors)
 
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+		propertyRoutes(mux)
+	})
+
+	mux.Route("/{property}", func(mux chi.Router) {
+		mux.Use(handlers.Repo.PropertyBySlug)
+
+		propertyRoutes(mux)
+	})
+
+	mux.Get("/reservations/manage/{token}", handlers.Repo.ManageReservation)
+	mux.Get("/reservations/manage/{token}/invoice.pdf", handlers.Repo.ManageReservationInvoice)
+
+	mux.Get("/ical/{room}.ics", handlers.Repo.RoomCalendarFeed)
+
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+
+	mux.Route(api.Prefix, apiRoutes)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
@@ -38,21 +50,130 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Route("/admin", func(mux chi.Router) {
 		// mux.Use(Auth)
+		mux.Use(handlers.Repo.DefaultProperty)
 
 		mux.Get("/dashboard", handlers.Repo.AdminDashBoard)
+		mux.Get("/dashboard/{list}/print", handlers.Repo.AdminPrintFrontDeskList)
+		mux.Post("/dashboard/{id}/check-in", handlers.Repo.AdminCheckIn)
+		mux.Post("/dashboard/{id}/check-out", handlers.Repo.AdminCheckOut)
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
 		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
 
+		mux.Get("/reservations/{src}/export", handlers.Repo.AdminExportReservations)
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Get("/reservations/{src}/{id}/invoice.pdf", handlers.Repo.AdminReservationInvoice)
+		mux.Post("/reservations/{src}/{id}/room", handlers.Repo.AdminReassignRoom)
+		mux.Post("/reservations/{src}/{id}/notes", handlers.Repo.AdminPostReservationNote)
+		mux.Post("/reservations/{src}/{id}/cancel", handlers.Repo.AdminCancelReservation)
+		mux.Post("/reservations/{src}/{id}/refund/{paymentId}", handlers.Repo.AdminRefundPayment)
+		mux.Post("/reservations/{src}/{id}/folio", handlers.Repo.AdminPostFolioEntry)
+		mux.Post("/reservations/{src}/{id}/folio/{entryId}/void", handlers.Repo.AdminVoidFolioEntry)
 
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+
+		mux.Get("/guests", handlers.Repo.AdminGuests)
+		mux.Get("/guests/{id}", handlers.Repo.AdminShowGuest)
+		mux.Post("/guests/{id}", handlers.Repo.AdminPostGuest)
+		mux.Post("/guests/{id}/merge", handlers.Repo.AdminMergeGuest)
+
+		mux.Get("/groups", handlers.Repo.AdminGroups)
+		mux.Get("/groups/{id}", handlers.Repo.AdminShowGroup)
+		mux.Post("/groups/{id}", handlers.Repo.AdminPostGroup)
+		mux.Post("/groups/{id}/reservations", handlers.Repo.AdminPostGroupReservation)
+		mux.Post("/groups/{id}/reservations/{reservationId}/remove", handlers.Repo.AdminRemoveGroupReservation)
+
+		mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		mux.Post("/promo-codes", handlers.Repo.AdminPostPromoCode)
+		mux.Post("/promo-codes/{id}/active/{active}", handlers.Repo.AdminTogglePromoCode)
+
+		mux.Get("/room-types", handlers.Repo.AdminRoomTypes)
+		mux.Post("/room-types", handlers.Repo.AdminPostRoomType)
+		mux.Post("/rooms/{id}/room-type", handlers.Repo.AdminPostRoomRoomType)
+		mux.Post("/rooms/{id}/ical-token", handlers.Repo.AdminPostRoomICalToken)
+
+		mux.Get("/properties", handlers.Repo.AdminProperties)
+		mux.Post("/properties", handlers.Repo.AdminPostProperty)
+		mux.Post("/properties/{id}", handlers.Repo.AdminUpdateProperty)
+		mux.Post("/properties/{id}/select", handlers.Repo.AdminSelectProperty)
+		mux.Post("/properties/{id}/staff", handlers.Repo.AdminPostPropertyStaff)
+
+		mux.Get("/reports", handlers.Repo.AdminReports)
+		mux.Get("/reports.json", handlers.Repo.AdminReportsJSON)
+
+		mux.Get("/import", handlers.Repo.AdminImport)
+		mux.Post("/import", handlers.Repo.AdminPostImport)
+
+		mux.Get("/audit-log", handlers.Repo.AdminAuditLog)
+
+		mux.Get("/housekeeping", handlers.Repo.AdminHousekeeping)
+		mux.Post("/housekeeping/plan", handlers.Repo.AdminPostHousekeepingPlan)
+		mux.Post("/housekeeping/rooms/{id}/status", handlers.Repo.AdminPostRoomStatus)
+		mux.Post("/housekeeping/tasks/{id}/done", handlers.Repo.AdminCompleteHousekeepingTask)
+
+		mux.Get("/ical-sources", handlers.Repo.AdminICalSources)
+		mux.Post("/ical-sources", handlers.Repo.AdminPostICalSource)
+		mux.Post("/ical-sources/{id}/sync", handlers.Repo.AdminSyncICalSource)
+		mux.Post("/ical-sources/{id}/delete", handlers.Repo.AdminDeleteICalSource)
+
+		mux.Get("/api-keys", handlers.Repo.AdminAPIKeys)
+		mux.Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+		mux.Post("/api-keys/{id}/revoke", handlers.Repo.AdminRevokeAPIKey)
+
+		mux.Get("/taxes-fees", handlers.Repo.AdminTaxesFees)
+		mux.Post("/taxes-fees", handlers.Repo.AdminPostTaxFeeRule)
+		mux.Post("/taxes-fees/{id}/active/{active}", handlers.Repo.AdminToggleTaxFeeRule)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 	return mux
 }
+
+// propertyRoutes are the booking pages of a property, served both under its slug and, for the
+// default property, at the top level
+func propertyRoutes(mux chi.Router) {
+	mux.Get("/search-availability", handlers.Repo.Availability)
+	mux.Post("/search-availability", handlers.Repo.PostAvailability)
+	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJson)
+	mux.Get("/contact", handlers.Repo.Contact)
+	mux.Get("/chose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Post("/chose-rooms", handlers.Repo.ChooseRooms)
+	mux.Get("/book-room", handlers.Repo.BookRoom)
+
+	mux.Get("/make-reservation", handlers.Repo.Reservation)
+	mux.Post("/make-reservation", handlers.Repo.PostReservation)
+	mux.Post("/make-reservation/promo", handlers.Repo.ApplyPromoCode)
+	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+}
+
+// apiRoutes are the endpoints of the public JSON API. They answer in JSON even when nothing matches.
+// Every endpoint but the guest's own reservation needs an API key with the scope it is grouped under
+func apiRoutes(mux chi.Router) {
+	mux.Use(handlers.Repo.APIKey)
+	mux.NotFound(handlers.Repo.APINotFound)
+	mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+	mux.With(handlers.Repo.APIScope(apikeys.ScopeAvailability)).Get("/properties", handlers.Repo.APIProperties)
+	mux.Get("/reservations/{token}", handlers.Repo.APIShowReservation)
+
+	mux.Route("/properties/{property}", func(mux chi.Router) {
+		mux.Use(handlers.Repo.APIProperty)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(handlers.Repo.APIScope(apikeys.ScopeAvailability))
+
+			mux.Get("/", handlers.Repo.APIShowProperty)
+			mux.Get("/rooms", handlers.Repo.APIRooms)
+			mux.Get("/rooms/{id}", handlers.Repo.APIRoom)
+			mux.Get("/availability", handlers.Repo.APIAvailability)
+			mux.Post("/quotes", handlers.Repo.APIQuote)
+		})
+
+		mux.With(handlers.Repo.APIScope(apikeys.ScopeReservations)).Post("/reservations", handlers.Repo.APICreateReservation)
+		mux.With(handlers.Repo.APIScope(apikeys.ScopeAdmin)).Get("/reservations", handlers.Repo.APIReservations)
+	})
+}
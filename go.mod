@@ -14,6 +14,6 @@ require github.com/go-sql-driver/mysql v1.7.0
 
 require (
 	github.com/toorop/go-dkim v0.0.0-20201103131630-e1cd1a0a5208 // indirect
-	github.com/xhit/go-simple-mail/v2 v2.13.0 // indirect
+	github.com/xhit/go-simple-mail/v2 v2.13.0
 	golang.org/x/crypto v0.4.0
 )
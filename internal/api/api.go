@@ -0,0 +1,215 @@
+// Package api holds the envelopes, errors and pagination of the public JSON API, so that every endpoint
+// answers in the same shape. Successful responses carry their payload in data, lists add the page they
+// hold in meta, and failures carry an error with the status, a stable code and the problems per field
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Prefix is the path the current version of the API is served under
+const Prefix = "/api/v1"
+
+// Page sizes
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+	// MaxPage keeps the offset of a page well within an int, whatever page is asked for
+	MaxPage = 1000000
+)
+
+// maxBody is the largest request body read
+const maxBody = 1 << 20
+
+// Error codes
+const (
+	CodeInvalidBody      = "invalid_body"
+	CodeUnsupportedType  = "unsupported_media_type"
+	CodeValidation       = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeRateLimited      = "rate_limited"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodePaymentDeclined  = "payment_declined"
+	CodeInternal         = "internal_error"
+)
+
+// Envelope is the body of a successful response
+type Envelope struct {
+	Data interface{} `json:"data"`
+	Meta *Meta       `json:"meta,omitempty"`
+}
+
+// Meta describes the page of a list
+type Meta struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+	Pages   int `json:"pages"`
+}
+
+// Error is the error of a failed response. Fields holds the messages of each invalid field
+type Error struct {
+	Status  int                 `json:"status"`
+	Code    string              `json:"code"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// NewError creates an error with status, code and message
+func NewError(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// NotFound is the error for a missing resource
+func NotFound(what string) *Error {
+	return NewError(http.StatusNotFound, CodeNotFound, fmt.Sprintf("%s not found", what))
+}
+
+// KeyRequired is the error for a request that needs an API key and was sent without one
+func KeyRequired() *Error {
+	return NewError(http.StatusUnauthorized, CodeUnauthorized,
+		"send an API key as a Bearer token in the Authorization header, or in an X-API-Key header")
+}
+
+// Invalid is the error for a request with invalid fields
+func Invalid(fields map[string][]string) *Error {
+	e := NewError(http.StatusUnprocessableEntity, CodeValidation, "some fields are invalid")
+	e.Fields = fields
+	return e
+}
+
+// Internal is the error for a failure on the server. Its details are logged, not sent
+func Internal() *Error {
+	return NewError(http.StatusInternalServerError, CodeInternal, http.StatusText(http.StatusInternalServerError))
+}
+
+// Write sends v as JSON with status
+func Write(w http.ResponseWriter, status int, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = w.Write(out)
+	return err
+}
+
+// Respond sends data in an envelope. meta is only set for lists
+func Respond(w http.ResponseWriter, status int, data interface{}, meta *Meta) error {
+	return Write(w, status, Envelope{Data: data, Meta: meta})
+}
+
+// Fail sends e in an error envelope
+func Fail(w http.ResponseWriter, e *Error) error {
+	return Write(w, e.Status, struct {
+		Error *Error `json:"error"`
+	}{e})
+}
+
+// Decode reads the JSON body of r into v. Unknown fields are refused, so that a misspelt field is not
+// silently ignored. The body must be sent as application/json, which a browser cannot do from another
+// site without asking first
+func Decode(w http.ResponseWriter, r *http.Request, v interface{}) *Error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return NewError(http.StatusUnsupportedMediaType, CodeUnsupportedType, "body must be sent as application/json")
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("body must hold a single JSON object")
+	}
+
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF):
+		return NewError(http.StatusBadRequest, CodeInvalidBody, "body must not be empty")
+	case errors.As(err, &syntax), errors.Is(err, io.ErrUnexpectedEOF):
+		return NewError(http.StatusBadRequest, CodeInvalidBody, "body is not valid JSON")
+	case errors.As(err, &typ):
+		return Invalid(map[string][]string{typ.Field: {fmt.Sprintf("Must be a %s", typ.Type)}})
+	}
+	return NewError(http.StatusBadRequest, CodeInvalidBody, err.Error())
+}
+
+// Page is the page of a list a request asks for, numbered from 1
+type Page struct {
+	Number  int
+	PerPage int
+}
+
+// ParsePage reads the page and per_page parameters of a list request
+func ParsePage(q url.Values) (Page, *Error) {
+	p := Page{Number: 1, PerPage: DefaultPerPage}
+	fields := make(map[string][]string)
+
+	if s := q.Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPage {
+			fields["page"] = append(fields["page"], fmt.Sprintf("Must be a whole number from 1 to %d", MaxPage))
+		}
+		p.Number = n
+	}
+	if s := q.Get("per_page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxPerPage {
+			fields["per_page"] = append(fields["per_page"], fmt.Sprintf("Must be a whole number from 1 to %d", MaxPerPage))
+		}
+		p.PerPage = n
+	}
+
+	if len(fields) > 0 {
+		return p, Invalid(fields)
+	}
+	return p, nil
+}
+
+// Offset returns the index of the first item of the page
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.PerPage
+}
+
+// Bounds returns the indexes of the page within total items, for slicing a list held in full
+func (p Page) Bounds(total int) (int, int) {
+	start := p.Offset()
+	if start > total {
+		start = total
+	}
+	end := start + p.PerPage
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// Meta describes the page within total items
+func (p Page) Meta(total int) *Meta {
+	return &Meta{
+		Page:    p.Number,
+		PerPage: p.PerPage,
+		Total:   total,
+		Pages:   (total + p.PerPage - 1) / p.PerPage,
+	}
+}
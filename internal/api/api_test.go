@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestParsePage(t *testing.T) {
+	p, e := ParsePage(url.Values{})
+	if e != nil || p.Number != 1 || p.PerPage != DefaultPerPage {
+		t.Errorf("expected the first page by default, got %+v %v", p, e)
+	}
+
+	p, e = ParsePage(url.Values{"page": {"3"}, "per_page": {"10"}})
+	if e != nil || p.Offset() != 20 {
+		t.Errorf("expected page 3 of 10 to start at 20, got %+v %v", p, e)
+	}
+
+	_, e = ParsePage(url.Values{"page": {"0"}, "per_page": {"1000"}})
+	if e == nil || e.Status != http.StatusUnprocessableEntity || len(e.Fields["page"]) != 1 || len(e.Fields["per_page"]) != 1 {
+		t.Errorf("expected both fields invalid, got %+v", e)
+	}
+
+	_, e = ParsePage(url.Values{"page": {"4611686018427387904"}, "per_page": {"4"}})
+	if e == nil || len(e.Fields["page"]) != 1 {
+		t.Errorf("expected a page past MaxPage refused, got %+v", e)
+	}
+}
+
+func TestPage_Bounds(t *testing.T) {
+	p := Page{Number: 2, PerPage: 10}
+
+	if start, end := p.Bounds(25); start != 10 || end != 20 {
+		t.Errorf("expected 10 to 20, got %d to %d", start, end)
+	}
+	if start, end := p.Bounds(15); start != 10 || end != 15 {
+		t.Errorf("expected 10 to 15, got %d to %d", start, end)
+	}
+	if start, end := p.Bounds(5); start != 5 || end != 5 {
+		t.Errorf("expected an empty page past the end, got %d to %d", start, end)
+	}
+	if start, end := (Page{Number: MaxPage, PerPage: MaxPerPage}).Bounds(5); start != 5 || end != 5 {
+		t.Errorf("expected an empty last page, got %d to %d", start, end)
+	}
+
+	if m := p.Meta(25); m.Pages != 3 || m.Total != 25 || m.Page != 2 {
+		t.Errorf("wrong meta %+v", m)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var tests = []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"valid", `{"name": "a", "count": 2}`, 0, ""},
+		{"empty", ``, http.StatusBadRequest, ""},
+		{"broken", `{"name": `, http.StatusBadRequest, ""},
+		{"unknown field", `{"nmae": "a"}`, http.StatusBadRequest, ""},
+		{"wrong type", `{"count": "two"}`, http.StatusUnprocessableEntity, "count"},
+		{"two objects", `{} {}`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		var v struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json; charset=utf-8")
+		e := Decode(httptest.NewRecorder(), r, &v)
+
+		switch {
+		case tt.status == 0 && e != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, e)
+		case tt.status != 0 && (e == nil || e.Status != tt.status):
+			t.Errorf("%s: expected status %d, got %v", tt.name, tt.status, e)
+		case tt.field != "" && len(e.Fields[tt.field]) == 0:
+			t.Errorf("%s: expected an error for %s, got %+v", tt.name, tt.field, e)
+		}
+	}
+}
+
+func TestDecode_ContentType(t *testing.T) {
+	for _, ct := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		var v struct{}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", ct)
+
+		if e := Decode(httptest.NewRecorder(), r, &v); e == nil || e.Status != http.StatusUnsupportedMediaType {
+			t.Errorf("%q: expected the body refused, got %v", ct, e)
+		}
+	}
+}
+
+func TestFail(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Fail(rr, Invalid(map[string][]string{"email": {"Invalid email address"}}))
+
+	if rr.Code != http.StatusUnprocessableEntity || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("wrong response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	var body struct {
+		Error Error `json:"error"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil || body.Error.Code != CodeValidation || body.Error.Fields["email"][0] != "Invalid email address" {
+		t.Errorf("wrong envelope %s", rr.Body.String())
+	}
+}
+
+func TestRespond(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Respond(rr, http.StatusOK, []Room{NewRoom(models.Room{ID: 4, RoomName: "Attic"})}, Page{Number: 1, PerPage: 20}.Meta(1))
+
+	var body struct {
+		Data []map[string]interface{} `json:"data"`
+		Meta Meta                     `json:"meta"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil || len(body.Data) != 1 || body.Meta.Total != 1 {
+		t.Fatalf("wrong envelope %s", rr.Body.String())
+	}
+	if id, ok := body.Data[0]["id"].(float64); !ok || id != 4 {
+		t.Errorf("expected a numeric id, got %v", body.Data[0]["id"])
+	}
+	if body.Data[0]["room_type"] != nil {
+		t.Errorf("expected a null room type, got %v", body.Data[0]["room_type"])
+	}
+}
+
+func TestStatus(t *testing.T) {
+	now := time.Now()
+
+	if s := Status(models.Reservation{}); s != StatusConfirmed {
+		t.Errorf("expected confirmed, got %s", s)
+	}
+	if s := Status(models.Reservation{CheckedInAt: now}); s != StatusCheckedIn {
+		t.Errorf("expected checked in, got %s", s)
+	}
+	if s := Status(models.Reservation{CheckedInAt: now, CheckedOutAt: now}); s != StatusCheckedOut {
+		t.Errorf("expected checked out, got %s", s)
+	}
+	if s := Status(models.Reservation{CheckedInAt: now, CancelledAt: now}); s != StatusCancelled {
+		t.Errorf("expected cancelled, got %s", s)
+	}
+}
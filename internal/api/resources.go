@@ -0,0 +1,220 @@
+package api
+
+import (
+	"time"
+
+	"github.com/eldicela/bookings/internal/assignment"
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/pricing"
+)
+
+// Reservation statuses
+const (
+	StatusConfirmed  = "confirmed"
+	StatusCheckedIn  = "checked_in"
+	StatusCheckedOut = "checked_out"
+	StatusCancelled  = "cancelled"
+)
+
+// Property is a property as the API shows it
+type Property struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	Address      string `json:"address"`
+	Timezone     string `json:"timezone"`
+	CheckInTime  string `json:"check_in_time"`
+	CheckOutTime string `json:"check_out_time"`
+}
+
+// NewProperty shows p
+func NewProperty(p models.Property) Property {
+	return Property{
+		ID:           p.ID,
+		Name:         p.Name,
+		Slug:         p.Slug,
+		Email:        p.Email,
+		Phone:        p.Phone,
+		Address:      p.Address,
+		Timezone:     p.Location().String(),
+		CheckInTime:  p.CheckInTime,
+		CheckOutTime: p.CheckOutTime,
+	}
+}
+
+// RoomType is a room type as the API shows it
+type RoomType struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Room is a room as the API shows it. Prices are in cents, per night
+type Room struct {
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	RoomType        *RoomType `json:"room_type"`
+	Price           int       `json:"price"`
+	MaxOccupancy    int       `json:"max_occupancy"`
+	BaseOccupancy   int       `json:"base_occupancy"`
+	ExtraGuestPrice int       `json:"extra_guest_price"`
+}
+
+// NewRoom shows room. Rooms without a type have a null room_type
+func NewRoom(room models.Room) Room {
+	r := Room{
+		ID:              room.ID,
+		Name:            room.RoomName,
+		Price:           room.Price,
+		MaxOccupancy:    room.MaxOccupancy,
+		BaseOccupancy:   room.BaseOccupancy,
+		ExtraGuestPrice: room.ExtraGuestPrice,
+	}
+	if room.RoomTypeId > 0 {
+		r.RoomType = &RoomType{ID: room.RoomTypeId, Name: room.RoomType.Name}
+	}
+	return r
+}
+
+// RoomRef names the room of a reservation
+type RoomRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Offer is what is free of a room type for a stay
+type Offer struct {
+	RoomType     RoomType `json:"room_type"`
+	Available    int      `json:"available"`
+	FromPrice    int      `json:"from_price"`
+	MaxOccupancy int      `json:"max_occupancy"`
+}
+
+// Availability is the answer to an availability search
+type Availability struct {
+	Arrival   string  `json:"arrival"`
+	Departure string  `json:"departure"`
+	Nights    int     `json:"nights"`
+	Guests    int     `json:"guests"`
+	Offers    []Offer `json:"offers"`
+	Rooms     []Room  `json:"rooms"`
+}
+
+// NewAvailability shows the rooms free for a stay of guests, grouped by type as the search page does
+func NewAvailability(stay dates.Range, guests int, rooms []models.Room) Availability {
+	a := Availability{
+		Arrival:   stay.Start.Format(dates.Layout),
+		Departure: stay.End.Format(dates.Layout),
+		Nights:    stay.Nights(),
+		Guests:    guests,
+		Offers:    []Offer{},
+		Rooms:     make([]Room, len(rooms)),
+	}
+
+	for _, o := range assignment.Offers(rooms) {
+		if o.RoomType.ID == 0 {
+			continue
+		}
+		a.Offers = append(a.Offers, Offer{
+			RoomType:     RoomType{ID: o.RoomType.ID, Name: o.RoomType.Name},
+			Available:    o.Available,
+			FromPrice:    o.FromPrice,
+			MaxOccupancy: o.MaxOccupancy,
+		})
+	}
+	for i, room := range rooms {
+		a.Rooms[i] = NewRoom(room)
+	}
+
+	return a
+}
+
+// QuoteLine is one amount of a quote
+type QuoteLine struct {
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	Amount      int    `json:"amount"`
+}
+
+// Quote is the price of a stay, in cents of Currency
+type Quote struct {
+	Currency string      `json:"currency"`
+	Nights   int         `json:"nights"`
+	Lines    []QuoteLine `json:"lines"`
+	Total    int         `json:"total"`
+	Deposit  int         `json:"deposit"`
+}
+
+// NewQuote shows q with the deposit taken when booking
+func NewQuote(q pricing.Quote, currency string, deposit int) Quote {
+	out := Quote{
+		Currency: currency,
+		Nights:   q.Nights,
+		Lines:    make([]QuoteLine, len(q.Lines)),
+		Total:    q.Total,
+		Deposit:  deposit,
+	}
+	for i, l := range q.Lines {
+		out.Lines[i] = QuoteLine{Description: l.Description, Category: l.Category, Amount: l.Amount}
+	}
+	return out
+}
+
+// Reservation is a reservation as the API shows it
+type Reservation struct {
+	ID        int        `json:"id"`
+	Status    string     `json:"status"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	Email     string     `json:"email"`
+	Phone     string     `json:"phone"`
+	Arrival   string     `json:"arrival"`
+	Departure string     `json:"departure"`
+	Nights    int        `json:"nights"`
+	Adults    int        `json:"adults"`
+	Children  int        `json:"children"`
+	Room      RoomRef    `json:"room"`
+	Channel   string     `json:"channel,omitempty"`
+	ManageURL string     `json:"manage_url,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// NewReservation shows res. manageURL is only given to whoever may pass the guest link on
+func NewReservation(res models.Reservation, manageURL string) Reservation {
+	out := Reservation{
+		ID:        res.ID,
+		Status:    Status(res),
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		Arrival:   res.StartDate.Format(dates.Layout),
+		Departure: res.EndDate.Format(dates.Layout),
+		Nights:    res.Stay().Nights(),
+		Adults:    res.Adults,
+		Children:  res.Children,
+		Room:      RoomRef{ID: res.RoomId, Name: res.Room.RoomName},
+		Channel:   res.Channel,
+		ManageURL: manageURL,
+	}
+	if !res.CreatedAt.IsZero() {
+		created := res.CreatedAt
+		out.CreatedAt = &created
+	}
+	return out
+}
+
+// Status returns where a reservation stands
+func Status(res models.Reservation) string {
+	switch {
+	case !res.CancelledAt.IsZero():
+		return StatusCancelled
+	case !res.CheckedOutAt.IsZero():
+		return StatusCheckedOut
+	case !res.CheckedInAt.IsZero():
+		return StatusCheckedIn
+	}
+	return StatusConfirmed
+}
@@ -0,0 +1,134 @@
+// Package apikeys issues the keys the JSON API is called with, reads them off requests and limits how
+// often each may be used. Keys are long random strings, so a plain SHA-256 of a key is enough to find it
+// again without keeping the key itself
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes
+const (
+	ScopeAvailability = "availability:read"
+	ScopeReservations = "reservations:write"
+	ScopeAdmin        = "admin"
+)
+
+// Scopes lists the scopes a key can be given, in the order the admin area shows them
+var Scopes = []string{ScopeAvailability, ScopeReservations, ScopeAdmin}
+
+// ScopeNames describes each scope for staff
+var ScopeNames = map[string]string{
+	ScopeAvailability: "Read rooms, availability and quotes",
+	ScopeReservations: "Create reservations",
+	ScopeAdmin:        "Everything, including the reservation lists",
+}
+
+// DefaultRateLimit is the requests per minute a new key is allowed
+const DefaultRateLimit = 60
+
+// marker starts every key, so a leaked key is easy to recognise
+const marker = "bk_"
+
+// PrefixLength is how many characters of a key are kept in the clear
+const PrefixLength = len(marker) + 8
+
+// Header is the header a key may be sent in instead of an Authorization bearer header
+const Header = "X-API-Key"
+
+// Generate returns a new key, its prefix and its hash. The key is shown once and never stored
+func Generate() (key, prefix, hash string, err error) {
+	b := make([]byte, 24)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key = marker + hex.EncodeToString(b)
+	return key, key[:PrefixLength], Hash(key), nil
+}
+
+// Hash returns the hash a key is stored and looked up by
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FromRequest returns the key sent with r, or "" when there is none
+func FromRequest(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get(Header)); key != "" {
+		return key
+	}
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// Valid reports whether scope is a known scope
+func Valid(scope string) bool {
+	_, ok := ScopeNames[scope]
+	return ok
+}
+
+// Allows reports whether a key with scopes may do what scope covers. Admin keys may do everything
+func Allows(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// window counts the requests of a key within one minute
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter limits the requests of each key to a number per minute, in windows starting at the first
+// request of each minute. It is kept in memory, so every server counts on its own
+type Limiter struct {
+	mu      sync.Mutex
+	windows map[int]window
+}
+
+// NewLimiter creates a limiter
+func NewLimiter() *Limiter {
+	return &Limiter{windows: make(map[int]window)}
+}
+
+// Allow counts a request of key id at now against limit requests per minute. It reports whether the
+// request may go ahead, how many are left in the window and when the window ends. A limit of 0 or less
+// is no limit
+func (l *Limiter) Allow(id, limit int, now time.Time) (bool, int, time.Time) {
+	if limit <= 0 {
+		return true, 0, time.Time{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w := l.windows[id]
+	if now.Sub(w.start) >= time.Minute {
+		w = window{start: now}
+	}
+	reset := w.start.Add(time.Minute)
+
+	if w.count >= limit {
+		return false, 0, reset
+	}
+
+	w.count++
+	l.windows[id] = w
+	return true, limit - w.count, reset
+}
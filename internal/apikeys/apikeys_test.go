@@ -0,0 +1,92 @@
+package apikeys
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, marker) || !strings.HasPrefix(key, prefix) || len(prefix) != PrefixLength {
+		t.Errorf("wrong key %s with prefix %s", key, prefix)
+	}
+	if hash != Hash(key) || len(hash) != 64 || strings.Contains(hash, key) {
+		t.Errorf("wrong hash %s", hash)
+	}
+
+	other, _, _, _ := Generate()
+	if other == key {
+		t.Error("expected a different key each time")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	var tests = []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"bearer", "Authorization", "Bearer bk_123", "bk_123"},
+		{"lower case bearer", "Authorization", "bearer bk_123", "bk_123"},
+		{"key header", Header, " bk_123 ", "bk_123"},
+		{"basic auth", "Authorization", "Basic dXNlcjpwYXNz", ""},
+		{"none", "Accept", "application/json", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(tt.header, tt.value)
+
+		if got := FromRequest(r); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	if !Allows([]string{ScopeAvailability}, ScopeAvailability) {
+		t.Error("expected a key to have its own scope")
+	}
+	if Allows([]string{ScopeAvailability}, ScopeReservations) {
+		t.Error("expected a read key not to create reservations")
+	}
+	if !Allows([]string{ScopeAdmin}, ScopeReservations) {
+		t.Error("expected an admin key to do everything")
+	}
+	if Allows(nil, ScopeAvailability) {
+		t.Error("expected a key without scopes to do nothing")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter()
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 2; i >= 0; i-- {
+		ok, left, _ := l.Allow(1, 3, now)
+		if !ok || left != i {
+			t.Fatalf("expected %d left, got %v %d", i, ok, left)
+		}
+	}
+
+	ok, _, reset := l.Allow(1, 3, now.Add(30*time.Second))
+	if ok || !reset.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected the fourth request refused until %s, got %v %s", now.Add(time.Minute), ok, reset)
+	}
+	if ok, _, _ := l.Allow(2, 3, now); !ok {
+		t.Error("expected keys to be counted apart")
+	}
+	if ok, left, _ := l.Allow(1, 3, now.Add(time.Minute)); !ok || left != 2 {
+		t.Errorf("expected a new window after a minute, got %v %d", ok, left)
+	}
+	if ok, _, _ := l.Allow(3, 0, now); !ok {
+		t.Error("expected no limit for a limit of 0")
+	}
+}
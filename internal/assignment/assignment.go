@@ -0,0 +1,148 @@
+package assignment
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Window is how many days around a new stay are looked at when scoring a room
+const Window = 30
+
+// ShortGap is the longest run of free nights considered hard to sell
+const ShortGap = 3
+
+// ErrNoRoom is returned when no room of the type is free for the whole stay
+var ErrNoRoom = errors.New("no room of this type is available")
+
+// Stay is a span already taken on a room calendar. End is the departure day
+type Stay struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Candidate is a physical room with what is already on its calendar around the new stay
+type Candidate struct {
+	Room  models.Room
+	Stays []Stay
+}
+
+// StaysFrom converts room restrictions, reservations and blocks alike, into stays
+func StaysFrom(restrictions []models.RoomRestriction) []Stay {
+	stays := make([]Stay, 0, len(restrictions))
+	for _, r := range restrictions {
+		stays = append(stays, Stay{Start: r.StartDate, End: r.EndDate})
+	}
+	return stays
+}
+
+// Free reports whether nothing on the candidate calendar overlaps a stay from start to end
+func (c Candidate) Free(start, end time.Time) bool {
+	for _, s := range c.Stays {
+		if start.Before(s.End) && end.After(s.Start) {
+			return false
+		}
+	}
+	return true
+}
+
+// Pick returns the free candidate whose calendar is left least fragmented by a stay from start to end.
+// A stay that sits right against existing ones is best, one that leaves a short gap of free nights is worst.
+// Ties go to the first candidate
+func Pick(candidates []Candidate, start, end time.Time) (models.Room, error) {
+	best := -1
+	bestCost := 0
+
+	for i, c := range candidates {
+		if !c.Free(start, end) {
+			continue
+		}
+
+		cost := c.cost(start, end)
+		if best < 0 || cost < bestCost {
+			best = i
+			bestCost = cost
+		}
+	}
+
+	if best < 0 {
+		return models.Room{}, ErrNoRoom
+	}
+
+	return candidates[best].Room, nil
+}
+
+// cost scores the free nights left on each side of the stay
+func (c Candidate) cost(start, end time.Time) int {
+	before, after := -1, -1
+
+	for _, s := range c.Stays {
+		if !s.End.After(start) {
+			if g := nights(s.End, start); before < 0 || g < before {
+				before = g
+			}
+		}
+		if !s.Start.Before(end) {
+			if g := nights(end, s.Start); after < 0 || g < after {
+				after = g
+			}
+		}
+	}
+
+	return gapCost(before) + gapCost(after)
+}
+
+// gapCost is 0 for no gap, 10 for a short gap and 1 for a long or open ended one (-1)
+func gapCost(gap int) int {
+	switch {
+	case gap == 0:
+		return 0
+	case gap > 0 && gap <= ShortGap:
+		return 10
+	}
+	return 1
+}
+
+func nights(from, to time.Time) int {
+	return int(to.Sub(from).Round(24*time.Hour).Hours() / 24)
+}
+
+// Offer is what a search shows for one room type
+type Offer struct {
+	RoomType     models.RoomType
+	Available    int
+	FromPrice    int
+	MaxOccupancy int
+}
+
+// Offers groups available rooms by type, in order of type name
+func Offers(rooms []models.Room) []Offer {
+	var offers []Offer
+	index := make(map[int]int)
+
+	for _, r := range rooms {
+		i, ok := index[r.RoomTypeId]
+		if !ok {
+			offers = append(offers, Offer{RoomType: r.RoomType, FromPrice: r.Price})
+			i = len(offers) - 1
+			index[r.RoomTypeId] = i
+		}
+
+		o := &offers[i]
+		o.Available++
+		if r.Price < o.FromPrice {
+			o.FromPrice = r.Price
+		}
+		if r.MaxOccupancy > o.MaxOccupancy {
+			o.MaxOccupancy = r.MaxOccupancy
+		}
+	}
+
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offers[i].RoomType.Name < offers[j].RoomType.Name
+	})
+
+	return offers
+}
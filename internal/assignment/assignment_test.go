@@ -0,0 +1,103 @@
+package assignment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestPick(t *testing.T) {
+	start, end := datestest.Day(t, "2050-01-10"), datestest.Day(t, "2050-01-12")
+
+	var tests = []struct {
+		name       string
+		candidates []Candidate
+		expected   int
+	}{
+		{
+			"empty calendars go to the first room",
+			[]Candidate{{Room: models.Room{ID: 1}}, {Room: models.Room{ID: 2}}},
+			1,
+		},
+		{
+			"booked room is skipped",
+			[]Candidate{
+				{Room: models.Room{ID: 1}, Stays: []Stay{{datestest.Day(t, "2050-01-11"), datestest.Day(t, "2050-01-13")}}},
+				{Room: models.Room{ID: 2}},
+			},
+			2,
+		},
+		{
+			"stay next to an existing one is preferred",
+			[]Candidate{
+				{Room: models.Room{ID: 1}},
+				{Room: models.Room{ID: 2}, Stays: []Stay{{datestest.Day(t, "2050-01-08"), datestest.Day(t, "2050-01-10")}}},
+			},
+			2,
+		},
+		{
+			"short gaps are avoided",
+			[]Candidate{
+				{Room: models.Room{ID: 1}, Stays: []Stay{{datestest.Day(t, "2050-01-05"), datestest.Day(t, "2050-01-08")}}},
+				{Room: models.Room{ID: 2}, Stays: []Stay{{datestest.Day(t, "2050-01-01"), datestest.Day(t, "2050-01-03")}}},
+			},
+			2,
+		},
+		{
+			"filling a gap exactly beats everything",
+			[]Candidate{
+				{Room: models.Room{ID: 1}, Stays: []Stay{{datestest.Day(t, "2050-01-08"), datestest.Day(t, "2050-01-10")}}},
+				{Room: models.Room{ID: 2}, Stays: []Stay{
+					{datestest.Day(t, "2050-01-08"), datestest.Day(t, "2050-01-10")},
+					{datestest.Day(t, "2050-01-12"), datestest.Day(t, "2050-01-14")},
+				}},
+			},
+			2,
+		},
+	}
+
+	for _, e := range tests {
+		room, err := Pick(e.candidates, start, end)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if room.ID != e.expected {
+			t.Errorf("%s: expected room %d, got %d", e.name, e.expected, room.ID)
+		}
+	}
+}
+
+func TestPick_NoRoom(t *testing.T) {
+	candidates := []Candidate{
+		{Room: models.Room{ID: 1}, Stays: []Stay{{datestest.Day(t, "2050-01-09"), datestest.Day(t, "2050-01-11")}}},
+	}
+
+	_, err := Pick(candidates, datestest.Day(t, "2050-01-10"), datestest.Day(t, "2050-01-12"))
+	if !errors.Is(err, ErrNoRoom) {
+		t.Errorf("expected ErrNoRoom, got %v", err)
+	}
+}
+
+func TestOffers(t *testing.T) {
+	double := models.RoomType{ID: 1, Name: "Standard Double"}
+	suite := models.RoomType{ID: 2, Name: "Suite"}
+
+	offers := Offers([]models.Room{
+		{ID: 1, RoomTypeId: 2, RoomType: suite, Price: 20000, MaxOccupancy: 4},
+		{ID: 2, RoomTypeId: 1, RoomType: double, Price: 9000, MaxOccupancy: 2},
+		{ID: 3, RoomTypeId: 1, RoomType: double, Price: 8500, MaxOccupancy: 2},
+	})
+
+	if len(offers) != 2 {
+		t.Fatalf("expected 2 offers, got %d", len(offers))
+	}
+	if offers[0].RoomType.ID != 1 || offers[0].Available != 2 || offers[0].FromPrice != 8500 {
+		t.Errorf("wrong offer for double: %+v", offers[0])
+	}
+	if offers[1].MaxOccupancy != 4 {
+		t.Errorf("wrong offer for suite: %+v", offers[1])
+	}
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// Actions
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionProcess = "process"
+	ActionRefund  = "refund"
+	ActionVoid    = "void"
+	ActionMerge   = "merge"
+	ActionCancel  = "cancel"
+	ActionRevoke  = "revoke"
+)
+
+// Actions lists the actions the audit log can be filtered by
+var Actions = []string{ActionCreate, ActionUpdate, ActionDelete, ActionProcess, ActionRefund, ActionVoid, ActionMerge, ActionCancel, ActionRevoke}
+
+// Entities
+const (
+	EntityReservation = "reservation"
+	EntityNote        = "reservation_note"
+	EntityGroup       = "reservation_group"
+	EntityBlock       = "block"
+	EntityFolioEntry  = "folio_entry"
+	EntityPayment     = "payment"
+	EntityRoom        = "room"
+	EntityRoomType    = "room_type"
+	EntityPromoCode   = "promo_code"
+	EntityTaxFeeRule  = "tax_fee_rule"
+	EntityProperty    = "property"
+	EntityGuest       = "guest"
+	EntityTask        = "housekeeping_task"
+	EntityICalSource  = "ical_source"
+	EntityAPIKey      = "api_key"
+)
+
+// Entities lists the entities the audit log can be filtered by
+var Entities = []string{
+	EntityReservation, EntityNote, EntityGroup, EntityBlock, EntityFolioEntry, EntityPayment, EntityRoom,
+	EntityRoomType, EntityPromoCode, EntityTaxFeeRule, EntityProperty, EntityGuest, EntityTask, EntityICalSource,
+	EntityAPIKey,
+}
+
+// Snapshot encodes the state of an entity as JSON for the before and after columns. Nothing, as for
+// the before of a create, is stored as ""
+func Snapshot(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+// Changed returns the top level fields whose value differs between two snapshots, in order of name.
+// Every field of the other snapshot is returned when one of them is empty
+func Changed(before, after string) []string {
+	var b, a map[string]json.RawMessage
+	_ = json.Unmarshal([]byte(before), &b)
+	_ = json.Unmarshal([]byte(after), &a)
+
+	seen := make(map[string]bool)
+	var fields []string
+	for _, m := range []map[string]json.RawMessage{b, a} {
+		for k := range m {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			if string(b[k]) != string(a[k]) {
+				fields = append(fields, k)
+			}
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+// IP returns the address a request came from, without its port
+func IP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestSnapshot(t *testing.T) {
+	if got := Snapshot(nil); got != "" {
+		t.Errorf("expected empty snapshot for nil, got %q", got)
+	}
+
+	got := Snapshot(struct {
+		ID   int
+		Name string
+	}{1, "Suite"})
+	if got != `{"ID":1,"Name":"Suite"}` {
+		t.Errorf("unexpected snapshot %s", got)
+	}
+
+	got = Snapshot(models.Reservation{ID: 1, ManageToken: "secret"})
+	if strings.Contains(got, "secret") || strings.Contains(got, "ManageToken") {
+		t.Errorf("expected the manage token left out, got %s", got)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	var tests = []struct {
+		name     string
+		before   string
+		after    string
+		expected []string
+	}{
+		{"same", `{"A":1,"B":"x"}`, `{"A":1,"B":"x"}`, nil},
+		{"one field", `{"A":1,"B":"x"}`, `{"A":2,"B":"x"}`, []string{"A"}},
+		{"created", "", `{"B":1,"A":2}`, []string{"A", "B"}},
+		{"deleted", `{"A":1}`, "", []string{"A"}},
+		{"field added", `{"A":1}`, `{"A":1,"C":3}`, []string{"C"}},
+	}
+
+	for _, e := range tests {
+		got := Changed(e.before, e.after)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/admin/dashboard", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+
+	if got := IP(r); got != "203.0.113.7" {
+		t.Errorf("expected 203.0.113.7, got %s", got)
+	}
+
+	r.RemoteAddr = "[2001:db8::1]:443"
+	if got := IP(r); got != "2001:db8::1" {
+		t.Errorf("expected 2001:db8::1, got %s", got)
+	}
+}
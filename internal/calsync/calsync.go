@@ -0,0 +1,190 @@
+// Package calsync keeps the blocks of a room in step with the outside calendars it is listed on. Every
+// event of a calendar becomes a block of the room, and the block goes again once the event is removed
+package calsync
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/ical"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// RestrictionExternal is the restriction id of the blocks synced from outside calendars
+const RestrictionExternal = 4
+
+// Statuses of a sync
+const (
+	StatusOK       = "ok"
+	StatusConflict = "conflict"
+	StatusError    = "error"
+)
+
+// maxFeedSize is the largest calendar read from a URL
+const maxFeedSize = 5 << 20
+
+// Client fetches calendar URLs. A platform that does not answer in time is tried again on the next sync
+var Client = &http.Client{Timeout: 30 * time.Second}
+
+// Plan is what a sync changes on the calendar of a room. Update holds blocks with their new dates
+type Plan struct {
+	Add    []models.RoomRestriction
+	Update []models.RoomRestriction
+	Remove []int
+}
+
+// Store is what a sync reads from and writes to
+type Store interface {
+	ExternalBlocks(sourceId int) ([]models.RoomRestriction, error)
+	ApplyCalendarSync(sourceId int, add, update []models.RoomRestriction, remove []int) error
+	GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error)
+	InsertICalSyncLog(entry models.ICalSyncLog) error
+}
+
+// Reconcile works out the plan that makes the blocks of source match its events. Blocks and events that
+// end by today are left alone, calendars often drop their past events
+func Reconcile(source models.ICalSource, existing []models.RoomRestriction, events []ical.Event, today time.Time) Plan {
+	var plan Plan
+
+	wanted := make(map[string]ical.Event)
+	var keys []string
+	for _, e := range events {
+		if !e.End.After(today) {
+			continue
+		}
+		k := key(e)
+		if _, ok := wanted[k]; ok {
+			// instances of a recurring event share their uid
+			k = fmt.Sprintf("%s/%s", k, e.Start.Format(dates.Layout))
+		}
+		wanted[k] = e
+		keys = append(keys, k)
+	}
+
+	for _, rr := range existing {
+		if !rr.EndDate.After(today) {
+			continue
+		}
+		e, ok := wanted[rr.ExternalUID]
+		if !ok {
+			plan.Remove = append(plan.Remove, rr.ID)
+			continue
+		}
+		delete(wanted, rr.ExternalUID)
+		if !e.Start.Equal(rr.StartDate) || !e.End.Equal(rr.EndDate) {
+			rr.StartDate, rr.EndDate = e.Start, e.End
+			plan.Update = append(plan.Update, rr)
+		}
+	}
+
+	for _, k := range keys {
+		e, ok := wanted[k]
+		if !ok {
+			continue
+		}
+		plan.Add = append(plan.Add, models.RoomRestriction{
+			RoomId:        source.RoomId,
+			StartDate:     e.Start,
+			EndDate:       e.End,
+			RestrictionId: RestrictionExternal,
+			ICalSourceId:  source.ID,
+			ExternalUID:   k,
+		})
+	}
+	sort.SliceStable(plan.Add, func(i, j int) bool {
+		return plan.Add[i].StartDate.Before(plan.Add[j].StartDate)
+	})
+
+	return plan
+}
+
+// key identifies an event from one sync to the next
+func key(e ical.Event) string {
+	if e.UID != "" {
+		return e.UID
+	}
+	return fmt.Sprintf("%s/%s", e.Start.Format(dates.Layout), e.End.Format(dates.Layout))
+}
+
+// Fetch downloads the events of a calendar URL
+func Fetch(client *http.Client, url string) ([]ical.Event, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("%q is not an http URL", url)
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calendar URL answered %s", resp.Status)
+	}
+
+	return ical.Parse(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+// Run syncs source with the events load returns, from its URL or an uploaded file, and logs the outcome.
+// Blocks that now overlap other rows of the room calendar, most likely a booking made here, are reported
+// as conflicts but kept, the room is taken on the other platform either way
+func Run(store Store, source models.ICalSource, today time.Time, load func() ([]ical.Event, error)) (models.ICalSyncLog, error) {
+	entry := models.ICalSyncLog{ICalSourceId: source.ID, Status: StatusOK}
+
+	conflicts, err := run(store, source, today, load, &entry)
+	switch {
+	case err != nil:
+		entry.Status = StatusError
+		entry.Message = err.Error()
+	case len(conflicts) > 0:
+		entry.Status = StatusConflict
+		entry.Message = strings.Join(conflicts, "; ")
+	}
+
+	return entry, store.InsertICalSyncLog(entry)
+}
+
+func run(store Store, source models.ICalSource, today time.Time, load func() ([]ical.Event, error), entry *models.ICalSyncLog) ([]string, error) {
+	events, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := store.ExternalBlocks(source.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := Reconcile(source, existing, events, today)
+	err = store.ApplyCalendarSync(source.ID, plan.Add, plan.Update, plan.Remove)
+	if err != nil {
+		return nil, err
+	}
+	entry.Added, entry.Updated, entry.Removed = len(plan.Add), len(plan.Update), len(plan.Remove)
+
+	var conflicts []string
+	for _, rr := range append(plan.Add, plan.Update...) {
+		stay := dates.Range{Start: rr.StartDate, End: rr.EndDate}
+		taken, err := store.GetRestrictionsForRoomByDate(source.RoomId, stay)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range taken {
+			if other.ICalSourceId == source.ID {
+				continue
+			}
+			what := fmt.Sprintf("block %d", other.ID)
+			if other.ReservationId > 0 {
+				what = fmt.Sprintf("reservation %d", other.ReservationId)
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s overlaps %s", stay, what))
+		}
+	}
+
+	return conflicts, nil
+}
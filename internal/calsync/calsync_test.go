@@ -0,0 +1,140 @@
+package calsync
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/ical"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+var source = models.ICalSource{ID: 3, RoomId: 1, Name: "Other"}
+
+func block(t testing.TB, id int, uid, start, end string) models.RoomRestriction {
+	t.Helper()
+	return models.RoomRestriction{ID: id, RoomId: 1, StartDate: datestest.Day(t, start), EndDate: datestest.Day(t, end),
+		RestrictionId: RestrictionExternal, ICalSourceId: source.ID, ExternalUID: uid}
+}
+
+func TestReconcile(t *testing.T) {
+	today := datestest.Day(t, "2050-01-10")
+	existing := []models.RoomRestriction{
+		block(t, 1, "kept", "2050-01-12", "2050-01-14"),
+		block(t, 2, "moved", "2050-01-15", "2050-01-17"),
+		block(t, 3, "gone", "2050-01-20", "2050-01-22"),
+		// over, left alone though the feed dropped it
+		block(t, 4, "past", "2050-01-01", "2050-01-03"),
+	}
+	events := []ical.Event{
+		{UID: "kept", Start: datestest.Day(t, "2050-01-12"), End: datestest.Day(t, "2050-01-14")},
+		{UID: "moved", Start: datestest.Day(t, "2050-01-16"), End: datestest.Day(t, "2050-01-18")},
+		{UID: "new", Start: datestest.Day(t, "2050-02-01"), End: datestest.Day(t, "2050-02-03")},
+		{Start: datestest.Day(t, "2050-01-25"), End: datestest.Day(t, "2050-01-26")},
+		{UID: "old", Start: datestest.Day(t, "2050-01-05"), End: datestest.Day(t, "2050-01-07")},
+	}
+
+	plan := Reconcile(source, existing, events, today)
+
+	if len(plan.Update) != 1 || plan.Update[0].ID != 2 || plan.Update[0].StartDate != datestest.Day(t, "2050-01-16") {
+		t.Errorf("expected block 2 to move, got %+v", plan.Update)
+	}
+	if len(plan.Remove) != 1 || plan.Remove[0] != 3 {
+		t.Errorf("expected block 3 to go, got %v", plan.Remove)
+	}
+	if len(plan.Add) != 2 {
+		t.Fatalf("expected 2 new blocks, got %+v", plan.Add)
+	}
+	if plan.Add[0].ExternalUID != "2050-01-25/2050-01-26" || plan.Add[1].ExternalUID != "new" {
+		t.Errorf("expected the new blocks by date, got %+v", plan.Add)
+	}
+	if plan.Add[1].RestrictionId != RestrictionExternal || plan.Add[1].ICalSourceId != source.ID || plan.Add[1].RoomId != 1 {
+		t.Errorf("wrong new block %+v", plan.Add[1])
+	}
+
+	// a second sync changes nothing
+	again := Reconcile(source, append(existing[:2], plan.Add...), events, today)
+	if len(again.Add) != 0 || len(again.Remove) != 0 || len(again.Update) != 1 {
+		t.Errorf("expected only the unsaved move, got %+v", again)
+	}
+}
+
+type store struct {
+	existing []models.RoomRestriction
+	calendar []models.RoomRestriction
+	plan     Plan
+	log      []models.ICalSyncLog
+}
+
+func (s *store) ExternalBlocks(sourceId int) ([]models.RoomRestriction, error) {
+	return s.existing, nil
+}
+
+func (s *store) ApplyCalendarSync(sourceId int, add, update []models.RoomRestriction, remove []int) error {
+	s.plan = Plan{Add: add, Update: update, Remove: remove}
+	return nil
+}
+
+func (s *store) GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error) {
+	var found []models.RoomRestriction
+	for _, rr := range s.calendar {
+		if period.Overlaps(dates.Range{Start: rr.StartDate, End: rr.EndDate}) {
+			found = append(found, rr)
+		}
+	}
+	return found, nil
+}
+
+func (s *store) InsertICalSyncLog(entry models.ICalSyncLog) error {
+	s.log = append(s.log, entry)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	s := &store{calendar: []models.RoomRestriction{
+		{ID: 9, RoomId: 1, ReservationId: 5, StartDate: datestest.Day(t, "2050-01-02"), EndDate: datestest.Day(t, "2050-01-04"), RestrictionId: 1},
+	}}
+	events := []ical.Event{{UID: "a", Start: datestest.Day(t, "2050-01-03"), End: datestest.Day(t, "2050-01-05")}}
+
+	entry, err := Run(s, source, datestest.Day(t, "2050-01-01"), func() ([]ical.Event, error) { return events, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != StatusConflict || entry.Added != 1 || len(s.plan.Add) != 1 {
+		t.Errorf("expected a conflicting new block, got %+v", entry)
+	}
+
+	entry, _ = Run(s, source, datestest.Day(t, "2050-01-01"), func() ([]ical.Event, error) { return nil, ical.ErrNotCalendar })
+	if entry.Status != StatusError || entry.Message != ical.ErrNotCalendar.Error() || len(s.log) != 2 {
+		t.Errorf("expected a logged error, got %+v", entry)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/room.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART;VALUE=DATE:20500101\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer srv.Close()
+
+	events, err := Fetch(srv.Client(), srv.URL+"/room.ics")
+	if err != nil || len(events) != 1 {
+		t.Errorf("expected an event, got %+v %v", events, err)
+	}
+
+	_, err = Fetch(srv.Client(), srv.URL+"/missing.ics")
+	if err == nil {
+		t.Error("expected an error for a missing calendar")
+	}
+
+	_, err = Fetch(srv.Client(), "file:///etc/passwd")
+	if err == nil || errors.Is(err, ical.ErrNotCalendar) {
+		t.Errorf("expected only http URLs, got %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+// Package channels connects properties to channel managers and online travel agencies. A channel is sent
+// the availability and rates of every room type and hands back the bookings made on it, which are booked
+// here the same way as bookings made on the site
+package channels
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eldicela/bookings/internal/assignment"
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/folio"
+	"github.com/eldicela/bookings/internal/guests"
+	"github.com/eldicela/bookings/internal/helpers"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/pricing"
+	"github.com/eldicela/bookings/internal/repository"
+	"github.com/eldicela/bookings/internal/timeline"
+)
+
+// Horizon is how many days ahead availability and rates are sent
+const Horizon = 365
+
+// Availability is how many rooms of a type are free on the night starting on Day
+type Availability struct {
+	RoomTypeId int
+	RoomType   string
+	Day        time.Time
+	Available  int
+}
+
+// Rate is the price of a night in a room type, the lowest price of its rooms
+type Rate struct {
+	RoomTypeId int
+	RoomType   string
+	Day        time.Time
+	Amount     int
+}
+
+// Booking is a reservation made on a channel. Ref is the channel's own id for it, Amount is what the
+// channel charged the guest, 0 to price the stay here
+type Booking struct {
+	Ref        string
+	RoomTypeId int
+	FirstName  string
+	LastName   string
+	Email      string
+	Phone      string
+	Arrival    time.Time
+	Departure  time.Time
+	Adults     int
+	Children   int
+	Amount     int
+}
+
+// Ack tells a channel what became of a booking, the reservation it was booked as or why it was refused
+type Ack struct {
+	Ref           string
+	ReservationId int
+	Reason        string
+}
+
+// Accepted reports whether the booking was booked
+func (a Ack) Accepted() bool {
+	return a.ReservationId > 0
+}
+
+// Channel is implemented by every channel manager or travel agency a property sells on. Bookings pulled
+// are acknowledged one by one, a booking that is not acknowledged is pulled again on the next sync
+type Channel interface {
+	Name() string
+	PushAvailability(property models.Property, days []Availability) error
+	PushRates(property models.Property, rates []Rate) error
+	PullReservations(property models.Property) ([]Booking, error)
+	Acknowledge(property models.Property, ack Ack) error
+}
+
+// Store is what a sync reads from and writes to
+type Store interface {
+	AllRooms(propertyId int) ([]models.Room, error)
+	GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error)
+	GetReservationByChannelRef(channel, ref string) (models.Reservation, error)
+	ActiveTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error)
+	FindOrCreateGuest(g models.Guest) (int, error)
+	CreateReservations(name string, lines []models.Reservation, charges []models.FolioEntry, payments []models.Payment) ([]models.Reservation, error)
+	InsertReservationEvent(e models.ReservationEvent) error
+}
+
+// Result is the outcome of a sync
+type Result struct {
+	Accepted   int
+	Duplicates int
+	Rejected   []Ack
+}
+
+// Inventory works out the availability and rates of every room type from calendar, the rows on the
+// calendar of each room, for the nights of period. Rooms without a type are not sold on channels
+func Inventory(rooms []models.Room, calendar map[int][]models.RoomRestriction, period dates.Range) ([]Availability, []Rate) {
+	var availability []Availability
+	var rates []Rate
+
+	var types []int
+	byType := make(map[int][]models.Room)
+	for _, room := range rooms {
+		if room.RoomTypeId == 0 {
+			continue
+		}
+		if _, ok := byType[room.RoomTypeId]; !ok {
+			types = append(types, room.RoomTypeId)
+		}
+		byType[room.RoomTypeId] = append(byType[room.RoomTypeId], room)
+	}
+
+	for _, typeId := range types {
+		typeRooms := byType[typeId]
+		for _, day := range period.Days() {
+			night := dates.Range{Start: day, End: day.AddDate(0, 0, 1)}
+
+			free := 0
+			price := typeRooms[0].Price
+			for _, room := range typeRooms {
+				if room.Price < price {
+					price = room.Price
+				}
+				c := assignment.Candidate{Room: room, Stays: assignment.StaysFrom(calendar[room.ID])}
+				if c.Free(night.Start, night.End) {
+					free++
+				}
+			}
+
+			name := typeRooms[0].RoomType.Name
+			availability = append(availability, Availability{RoomTypeId: typeId, RoomType: name, Day: day, Available: free})
+			rates = append(rates, Rate{RoomTypeId: typeId, RoomType: name, Day: day, Amount: price})
+		}
+	}
+
+	return availability, rates
+}
+
+// Run syncs a property with ch. Bookings made on the channel are pulled and booked first, with the same
+// atomic reservation creation as bookings made on the site, so that the availability pushed afterwards
+// already counts them. A booking pulled twice is acknowledged again with the reservation it was booked as
+func Run(store Store, ch Channel, property models.Property) (Result, error) {
+	var result Result
+
+	bookings, err := ch.PullReservations(property)
+	if err != nil {
+		return result, fmt.Errorf("cannot pull reservations from %s: %w", ch.Name(), err)
+	}
+
+	for _, b := range bookings {
+		ack, duplicate, err := book(store, ch.Name(), property, b)
+		if err != nil {
+			return result, err
+		}
+
+		err = ch.Acknowledge(property, ack)
+		if err != nil {
+			return result, fmt.Errorf("cannot acknowledge %s booking %s: %w", ch.Name(), b.Ref, err)
+		}
+
+		switch {
+		case duplicate:
+			result.Duplicates++
+		case ack.Accepted():
+			result.Accepted++
+		default:
+			result.Rejected = append(result.Rejected, ack)
+		}
+	}
+
+	rooms, err := store.AllRooms(property.ID)
+	if err != nil {
+		return result, err
+	}
+
+	today := property.Today()
+	period := dates.Range{Start: today, End: today.AddDate(0, 0, Horizon)}
+	calendar := make(map[int][]models.RoomRestriction, len(rooms))
+	for _, room := range rooms {
+		calendar[room.ID], err = store.GetRestrictionsForRoomByDate(room.ID, period)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	availability, rates := Inventory(rooms, calendar, period)
+	err = ch.PushAvailability(property, availability)
+	if err != nil {
+		return result, fmt.Errorf("cannot push availability to %s: %w", ch.Name(), err)
+	}
+	err = ch.PushRates(property, rates)
+	if err != nil {
+		return result, fmt.Errorf("cannot push rates to %s: %w", ch.Name(), err)
+	}
+
+	return result, nil
+}
+
+// book books a channel booking in the least fragmenting free room of its type. Bookings that cannot be
+// booked are refused with the reason in the ack, errors are only returned when the store fails
+func book(store Store, channel string, property models.Property, b Booking) (Ack, bool, error) {
+	ack := Ack{Ref: b.Ref}
+
+	existing, err := store.GetReservationByChannelRef(channel, b.Ref)
+	if err == nil {
+		ack.ReservationId = existing.ID
+		return ack, true, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return ack, false, err
+	}
+
+	res := models.Reservation{
+		FirstName:  b.FirstName,
+		LastName:   b.LastName,
+		Email:      b.Email,
+		Phone:      b.Phone,
+		StartDate:  b.Arrival,
+		EndDate:    b.Departure,
+		Adults:     b.Adults,
+		Children:   b.Children,
+		RoomTypeId: b.RoomTypeId,
+		Channel:    channel,
+		ChannelRef: b.Ref,
+	}
+
+	if reason := check(res); reason != "" {
+		ack.Reason = reason
+		return ack, false, nil
+	}
+
+	room, err := pick(store, property, res)
+	if errors.Is(err, assignment.ErrNoRoom) {
+		ack.Reason = err.Error()
+		return ack, false, nil
+	} else if err != nil {
+		return ack, false, err
+	}
+	res.RoomId = room.ID
+	res.Room = room
+
+	res.GuestId, err = store.FindOrCreateGuest(guests.FromReservation(res))
+	if err != nil {
+		return ack, false, err
+	}
+	res.ManageToken, err = helpers.RandomToken(16)
+	if err != nil {
+		return ack, false, err
+	}
+
+	lines, err := charges(store, channel, res, b.Amount)
+	if err != nil {
+		return ack, false, err
+	}
+	entries := make([]models.FolioEntry, len(lines))
+	for i, line := range lines {
+		entries[i] = models.FolioEntry{
+			EntryType:    folio.TypeCharge,
+			Category:     line.Category,
+			Description:  line.Description,
+			Amount:       line.Amount,
+			TaxFeeRuleId: line.RuleId,
+		}
+	}
+
+	// the charges are written with the reservation, so a booking is never left without them
+	booked, err := store.CreateReservations("", []models.Reservation{res}, entries, nil)
+	if errors.Is(err, repository.ErrUnavailable) {
+		// booked on the site since the room was picked
+		ack.Reason = assignment.ErrNoRoom.Error()
+		return ack, false, nil
+	} else if err != nil {
+		return ack, false, err
+	}
+	res = booked[0]
+	ack.ReservationId = res.ID
+
+	err = store.InsertReservationEvent(models.ReservationEvent{
+		ReservationId: res.ID,
+		EventType:     timeline.EventCreated,
+		Description:   fmt.Sprintf("Booked on %s as %s, %s for %s", channel, b.Ref, room.RoomName, res.Stay()),
+	})
+	if err != nil {
+		return ack, false, err
+	}
+
+	return ack, false, nil
+}
+
+// check returns why a booking cannot be booked, or an empty string when it can
+func check(res models.Reservation) string {
+	switch {
+	case res.ChannelRef == "":
+		return "booking has no reference"
+	case res.FirstName == "" || res.LastName == "":
+		return "guest name is missing"
+	case res.StartDate.IsZero() || !res.EndDate.After(res.StartDate):
+		return dates.ErrEmptyRange.Error()
+	case res.Adults < 1 || res.Children < 0:
+		return "party must have at least one adult"
+	}
+	return ""
+}
+
+// pick returns the room of the property the booking goes in, the way rooms of bookings made on the site
+// are assigned
+func pick(store Store, property models.Property, res models.Reservation) (models.Room, error) {
+	rooms, err := store.AllRooms(property.ID)
+	if err != nil {
+		return models.Room{}, err
+	}
+
+	around := dates.Range{
+		Start: res.StartDate.AddDate(0, 0, -assignment.Window),
+		End:   res.EndDate.AddDate(0, 0, assignment.Window),
+	}
+
+	var candidates []assignment.Candidate
+	for _, room := range rooms {
+		if room.RoomTypeId != res.RoomTypeId {
+			continue
+		}
+		if room.MaxOccupancy > 0 && res.Guests() > room.MaxOccupancy {
+			continue
+		}
+
+		restrictions, err := store.GetRestrictionsForRoomByDate(room.ID, around)
+		if err != nil {
+			return models.Room{}, err
+		}
+
+		candidates = append(candidates, assignment.Candidate{
+			Room:  room,
+			Stays: assignment.StaysFrom(restrictions),
+		})
+	}
+
+	return assignment.Pick(candidates, res.StartDate, res.EndDate)
+}
+
+// charges returns the folio charges of a channel booking. The amount the channel charged is posted as it
+// is, taxes included, otherwise the stay is priced like a booking made on the site
+func charges(store Store, channel string, res models.Reservation, amount int) ([]pricing.Line, error) {
+	if amount > 0 {
+		return []pricing.Line{{
+			Description: fmt.Sprintf("%s, %d night(s), booked on %s", res.Room.RoomName, res.Stay().Nights(), channel),
+			Category:    folio.CategoryRoom,
+			Amount:      amount,
+		}}, nil
+	}
+
+	quote := pricing.NewQuote(res.Room, res.StartDate, res.EndDate)
+	quote.ApplyExtraGuests(res.Room, res.Guests())
+
+	rules, err := store.ActiveTaxFeeRules(res.Room.PropertyId)
+	if err != nil {
+		return nil, err
+	}
+	quote.ApplyTaxesAndFees(rules, res.Guests())
+
+	return quote.Lines, nil
+}
@@ -0,0 +1,199 @@
+package channels
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/repository"
+)
+
+var rooms = []models.Room{
+	{ID: 1, RoomName: "Garden 1", Price: 9000, MaxOccupancy: 2, RoomTypeId: 7, RoomType: models.RoomType{Name: "Garden"}},
+	{ID: 2, RoomName: "Garden 2", Price: 8000, MaxOccupancy: 2, RoomTypeId: 7, RoomType: models.RoomType{Name: "Garden"}},
+	{ID: 3, RoomName: "Attic", Price: 5000},
+}
+
+func TestInventory(t *testing.T) {
+	calendar := map[int][]models.RoomRestriction{
+		1: {{RoomId: 1, StartDate: datestest.Day(t, "2050-01-01"), EndDate: datestest.Day(t, "2050-01-03")}},
+		2: {{RoomId: 2, StartDate: datestest.Day(t, "2050-01-02"), EndDate: datestest.Day(t, "2050-01-03")}},
+	}
+	period := dates.Range{Start: datestest.Day(t, "2050-01-01"), End: datestest.Day(t, "2050-01-04")}
+
+	availability, rates := Inventory(rooms, calendar, period)
+
+	if len(availability) != 3 || len(rates) != 3 {
+		t.Fatalf("expected 3 nights of the one typed room type, got %+v %+v", availability, rates)
+	}
+	for i, want := range []int{1, 0, 2} {
+		if availability[i].Available != want {
+			t.Errorf("expected %d free on %s, got %d", want, availability[i].Day.Format(dates.Layout), availability[i].Available)
+		}
+	}
+	if rates[0].Amount != 8000 || rates[0].RoomType != "Garden" {
+		t.Errorf("expected the lowest price of the type, got %+v", rates[0])
+	}
+}
+
+type store struct {
+	calendar     []models.RoomRestriction
+	reservations []models.Reservation
+	folio        []models.FolioEntry
+	events       []models.ReservationEvent
+}
+
+func (s *store) AllRooms(propertyId int) ([]models.Room, error) {
+	return rooms, nil
+}
+
+func (s *store) GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error) {
+	var found []models.RoomRestriction
+	for _, rr := range s.calendar {
+		if rr.RoomId == roomId && period.Overlaps(dates.Range{Start: rr.StartDate, End: rr.EndDate}) {
+			found = append(found, rr)
+		}
+	}
+	return found, nil
+}
+
+func (s *store) GetReservationByChannelRef(channel, ref string) (models.Reservation, error) {
+	for _, res := range s.reservations {
+		if res.Channel == channel && res.ChannelRef == ref {
+			return res, nil
+		}
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+func (s *store) ActiveTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error) {
+	return nil, nil
+}
+
+func (s *store) FindOrCreateGuest(g models.Guest) (int, error) {
+	return 4, nil
+}
+
+func (s *store) CreateReservations(name string, lines []models.Reservation, charges []models.FolioEntry, payments []models.Payment) ([]models.Reservation, error) {
+	res := lines[0]
+	stay := res.Stay()
+	for _, rr := range s.calendar {
+		if rr.RoomId == res.RoomId && stay.Overlaps(dates.Range{Start: rr.StartDate, End: rr.EndDate}) {
+			return nil, repository.ErrUnavailable
+		}
+	}
+
+	res.ID = len(s.reservations) + 1
+	s.reservations = append(s.reservations, res)
+	s.calendar = append(s.calendar, models.RoomRestriction{RoomId: res.RoomId, ReservationId: res.ID,
+		StartDate: res.StartDate, EndDate: res.EndDate, RestrictionId: 1})
+	for _, e := range charges {
+		e.ReservationId = res.ID
+		s.folio = append(s.folio, e)
+	}
+	return []models.Reservation{res}, nil
+}
+
+func (s *store) InsertReservationEvent(e models.ReservationEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func drop(t *testing.T, dir, name string, b fileBooking) {
+	data, _ := json.Marshal(b)
+	err := os.WriteFile(filepath.Join(dir, "inbox", name), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAck(t *testing.T, dir, name string) fileAck {
+	var ack fileAck
+	data, err := os.ReadFile(filepath.Join(dir, "acks", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(data, &ack)
+	return ack
+}
+
+func TestRun(t *testing.T) {
+	property := models.Property{ID: 1, Slug: "seaside"}
+	arrival := property.Today().AddDate(0, 0, 10)
+	departure := arrival.AddDate(0, 0, 2)
+
+	root := t.TempDir()
+	dir := filepath.Join(root, property.Slug)
+	os.MkdirAll(filepath.Join(dir, "inbox"), 0755)
+
+	guest := fileBooking{RoomTypeId: 7, FirstName: "Ada", LastName: "Byron", Adults: 2,
+		Arrival: arrival.Format(dates.Layout), Departure: departure.Format(dates.Layout)}
+
+	first, second, third := guest, guest, guest
+	first.Ref, first.Amount = "A1", 15000
+	second.Ref = "A2"
+	third.Ref = "A3"
+	drop(t, dir, "1.json", first)
+	drop(t, dir, "2.json", second)
+	drop(t, dir, "3.json", third)
+	os.WriteFile(filepath.Join(dir, "inbox", "4.json"), []byte("{"), 0644)
+
+	s := &store{}
+	ch := NewFileChannel(root)
+
+	result, err := Run(s, ch, property)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Accepted != 2 || len(result.Rejected) != 1 || result.Rejected[0].Ref != "A3" {
+		t.Errorf("expected both garden rooms booked and the third refused, got %+v", result)
+	}
+	if s.reservations[0].Channel != "file" || s.reservations[0].ChannelRef != "A1" || s.reservations[0].ManageToken == "" {
+		t.Errorf("wrong reservation %+v", s.reservations[0])
+	}
+	if s.reservations[0].RoomId == s.reservations[1].RoomId {
+		t.Error("expected the two bookings in different rooms")
+	}
+	if s.folio[0].Amount != 15000 || len(s.folio) != 2 || s.folio[1].Amount != 2*9000 && s.folio[1].Amount != 2*8000 {
+		t.Errorf("expected the channel amount, then the stay priced here, got %+v", s.folio)
+	}
+	if s.folio[0].ReservationId != 1 || s.folio[1].ReservationId != 2 {
+		t.Errorf("expected the charges booked with their reservations, got %+v", s.folio)
+	}
+	if len(s.events) != 2 {
+		t.Errorf("expected a timeline event per reservation, got %+v", s.events)
+	}
+
+	if ack := readAck(t, dir, "1.json"); ack.Status != "accepted" || ack.ReservationId != 1 {
+		t.Errorf("wrong ack %+v", ack)
+	}
+	if ack := readAck(t, dir, "3.json"); ack.Status != "rejected" || ack.Reason == "" {
+		t.Errorf("wrong ack %+v", ack)
+	}
+	if ack := readAck(t, dir, "4.json"); ack.Status != "rejected" || ack.Ref != "4" {
+		t.Errorf("expected the unreadable file to be refused, got %+v", ack)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "inbox", "*")); len(left) != 0 {
+		t.Errorf("expected an empty inbox, got %v", left)
+	}
+
+	var days []fileDay
+	data, _ := os.ReadFile(filepath.Join(dir, "availability.json"))
+	json.Unmarshal(data, &days)
+	if len(days) != Horizon || *days[10].Available != 0 || *days[12].Available != 2 {
+		t.Errorf("expected the new bookings in the availability pushed, got %+v", days[10:13])
+	}
+
+	// a booking delivered again is not booked twice
+	drop(t, dir, "1.json", first)
+	result, err = Run(s, ch, property)
+	if err != nil || result.Duplicates != 1 || result.Accepted != 0 || len(s.reservations) != 2 {
+		t.Errorf("expected a duplicate, got %+v %v", result, err)
+	}
+}
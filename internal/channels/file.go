@@ -0,0 +1,222 @@
+package channels
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// FileChannel is a stand-in channel that exchanges files in a directory, one folder per property slug.
+// Availability and rates are written to availability.json and rates.json, bookings are read from the JSON
+// files dropped in inbox and each is answered with a file of the same name in acks. It serves local
+// development and tests, and shows what a connector to a real channel has to do
+type FileChannel struct {
+	dir    string
+	mu     sync.Mutex
+	pulled map[string]string
+}
+
+// NewFileChannel creates a file channel exchanging files in dir
+func NewFileChannel(dir string) *FileChannel {
+	return &FileChannel{
+		dir:    dir,
+		pulled: make(map[string]string),
+	}
+}
+
+// Name returns the channel name stored on the reservations booked from it
+func (c *FileChannel) Name() string {
+	return "file"
+}
+
+// fileDay is a day of availability.json or rates.json
+type fileDay struct {
+	RoomTypeId int    `json:"room_type_id"`
+	RoomType   string `json:"room_type"`
+	Day        string `json:"day"`
+	Available  *int   `json:"available,omitempty"`
+	Amount     *int   `json:"amount,omitempty"`
+}
+
+// fileBooking is a booking file of the inbox
+type fileBooking struct {
+	Ref        string `json:"ref"`
+	RoomTypeId int    `json:"room_type_id"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Email      string `json:"email"`
+	Phone      string `json:"phone"`
+	Arrival    string `json:"arrival"`
+	Departure  string `json:"departure"`
+	Adults     int    `json:"adults"`
+	Children   int    `json:"children"`
+	Amount     int    `json:"amount"`
+}
+
+// fileAck is an answer written to acks
+type fileAck struct {
+	Ref           string `json:"ref"`
+	Status        string `json:"status"`
+	ReservationId int    `json:"reservation_id,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// PushAvailability replaces availability.json of the property
+func (c *FileChannel) PushAvailability(property models.Property, days []Availability) error {
+	out := make([]fileDay, len(days))
+	for i, d := range days {
+		available := d.Available
+		out[i] = fileDay{RoomTypeId: d.RoomTypeId, RoomType: d.RoomType, Day: d.Day.Format(dates.Layout), Available: &available}
+	}
+	return writeJSON(filepath.Join(c.folder(property), "availability.json"), out)
+}
+
+// PushRates replaces rates.json of the property
+func (c *FileChannel) PushRates(property models.Property, rates []Rate) error {
+	out := make([]fileDay, len(rates))
+	for i, r := range rates {
+		amount := r.Amount
+		out[i] = fileDay{RoomTypeId: r.RoomTypeId, RoomType: r.RoomType, Day: r.Day.Format(dates.Layout), Amount: &amount}
+	}
+	return writeJSON(filepath.Join(c.folder(property), "rates.json"), out)
+}
+
+// PullReservations reads the booking files in the inbox of the property, in order of file name. A booking
+// without a ref takes the name of its file. Files that cannot be read as a booking are answered at once
+// and left out
+func (c *FileChannel) PullReservations(property models.Property) ([]Booking, error) {
+	paths, err := filepath.Glob(filepath.Join(c.folder(property), "inbox", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var bookings []Booking
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+
+		b, err := readBooking(path)
+		if err != nil {
+			err = c.answer(path, fileAck{Ref: name, Status: "rejected", Reason: err.Error()})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if b.Ref == "" {
+			b.Ref = name
+		}
+
+		c.pulled[b.Ref] = path
+		bookings = append(bookings, b)
+	}
+
+	return bookings, nil
+}
+
+// Acknowledge writes the answer to a pulled booking to acks and removes the booking from the inbox
+func (c *FileChannel) Acknowledge(property models.Property, ack Ack) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path, ok := c.pulled[ack.Ref]
+	if !ok {
+		return fmt.Errorf("booking %s was not pulled from %s", ack.Ref, c.folder(property))
+	}
+
+	answer := fileAck{Ref: ack.Ref, Status: "accepted", ReservationId: ack.ReservationId}
+	if !ack.Accepted() {
+		answer = fileAck{Ref: ack.Ref, Status: "rejected", Reason: ack.Reason}
+	}
+
+	err := c.answer(path, answer)
+	if err != nil {
+		return err
+	}
+	delete(c.pulled, ack.Ref)
+
+	return nil
+}
+
+// answer writes the ack of the inbox file at path and removes the file
+func (c *FileChannel) answer(path string, ack fileAck) error {
+	acks := filepath.Join(filepath.Dir(filepath.Dir(path)), "acks")
+	err := writeJSON(filepath.Join(acks, filepath.Base(path)), ack)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// folder returns the folder of the files of a property
+func (c *FileChannel) folder(property models.Property) string {
+	return filepath.Join(c.dir, property.Slug)
+}
+
+// readBooking reads a booking file
+func readBooking(path string) (Booking, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Booking{}, err
+	}
+
+	var fb fileBooking
+	err = json.Unmarshal(data, &fb)
+	if err != nil {
+		return Booking{}, fmt.Errorf("invalid booking file: %w", err)
+	}
+
+	stay, err := dates.Parse(fb.Arrival, fb.Departure)
+	if err != nil {
+		return Booking{}, err
+	}
+
+	return Booking{
+		Ref:        fb.Ref,
+		RoomTypeId: fb.RoomTypeId,
+		FirstName:  fb.FirstName,
+		LastName:   fb.LastName,
+		Email:      fb.Email,
+		Phone:      fb.Phone,
+		Arrival:    stay.Start,
+		Departure:  stay.End,
+		Adults:     fb.Adults,
+		Children:   fb.Children,
+		Amount:     fb.Amount,
+	}, nil
+}
+
+// writeJSON writes v to path through a temporary file, so that whoever reads the file never sees it half
+// written
+func writeJSON(path string, v interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -3,18 +3,28 @@ package config
 import (
 	"log"
 	"text/template"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/payments"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache         bool
+	TemplateCache    map[string]*template.Template
+	InfoLog          *log.Logger
+	ErrorLog         *log.Logger
+	InProduction     bool
+	Session          *scs.SessionManager
+	MailChan         chan models.MailData
+	Payments         payments.Gateway
+	Currency         string
+	DepositPercent   int
+	BaseURL          string
+	AttachInvoice    bool
+	ICalSync         time.Duration
+	ChannelSync      time.Duration
+	HousekeepingPlan time.Duration
 }
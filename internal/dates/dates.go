@@ -0,0 +1,114 @@
+// Package dates holds the calendar arithmetic of stays. Days are stored as midnight UTC, whatever
+// the timezone of the property, so they compare and format the same everywhere
+package dates
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Layout is the format of days in forms, URLs and the database
+const Layout = "2006-01-02"
+
+// ClockLayout is the format of check-in and check-out times
+const ClockLayout = "15:04"
+
+// ErrEmptyRange is returned when the departure day is not after the arrival day
+var ErrEmptyRange = errors.New("departure must be after arrival")
+
+// Range is a stay from the arrival day to the departure day. It covers the nights starting on
+// Start up to, but not including, End, so the departure day is free for the next guest
+type Range struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Parse reads a range from arrival and departure days in Layout
+func Parse(start, end string) (Range, error) {
+	s, err := time.Parse(Layout, start)
+	if err != nil {
+		return Range{}, err
+	}
+
+	e, err := time.Parse(Layout, end)
+	if err != nil {
+		return Range{}, err
+	}
+
+	if !e.After(s) {
+		return Range{}, ErrEmptyRange
+	}
+
+	return Range{Start: s, End: e}, nil
+}
+
+// Day returns the calendar day of t, as seen in t's location
+func Day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Today returns the current day in loc
+func Today(loc *time.Location) time.Time {
+	return Day(time.Now().In(loc))
+}
+
+// Nights returns the number of nights in the range
+func (r Range) Nights() int {
+	n := int(r.End.Sub(r.Start).Round(24*time.Hour).Hours() / 24)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Days returns the day each night of the range starts on
+func (r Range) Days() []time.Time {
+	days := make([]time.Time, 0, r.Nights())
+	for d := r.Start; d.Before(r.End); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// Contains reports whether the night starting on day is part of the range
+func (r Range) Contains(day time.Time) bool {
+	return !day.Before(r.Start) && day.Before(r.End)
+}
+
+// Overlaps reports whether the two ranges share a night. A departure on the day of an arrival is
+// not an overlap
+func (r Range) Overlaps(o Range) bool {
+	return r.Start.Before(o.End) && o.Start.Before(r.End)
+}
+
+// Bounds returns the moments the first day of the range starts and the last one ends in loc
+func (r Range) Bounds(loc *time.Location) (time.Time, time.Time) {
+	return midnight(r.Start, loc), midnight(r.End, loc)
+}
+
+func midnight(day time.Time, loc *time.Location) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%s to %s", r.Start.Format(Layout), r.End.Format(Layout))
+}
+
+// ParseClock checks a time of day in ClockLayout
+func ParseClock(clock string) (time.Time, error) {
+	return time.Parse(ClockLayout, clock)
+}
+
+// At returns the moment clock strikes on day in loc
+func At(day time.Time, clock string, loc *time.Location) (time.Time, error) {
+	c, err := ParseClock(clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	y, m, d := day.Date()
+	return time.Date(y, m, d, c.Hour(), c.Minute(), 0, 0, loc), nil
+}
@@ -0,0 +1,81 @@
+package dates
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	r, err := Parse("2050-01-10", "2050-01-13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Nights() != 3 {
+		t.Errorf("expected 3 nights, got %d", r.Nights())
+	}
+
+	days := r.Days()
+	if len(days) != 3 || days[2].Format(Layout) != "2050-01-12" {
+		t.Errorf("wrong nights %v", days)
+	}
+
+	if _, err := Parse("2050-01-10", "2050-01-10"); !errors.Is(err, ErrEmptyRange) {
+		t.Errorf("expected ErrEmptyRange for a zero night stay, got %v", err)
+	}
+	if _, err := Parse("2050-01-10", "invalid"); err == nil {
+		t.Error("expected error for an invalid day")
+	}
+}
+
+func TestRange_Overlaps(t *testing.T) {
+	r, _ := Parse("2050-01-10", "2050-01-13")
+
+	var tests = []struct {
+		start    string
+		end      string
+		expected bool
+	}{
+		{"2050-01-08", "2050-01-10", false},
+		{"2050-01-13", "2050-01-15", false},
+		{"2050-01-12", "2050-01-14", true},
+		{"2050-01-09", "2050-01-15", true},
+	}
+
+	for _, e := range tests {
+		o, _ := Parse(e.start, e.end)
+		if r.Overlaps(o) != e.expected {
+			t.Errorf("%s overlapping %s: expected %v", r, o, e.expected)
+		}
+	}
+
+	if r.Contains(r.End) {
+		t.Error("the departure day should not be part of the stay")
+	}
+}
+
+func TestDay(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	// late evening of the 10th in UTC is already the 11th in Tokyo
+	now := time.Date(2050, 1, 10, 20, 0, 0, 0, time.UTC)
+	if d := Day(now.In(tokyo)); d.Format(Layout) != "2050-01-11" {
+		t.Errorf("expected 2050-01-11, got %s", d.Format(Layout))
+	}
+}
+
+func TestAt(t *testing.T) {
+	day, _ := time.Parse(Layout, "2050-01-10")
+
+	checkIn, err := At(day, "15:00", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkIn.Hour() != 15 || checkIn.Day() != 10 {
+		t.Errorf("wrong check-in %v", checkIn)
+	}
+
+	if _, err := At(day, "3pm", time.UTC); err == nil {
+		t.Error("expected error for an invalid clock")
+	}
+}
@@ -0,0 +1,20 @@
+// Package datestest helps tests write days
+package datestest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates"
+)
+
+// Day returns the day s in dates.Layout, failing the test when s is not a day
+func Day(tb testing.TB, s string) time.Time {
+	tb.Helper()
+
+	d, err := time.Parse(dates.Layout, s)
+	if err != nil {
+		tb.Fatalf("bad day %q: %v", s, err)
+	}
+	return d
+}
@@ -0,0 +1,30 @@
+package datestest
+
+import (
+	"testing"
+	"time"
+)
+
+// recorder stands in for a test, recording whether it was failed
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failed = true
+}
+
+func TestDay(t *testing.T) {
+	if got := Day(t, "2050-01-10"); !got.Equal(time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("wrong day %s", got)
+	}
+
+	r := &recorder{}
+	Day(r, "10/01/2050")
+	if !r.failed {
+		t.Error("expected a bad day to fail the test")
+	}
+}
@@ -0,0 +1,175 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/money"
+	"github.com/eldicela/bookings/internal/xlsx"
+)
+
+// Formats
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+var (
+	// ErrUnknownFormat is returned for a format other than FormatCSV and FormatXLSX
+	ErrUnknownFormat = errors.New("unknown export format")
+	// ErrUnknownColumn is returned when selecting a column that does not exist
+	ErrUnknownColumn = errors.New("unknown export column")
+)
+
+// Column is a column a reservation export can have
+type Column struct {
+	Key     string
+	Title   string
+	Numeric bool
+	value   func(res models.Reservation) string
+}
+
+// Columns lists every column of a reservation export, in their default order
+var Columns = []Column{
+	{"id", "ID", true, func(res models.Reservation) string { return strconv.Itoa(res.ID) }},
+	{"first_name", "First Name", false, func(res models.Reservation) string { return res.FirstName }},
+	{"last_name", "Last Name", false, func(res models.Reservation) string { return res.LastName }},
+	{"email", "Email", false, func(res models.Reservation) string { return res.Email }},
+	{"phone", "Phone", false, func(res models.Reservation) string { return res.Phone }},
+	{"room", "Room", false, func(res models.Reservation) string { return res.Room.RoomName }},
+	{"arrival", "Arrival", false, func(res models.Reservation) string { return res.StartDate.Format(dates.Layout) }},
+	{"departure", "Departure", false, func(res models.Reservation) string { return res.EndDate.Format(dates.Layout) }},
+	{"nights", "Nights", true, func(res models.Reservation) string { return strconv.Itoa(res.Stay().Nights()) }},
+	{"adults", "Adults", true, func(res models.Reservation) string { return strconv.Itoa(res.Adults) }},
+	{"children", "Children", true, func(res models.Reservation) string { return strconv.Itoa(res.Children) }},
+	{"balance_due", "Balance Due", true, func(res models.Reservation) string { return money.Format(res.BalanceDue) }},
+	{"processed", "Processed", false, func(res models.Reservation) string { return yesNo(res.Processed == 1) }},
+	{"checked_in", "Checked In", false, func(res models.Reservation) string { return timestamp(res.CheckedInAt) }},
+	{"checked_out", "Checked Out", false, func(res models.Reservation) string { return timestamp(res.CheckedOutAt) }},
+	{"cancelled", "Cancelled", false, func(res models.Reservation) string { return timestamp(res.CancelledAt) }},
+	{"created_at", "Booked", false, func(res models.Reservation) string { return timestamp(res.CreatedAt) }},
+}
+
+// Select returns the columns named by keys, in that order. No keys selects every column
+func Select(keys []string) ([]Column, error) {
+	if len(keys) == 0 {
+		return Columns, nil
+	}
+
+	var columns []Column
+	for _, key := range keys {
+		found := false
+		for _, c := range Columns {
+			if c.Key == key {
+				columns = append(columns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, key)
+		}
+	}
+
+	return columns, nil
+}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Writer writes reservations as the rows of a spreadsheet, one at a time
+type Writer struct {
+	columns []Column
+	numeric []bool
+	csv     *csv.Writer
+	xlsx    *xlsx.Writer
+}
+
+// NewWriter starts a spreadsheet in format on w and writes its header row
+func NewWriter(w io.Writer, format string, columns []Column) (*Writer, error) {
+	ew := &Writer{columns: columns}
+
+	header := make([]string, len(columns))
+	ew.numeric = make([]bool, len(columns))
+	for i, c := range columns {
+		header[i] = c.Title
+		ew.numeric[i] = c.Numeric
+	}
+
+	switch format {
+	case FormatCSV:
+		ew.csv = csv.NewWriter(w)
+		return ew, ew.csv.Write(header)
+	case FormatXLSX:
+		xw, err := xlsx.NewWriter(w, "Reservations")
+		if err != nil {
+			return nil, err
+		}
+		ew.xlsx = xw
+		return ew, xw.Write(header, nil)
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// Write adds the row of a reservation
+func (w *Writer) Write(res models.Reservation) error {
+	row := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		row[i] = c.value(res)
+	}
+
+	if w.xlsx != nil {
+		return w.xlsx.Write(row, w.numeric)
+	}
+
+	for i, v := range row {
+		if !w.numeric[i] {
+			row[i] = defuse(v)
+		}
+	}
+	return w.csv.Write(row)
+}
+
+// Close finishes the spreadsheet
+func (w *Writer) Close() error {
+	if w.xlsx != nil {
+		return w.xlsx.Close()
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// defuse keeps spreadsheets from reading text typed by guests as a formula
+func defuse(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
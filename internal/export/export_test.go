@@ -0,0 +1,71 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestSelect(t *testing.T) {
+	columns, err := Select(nil)
+	if err != nil || len(columns) != len(Columns) {
+		t.Errorf("expected every column, got %d %v", len(columns), err)
+	}
+
+	columns, err = Select([]string{"last_name", "id"})
+	if err != nil || len(columns) != 2 || columns[0].Key != "last_name" || columns[1].Key != "id" {
+		t.Errorf("wrong columns %+v %v", columns, err)
+	}
+
+	if _, err = Select([]string{"password"}); !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("expected ErrUnknownColumn, got %v", err)
+	}
+}
+
+func TestWriter_CSV(t *testing.T) {
+	columns, _ := Select([]string{"id", "last_name", "nights", "balance_due", "cancelled"})
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatCSV, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	err = w.Write(models.Reservation{ID: 7, LastName: "=HYPERLINK(\"x\")", StartDate: day, EndDate: day.AddDate(0, 0, 3), BalanceDue: -1205})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "ID,Last Name,Nights,Balance Due,Cancelled\n7,\"'=HYPERLINK(\"\"x\"\")\",3,-12.05,\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%q\ngot\n%q", want, buf.String())
+	}
+}
+
+func TestWriter_XLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatXLSX, Columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(models.Reservation{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("PK")) {
+		t.Error("expected a zip file")
+	}
+
+	if _, err = NewWriter(&buf, "pdf", Columns); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("expected ErrUnknownFormat, got %v", err)
+	}
+}
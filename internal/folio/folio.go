@@ -0,0 +1,122 @@
+package folio
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Entry types. Charges raise the balance due, payments lower it
+const (
+	TypeCharge  = "charge"
+	TypePayment = "payment"
+)
+
+// Entry categories
+const (
+	CategoryRoom     = "room"
+	CategoryExtra    = "extra"
+	CategoryDamage   = "damage"
+	CategoryTax      = "tax"
+	CategoryFee      = "fee"
+	CategoryDiscount = "discount"
+	CategoryPayment  = "payment"
+	CategoryDeposit  = "deposit"
+	CategoryRefund   = "refund"
+)
+
+// Categories lists the categories staff can post, by entry type
+var Categories = map[string][]string{
+	TypeCharge:  {CategoryExtra, CategoryDamage, CategoryTax, CategoryFee, CategoryRoom},
+	TypePayment: {CategoryPayment, CategoryRefund},
+}
+
+var (
+	// ErrEntryNotFound is returned when voiding an entry that is not on the folio
+	ErrEntryNotFound = errors.New("folio entry not found")
+	// ErrAlreadyVoided is returned when voiding an entry twice
+	ErrAlreadyVoided = errors.New("folio entry is already voided")
+	// ErrVoidOfVoid is returned when voiding a void entry
+	ErrVoidOfVoid = errors.New("a void entry cannot be voided")
+)
+
+// Line is a folio entry with the balance due after it was posted
+type Line struct {
+	models.FolioEntry
+	Balance int
+	Voided  bool
+}
+
+// Signed returns the effect of an entry on the balance due
+func Signed(e models.FolioEntry) int {
+	if e.EntryType == TypePayment {
+		return -e.Amount
+	}
+	return e.Amount
+}
+
+// Balance returns the balance due for the entries
+func Balance(entries []models.FolioEntry) int {
+	var balance int
+	for _, e := range entries {
+		balance += Signed(e)
+	}
+	return balance
+}
+
+// Ledger returns the entries, in posting order, with their running balance
+func Ledger(entries []models.FolioEntry) []Line {
+	voided := make(map[int]bool)
+	for _, e := range entries {
+		if e.VoidsId > 0 {
+			voided[e.VoidsId] = true
+		}
+	}
+
+	var lines []Line
+	var balance int
+	for _, e := range entries {
+		balance += Signed(e)
+		lines = append(lines, Line{
+			FolioEntry: e,
+			Balance:    balance,
+			Voided:     voided[e.ID],
+		})
+	}
+
+	return lines
+}
+
+// Void builds the entry reversing entry id. Entries are never changed, a void is posted instead
+func Void(entries []models.FolioEntry, id int) (models.FolioEntry, error) {
+	var original models.FolioEntry
+	found := false
+
+	for _, e := range entries {
+		if e.VoidsId == id {
+			return models.FolioEntry{}, ErrAlreadyVoided
+		}
+		if e.ID == id {
+			original = e
+			found = true
+		}
+	}
+
+	if !found {
+		return models.FolioEntry{}, ErrEntryNotFound
+	}
+	if original.VoidsId > 0 {
+		return models.FolioEntry{}, ErrVoidOfVoid
+	}
+
+	return models.FolioEntry{
+		ReservationId: original.ReservationId,
+		EntryType:     original.EntryType,
+		Category:      original.Category,
+		Description:   fmt.Sprintf("Void: %s", original.Description),
+		Amount:        -original.Amount,
+		VoidsId:       original.ID,
+		TaxFeeRuleId:  original.TaxFeeRuleId,
+	}, nil
+}
@@ -0,0 +1,68 @@
+package folio
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+var entries = []models.FolioEntry{
+	{ID: 1, EntryType: TypeCharge, Category: CategoryRoom, Description: "Room", Amount: 20000},
+	{ID: 2, EntryType: TypePayment, Category: CategoryDeposit, Description: "Deposit", Amount: 4000},
+	{ID: 3, EntryType: TypeCharge, Category: CategoryExtra, Description: "Minibar", Amount: 1550},
+	{ID: 4, EntryType: TypeCharge, Category: CategoryExtra, Description: "Void: Minibar", Amount: -1550, VoidsId: 3},
+}
+
+func TestBalance(t *testing.T) {
+	if b := Balance(entries); b != 16000 {
+		t.Errorf("expected balance 16000, got %d", b)
+	}
+
+	if b := Balance(nil); b != 0 {
+		t.Errorf("expected balance 0 for empty folio, got %d", b)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	lines := Ledger(entries)
+
+	expected := []int{20000, 16000, 17550, 16000}
+	for i, l := range lines {
+		if l.Balance != expected[i] {
+			t.Errorf("line %d: expected balance %d, got %d", i, expected[i], l.Balance)
+		}
+	}
+
+	if !lines[2].Voided {
+		t.Error("voided entry not marked as voided")
+	}
+	if lines[0].Voided {
+		t.Error("entry marked as voided when it is not")
+	}
+}
+
+func TestVoid(t *testing.T) {
+	v, err := Void(entries, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Amount != -4000 || v.VoidsId != 2 || v.EntryType != TypePayment {
+		t.Errorf("wrong void entry: %+v", v)
+	}
+
+	_, err = Void(entries, 3)
+	if !errors.Is(err, ErrAlreadyVoided) {
+		t.Errorf("expected ErrAlreadyVoided, got %v", err)
+	}
+
+	_, err = Void(entries, 4)
+	if !errors.Is(err, ErrVoidOfVoid) {
+		t.Errorf("expected ErrVoidOfVoid, got %v", err)
+	}
+
+	_, err = Void(entries, 99)
+	if !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("expected ErrEntryNotFound, got %v", err)
+	}
+}
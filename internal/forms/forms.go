@@ -3,6 +3,7 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
@@ -62,3 +63,40 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+var errInvalidAmount = fmt.Errorf("invalid amount")
+
+// IsAmount checks for a money amount such as 12 or 12.50
+func (f *Form) IsAmount(field string) {
+	_, err := ParseAmount(f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "Invalid amount")
+	}
+}
+
+// ParseAmount converts an amount such as 12.50 to cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || (hasFrac && (frac == "" || len(frac) > 2)) {
+		return 0, errInvalidAmount
+	}
+
+	units, err := strconv.Atoi(whole)
+	if err != nil || units < 0 {
+		return 0, errInvalidAmount
+	}
+
+	cents := 0
+	if hasFrac {
+		if len(frac) == 1 {
+			frac += "0"
+		}
+		cents, err = strconv.Atoi(frac)
+		if err != nil || cents < 0 {
+			return 0, errInvalidAmount
+		}
+	}
+
+	return units*100 + cents, nil
+}
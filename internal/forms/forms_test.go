@@ -147,3 +147,51 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("got an valid for invalid email address")
 	}
 }
+
+func TestForm_IsAmount(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("amount", "12.5")
+	form := New(postedValues)
+
+	form.IsAmount("amount")
+	if !form.Valid() {
+		t.Error("got an invalid amount when we should not have")
+	}
+
+	postedValues = url.Values{}
+	postedValues.Add("amount", "12.505")
+	form = New(postedValues)
+
+	form.IsAmount("amount")
+	if form.Valid() {
+		t.Error("got a valid amount for too many decimals")
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected int
+		isError  bool
+	}{
+		{"12", 1200, false},
+		{"12.5", 1250, false},
+		{"12.05", 1205, false},
+		{" 0.99 ", 99, false},
+		{"", 0, true},
+		{"-3", 0, true},
+		{"1.", 0, true},
+		{"1.2.3", 0, true},
+		{"abc", 0, true},
+	}
+
+	for _, e := range tests {
+		cents, err := ParseAmount(e.value)
+		if e.isError && err == nil {
+			t.Errorf("expected an error parsing %q", e.value)
+		}
+		if !e.isError && cents != e.expected {
+			t.Errorf("parsing %q: expected %d, got %d", e.value, e.expected, cents)
+		}
+	}
+}
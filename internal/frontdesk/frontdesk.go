@@ -0,0 +1,82 @@
+package frontdesk
+
+import (
+	"errors"
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Lists
+const (
+	ListArrivals   = "arrivals"
+	ListDepartures = "departures"
+	ListInHouse    = "in-house"
+)
+
+// Lists names the lists of the front desk report, in the order they are shown
+var Lists = []string{ListArrivals, ListDepartures, ListInHouse}
+
+// Titles are the headings of the lists
+var Titles = map[string]string{
+	ListArrivals:   "Arrivals",
+	ListDepartures: "Departures",
+	ListInHouse:    "In House",
+}
+
+var (
+	// ErrTooEarly is returned when checking in guests before the day they arrive
+	ErrTooEarly = errors.New("guests cannot check in before their arrival day")
+	// ErrTooLate is returned when checking in guests on or after the day they leave
+	ErrTooLate = errors.New("guests cannot check in on or after their departure day")
+	// ErrCancelled is returned when checking in guests of a cancelled reservation
+	ErrCancelled = errors.New("the reservation is cancelled")
+	// ErrCheckedIn is returned when checking in guests twice
+	ErrCheckedIn = errors.New("guests are already checked in")
+	// ErrNotCheckedIn is returned when checking out guests that never checked in
+	ErrNotCheckedIn = errors.New("guests are not checked in")
+	// ErrCheckedOut is returned when checking out guests twice
+	ErrCheckedOut = errors.New("guests are already checked out")
+)
+
+// List is one list of the report: the reservations arriving, leaving or staying on a day
+type List struct {
+	Name         string
+	Title        string
+	Day          time.Time
+	Reservations []models.Reservation
+	// Actionable is set on the lists of today, where guests can be checked in and out
+	Actionable bool
+}
+
+// ValidList reports whether name is a list of the report
+func ValidList(name string) bool {
+	_, ok := Titles[name]
+	return ok
+}
+
+// CanCheckIn reports whether the guests of res can check in on today
+func CanCheckIn(res models.Reservation, today time.Time) error {
+	switch {
+	case !res.CancelledAt.IsZero():
+		return ErrCancelled
+	case !res.CheckedInAt.IsZero():
+		return ErrCheckedIn
+	case today.Before(res.StartDate):
+		return ErrTooEarly
+	case !today.Before(res.EndDate):
+		return ErrTooLate
+	}
+	return nil
+}
+
+// CanCheckOut reports whether the guests of res can check out
+func CanCheckOut(res models.Reservation) error {
+	switch {
+	case !res.CheckedOutAt.IsZero():
+		return ErrCheckedOut
+	case res.CheckedInAt.IsZero():
+		return ErrNotCheckedIn
+	}
+	return nil
+}
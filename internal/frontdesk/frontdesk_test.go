@@ -0,0 +1,72 @@
+package frontdesk
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestCanCheckIn(t *testing.T) {
+	res := models.Reservation{StartDate: datestest.Day(t, "2050-01-10"), EndDate: datestest.Day(t, "2050-01-12")}
+
+	if err := CanCheckIn(res, datestest.Day(t, "2050-01-09")); !errors.Is(err, ErrTooEarly) {
+		t.Errorf("expected ErrTooEarly, got %v", err)
+	}
+	if err := CanCheckIn(res, datestest.Day(t, "2050-01-10")); err != nil {
+		t.Errorf("expected check in on the arrival day, got %v", err)
+	}
+	// late arrivals still check in
+	if err := CanCheckIn(res, datestest.Day(t, "2050-01-11")); err != nil {
+		t.Errorf("expected a late check in, got %v", err)
+	}
+
+	if err := CanCheckIn(res, datestest.Day(t, "2050-01-12")); !errors.Is(err, ErrTooLate) {
+		t.Errorf("expected ErrTooLate on the departure day, got %v", err)
+	}
+	if err := CanCheckIn(res, datestest.Day(t, "2050-01-13")); !errors.Is(err, ErrTooLate) {
+		t.Errorf("expected ErrTooLate after the departure day, got %v", err)
+	}
+
+	cancelled := res
+	cancelled.CancelledAt = time.Now()
+	if err := CanCheckIn(cancelled, datestest.Day(t, "2050-01-10")); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+
+	res.CheckedInAt = time.Now()
+	if err := CanCheckIn(res, datestest.Day(t, "2050-01-10")); !errors.Is(err, ErrCheckedIn) {
+		t.Errorf("expected ErrCheckedIn, got %v", err)
+	}
+}
+
+func TestCanCheckOut(t *testing.T) {
+	var res models.Reservation
+
+	if err := CanCheckOut(res); !errors.Is(err, ErrNotCheckedIn) {
+		t.Errorf("expected ErrNotCheckedIn, got %v", err)
+	}
+
+	res.CheckedInAt = time.Now()
+	if err := CanCheckOut(res); err != nil {
+		t.Errorf("expected check out, got %v", err)
+	}
+
+	res.CheckedOutAt = time.Now()
+	if err := CanCheckOut(res); !errors.Is(err, ErrCheckedOut) {
+		t.Errorf("expected ErrCheckedOut, got %v", err)
+	}
+}
+
+func TestValidList(t *testing.T) {
+	for _, name := range Lists {
+		if !ValidList(name) {
+			t.Errorf("expected %s to be a list", name)
+		}
+	}
+	if ValidList("all") {
+		t.Error("expected all not to be a list")
+	}
+}
@@ -0,0 +1,105 @@
+// Package guests links reservations to guest profiles. Profiles are matched on normalised contact
+// details so the same person booking twice ends up with one history
+package guests
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// MinPhoneDigits is the shortest phone number trusted to identify a guest
+const MinPhoneDigits = 7
+
+// EmailKey normalises an email address for matching
+func EmailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// PhoneKey normalises a phone number for matching. Only digits are kept, an international 00 prefix
+// is treated like +, and numbers too short to be unique give an empty key
+func PhoneKey(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+
+	key := strings.TrimPrefix(b.String(), "00")
+	if len(key) < MinPhoneDigits {
+		return ""
+	}
+	return key
+}
+
+// nameKey normalises a full name for spotting duplicates
+func nameKey(g models.Guest) string {
+	return strings.ToLower(strings.Join(strings.Fields(g.FirstName+" "+g.LastName), " "))
+}
+
+// FromReservation returns the guest profile details entered on a reservation
+func FromReservation(res models.Reservation) models.Guest {
+	return models.Guest{
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+	}
+}
+
+// Duplicates groups profiles that are probably the same person: they share a phone number, an email
+// address or a full name. Only groups of two or more are returned, in the order of their first member
+func Duplicates(list []models.Guest) [][]models.Guest {
+	parent := make([]int, len(list))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	seen := make(map[string]int)
+	link := func(kind, key string, i int) {
+		if key == "" {
+			return
+		}
+		key = kind + key
+		if j, ok := seen[key]; ok {
+			parent[find(i)] = find(j)
+			return
+		}
+		seen[key] = i
+	}
+
+	for i, g := range list {
+		link("email:", EmailKey(g.Email), i)
+		link("phone:", PhoneKey(g.Phone), i)
+		link("name:", nameKey(g), i)
+	}
+
+	groups := make(map[int][]models.Guest)
+	var order []int
+	for i, g := range list {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			order = append(order, root)
+		}
+		groups[root] = append(groups[root], g)
+	}
+
+	var duplicates [][]models.Guest
+	for _, root := range order {
+		if len(groups[root]) > 1 {
+			duplicates = append(duplicates, groups[root])
+		}
+	}
+
+	return duplicates
+}
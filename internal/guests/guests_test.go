@@ -0,0 +1,54 @@
+package guests
+
+import (
+	"testing"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestEmailKey(t *testing.T) {
+	if k := EmailKey("  John.Smith@Example.COM "); k != "john.smith@example.com" {
+		t.Errorf("wrong key %q", k)
+	}
+}
+
+func TestPhoneKey(t *testing.T) {
+	var tests = []struct {
+		phone    string
+		expected string
+	}{
+		{"+44 (0)20 7946-0018", "4402079460018"},
+		{"0044 020 7946 0018", "4402079460018"},
+		{"555-12", ""},
+		{"", ""},
+	}
+
+	for _, e := range tests {
+		if k := PhoneKey(e.phone); k != e.expected {
+			t.Errorf("%q: expected %q, got %q", e.phone, e.expected, k)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	list := []models.Guest{
+		{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@here.com", Phone: "555 123 4567"},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@here.com"},
+		{ID: 3, FirstName: "J.", LastName: "Smith", Email: "js@work.com", Phone: "(555) 123-4567"},
+		{ID: 4, FirstName: "Jane ", LastName: "doe", Email: "jane.doe@work.com"},
+		{ID: 5, FirstName: "Bob", LastName: "Jones", Email: "bob@here.com"},
+		{ID: 6, FirstName: "John", LastName: "Smith", Email: "other@here.com"},
+	}
+
+	groups := Duplicates(list)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+
+	if len(groups[0]) != 3 || groups[0][0].ID != 1 || groups[0][1].ID != 3 || groups[0][2].ID != 6 {
+		t.Errorf("wrong first group %+v", groups[0])
+	}
+	if len(groups[1]) != 2 || groups[1][1].ID != 4 {
+		t.Errorf("wrong second group %+v", groups[1])
+	}
+}
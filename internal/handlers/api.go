@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eldicela/bookings/internal/api"
+	"github.com/eldicela/bookings/internal/apikeys"
+	"github.com/eldicela/bookings/internal/assignment"
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/forms"
+	"github.com/eldicela/bookings/internal/helpers"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/payments"
+	"github.com/eldicela/bookings/internal/pricing"
+	"github.com/eldicela/bookings/internal/promo"
+	"github.com/eldicela/bookings/internal/repository"
+	"github.com/go-chi/chi/v5"
+)
+
+// apiBooking is the body of quote and reservation requests. A stay is either in the room given or in
+// any room of the type given, assigned the way rooms of bookings made on the site are
+type apiBooking struct {
+	RoomId       int    `json:"room_id"`
+	RoomTypeId   int    `json:"room_type_id"`
+	Arrival      string `json:"arrival"`
+	Departure    string `json:"departure"`
+	Adults       int    `json:"adults"`
+	Children     int    `json:"children"`
+	PromoCode    string `json:"promo_code"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	Phone        string `json:"phone"`
+	PaymentToken string `json:"payment_token"`
+}
+
+// values returns the guest fields of b, so they are checked with the rules of the reservation form
+func (b apiBooking) values() url.Values {
+	return url.Values{
+		"first_name":    {b.FirstName},
+		"last_name":     {b.LastName},
+		"email":         {b.Email},
+		"phone":         {b.Phone},
+		"payment_token": {b.PaymentToken},
+	}
+}
+
+// apiServerError logs err and sends an internal error, without its details
+func (m *Repository) apiServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s \n %s ", err.Error(), debug.Stack())
+	m.App.ErrorLog.Println(trace)
+	api.Fail(w, api.Internal())
+}
+
+// APINotFound answers paths of the API that do not exist
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	api.Fail(w, api.NotFound("endpoint"))
+}
+
+// APIMethodNotAllowed answers methods an endpoint of the API does not serve
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	api.Fail(w, api.NewError(http.StatusMethodNotAllowed, api.CodeMethodNotAllowed,
+		fmt.Sprintf("%s is not allowed here", r.Method)))
+}
+
+// APIKey checks the key a request to the API is sent with and puts it in the request context. Requests
+// without a key go on, and are turned away by APIScope where a scope is needed
+func (m *Repository) APIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := apikeys.FromRequest(r)
+		if secret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := m.DB.GetAPIKeyByHash(apikeys.Hash(secret))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !key.RevokedAt.IsZero()) {
+			api.Fail(w, api.NewError(http.StatusUnauthorized, api.CodeUnauthorized, "the API key is not valid"))
+			return
+		} else if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+
+		now := time.Now()
+		ok, left, reset := m.Limiter.Allow(key.ID, key.RateLimit, now)
+		if key.RateLimit > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(key.RateLimit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(left))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+			api.Fail(w, api.NewError(http.StatusTooManyRequests, api.CodeRateLimited,
+				fmt.Sprintf("this key is limited to %d requests a minute", key.RateLimit)))
+			return
+		}
+
+		// last use is only kept to the minute, so busy keys do not write on every request
+		if now.Sub(key.LastUsedAt) >= time.Minute {
+			err = m.DB.TouchAPIKey(key.ID, now)
+			if err != nil {
+				m.App.ErrorLog.Println(err)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithAPIKey(r.Context(), key)))
+	})
+}
+
+// APIScope lets through requests made with a key that has scope
+func (m *Repository) APIScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := helpers.APIKeyFromContext(r.Context())
+			if key.ID == 0 {
+				api.Fail(w, api.KeyRequired())
+				return
+			}
+			if !apikeys.Allows(key.Scopes, scope) {
+				api.Fail(w, api.NewError(http.StatusForbidden, api.CodeForbidden,
+					fmt.Sprintf("the API key does not have the %s scope", scope)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIProperty puts the property named by the {property} URL parameter in the request context, like
+// PropertyBySlug but answering in JSON
+func (m *Repository) APIProperty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := m.DB.GetPropertyBySlug(chi.URLParam(r, "property"))
+		if errors.Is(err, sql.ErrNoRows) {
+			api.Fail(w, api.NotFound("property"))
+			return
+		} else if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithProperty(r.Context(), p)))
+	})
+}
+
+// APIProperties lists the properties
+func (m *Repository) APIProperties(w http.ResponseWriter, r *http.Request) {
+	page, e := api.ParsePage(r.URL.Query())
+	if e != nil {
+		api.Fail(w, e)
+		return
+	}
+
+	properties, err := m.DB.AllProperties()
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	start, end := page.Bounds(len(properties))
+	out := make([]api.Property, 0, end-start)
+	for _, p := range properties[start:end] {
+		out = append(out, api.NewProperty(p))
+	}
+
+	api.Respond(w, http.StatusOK, out, page.Meta(len(properties)))
+}
+
+// APIShowProperty shows the property of the request
+func (m *Repository) APIShowProperty(w http.ResponseWriter, r *http.Request) {
+	api.Respond(w, http.StatusOK, api.NewProperty(helpers.PropertyFromContext(r.Context())), nil)
+}
+
+// APIRooms lists the rooms of a property, optionally of one room type
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	page, e := api.ParsePage(q)
+	if e != nil {
+		api.Fail(w, e)
+		return
+	}
+
+	var typeId int
+	if s := q.Get("room_type_id"); s != "" {
+		var err error
+		typeId, err = strconv.Atoi(s)
+		if err != nil || typeId < 1 {
+			api.Fail(w, api.Invalid(map[string][]string{"room_type_id": {"Must be a room type id"}}))
+			return
+		}
+	}
+
+	rooms, err := m.DB.AllRooms(helpers.PropertyFromContext(r.Context()).ID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	var matching []models.Room
+	for _, room := range rooms {
+		if typeId == 0 || room.RoomTypeId == typeId {
+			matching = append(matching, room)
+		}
+	}
+
+	start, end := page.Bounds(len(matching))
+	out := make([]api.Room, 0, end-start)
+	for _, room := range matching[start:end] {
+		out = append(out, api.NewRoom(room))
+	}
+
+	api.Respond(w, http.StatusOK, out, page.Meta(len(matching)))
+}
+
+// APIRoom shows one room of a property
+func (m *Repository) APIRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoom(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	api.Respond(w, http.StatusOK, api.NewRoom(room), nil)
+}
+
+// apiRoom loads the room with id of the property of the request, answering 404 for a room of another
+// property. It reports whether the room was found
+func (m *Repository) apiRoom(w http.ResponseWriter, r *http.Request, id string) (models.Room, bool) {
+	roomId, err := strconv.Atoi(id)
+	if err != nil {
+		api.Fail(w, api.NotFound("room"))
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(roomId)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && room.PropertyId != helpers.PropertyFromContext(r.Context()).ID {
+		api.Fail(w, api.NotFound("room"))
+		return room, false
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+// APIAvailability lists the rooms of a property free for a stay, taking the party size into account
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	property := helpers.PropertyFromContext(r.Context())
+
+	form := forms.New(nil)
+	stay := apiStay(form, property, q.Get("arrival"), q.Get("departure"))
+	adults := apiCount(form, "adults", q.Get("adults"), 1)
+	children := apiCount(form, "children", q.Get("children"), 0)
+	if adults < 1 {
+		form.Errors.Add("adults", "There must be at least one adult")
+	}
+	if !form.Valid() {
+		api.Fail(w, api.Invalid(form.Errors))
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(stay, adults+children, property.ID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	api.Respond(w, http.StatusOK, api.NewAvailability(stay, adults+children, rooms), nil)
+}
+
+// apiStay checks the arrival and departure of a request, adding the problems to form
+func apiStay(form *forms.Form, property models.Property, arrival, departure string) dates.Range {
+	if arrival == "" {
+		form.Errors.Add("arrival", "This field cannot be blank")
+	}
+	if departure == "" {
+		form.Errors.Add("departure", "This field cannot be blank")
+	}
+	if arrival == "" || departure == "" {
+		return dates.Range{}
+	}
+
+	stay, err := dates.Parse(arrival, departure)
+	switch {
+	case errors.Is(err, dates.ErrEmptyRange):
+		form.Errors.Add("departure", "Departure must be after arrival")
+	case err != nil:
+		if _, err := time.Parse(dates.Layout, arrival); err != nil {
+			form.Errors.Add("arrival", "Must be a date such as 2050-01-31")
+		}
+		if _, err := time.Parse(dates.Layout, departure); err != nil {
+			form.Errors.Add("departure", "Must be a date such as 2050-01-31")
+		}
+	case stay.Start.Before(property.Today()):
+		form.Errors.Add("arrival", "Arrival can't be in the past")
+	}
+
+	return stay
+}
+
+// apiCount reads a count of guests from a query parameter, def when it is missing
+func apiCount(form *forms.Form, field, value string, def int) int {
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		form.Errors.Add(field, "Must be a whole number")
+		return def
+	}
+	return n
+}
+
+// apiReservation checks the stay of a quote or reservation request and picks its room. The reservation
+// is returned with the promo code it may use. ok is false once an error has been sent
+func (m *Repository) apiReservation(w http.ResponseWriter, r *http.Request, b apiBooking, form *forms.Form) (models.Reservation, models.PromoCode, bool) {
+	property := helpers.PropertyFromContext(r.Context())
+
+	stay := apiStay(form, property, b.Arrival, b.Departure)
+	if b.Adults < 1 {
+		form.Errors.Add("adults", "There must be at least one adult")
+	}
+	if b.Children < 0 {
+		form.Errors.Add("children", "Must be a whole number")
+	}
+
+	res := models.Reservation{
+		FirstName:  strings.TrimSpace(b.FirstName),
+		LastName:   strings.TrimSpace(b.LastName),
+		Email:      strings.TrimSpace(b.Email),
+		Phone:      strings.TrimSpace(b.Phone),
+		StartDate:  stay.Start,
+		EndDate:    stay.End,
+		Adults:     b.Adults,
+		Children:   b.Children,
+		RoomTypeId: b.RoomTypeId,
+	}
+
+	switch {
+	case b.RoomId > 0:
+		room, err := m.DB.GetRoomByID(b.RoomId)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && room.PropertyId != property.ID {
+			form.Errors.Add("room_id", "There is no such room")
+		} else if err != nil {
+			m.apiServerError(w, err)
+			return res, models.PromoCode{}, false
+		}
+		res.RoomId, res.Room, res.RoomTypeId = room.ID, room, room.RoomTypeId
+	case b.RoomTypeId > 0:
+		types, err := m.DB.AllRoomTypes(property.ID)
+		if err != nil {
+			m.apiServerError(w, err)
+			return res, models.PromoCode{}, false
+		}
+		found := false
+		for _, t := range types {
+			found = found || t.ID == b.RoomTypeId
+		}
+		if !found {
+			form.Errors.Add("room_type_id", "There is no such room type")
+		}
+	default:
+		form.Errors.Add("room_id", "Either room_id or room_type_id is required")
+	}
+
+	if !form.Valid() {
+		api.Fail(w, api.Invalid(form.Errors))
+		return res, models.PromoCode{}, false
+	}
+
+	if res.RoomId == 0 {
+		room, err := m.assignRoom(property.ID, res)
+		if errors.Is(err, assignment.ErrNoRoom) {
+			api.Fail(w, api.NewError(http.StatusConflict, api.CodeConflict, err.Error()))
+			return res, models.PromoCode{}, false
+		} else if err != nil {
+			m.apiServerError(w, err)
+			return res, models.PromoCode{}, false
+		}
+		res.RoomId, res.Room = room.ID, room
+	} else {
+		checkOccupancy(form, res)
+		if !form.Valid() {
+			api.Fail(w, api.Invalid(form.Errors))
+			return res, models.PromoCode{}, false
+		}
+
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(res.Stay(), res.RoomId)
+		if err != nil {
+			m.apiServerError(w, err)
+			return res, models.PromoCode{}, false
+		}
+		if !available {
+			api.Fail(w, api.NewError(http.StatusConflict, api.CodeConflict, "the room is not available for these dates"))
+			return res, models.PromoCode{}, false
+		}
+	}
+
+	var code models.PromoCode
+	if b.PromoCode != "" {
+		var err error
+		code, err = m.DB.GetPromoCodeByCode(property.ID, promo.Normalize(b.PromoCode))
+		if err != nil && !errors.Is(err, promo.ErrUnknownCode) {
+			m.apiServerError(w, err)
+			return res, code, false
+		}
+		if err == nil {
+			err = promo.Validate(code, res.RoomId, res.StartDate, res.EndDate, property.Today())
+		}
+		if err != nil {
+			form.Errors.Add("promo_code", promo.Message(err))
+			api.Fail(w, api.Invalid(form.Errors))
+			return res, code, false
+		}
+	}
+
+	return res, code, true
+}
+
+// APIQuote prices a stay without booking it
+func (m *Repository) APIQuote(w http.ResponseWriter, r *http.Request) {
+	var b apiBooking
+	if e := api.Decode(w, r, &b); e != nil {
+		api.Fail(w, e)
+		return
+	}
+
+	res, code, ok := m.apiReservation(w, r, b, forms.New(nil))
+	if !ok {
+		return
+	}
+
+	quote, err := m.quoteFor(res, code)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	api.Respond(w, http.StatusOK, struct {
+		Room  api.Room  `json:"room"`
+		Quote api.Quote `json:"quote"`
+	}{api.NewRoom(res.Room), api.NewQuote(quote, m.App.Currency, quote.Deposit(m.App.DepositPercent))}, nil)
+}
+
+// APICreateReservation books a stay. It goes through the same steps as a booking made on the site: the
+// deposit is authorized before anything is written and the room is booked atomically, so a room taken in
+// the meantime answers 409 and leaves nothing behind
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var b apiBooking
+	if e := api.Decode(w, r, &b); e != nil {
+		api.Fail(w, e)
+		return
+	}
+
+	form := forms.New(b.values())
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	res, code, ok := m.apiReservation(w, r, b, form)
+	if !ok {
+		return
+	}
+
+	quote, err := m.quoteFor(res, code)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	deposit := quote.Deposit(m.App.DepositPercent)
+	if deposit > 0 {
+		form.Required("payment_token")
+		if !form.Valid() {
+			api.Fail(w, api.Invalid(form.Errors))
+			return
+		}
+	}
+
+	lines, err := m.book(r, booking{
+		lines:        []models.Reservation{res},
+		quote:        quote,
+		quotes:       []pricing.Quote{quote},
+		code:         code,
+		paymentToken: b.PaymentToken,
+		via:          "through the API",
+	})
+	var rejected *promoRejectedError
+	if errors.As(err, &rejected) {
+		form.Errors.Add("promo_code", promo.Message(err))
+		api.Fail(w, api.Invalid(form.Errors))
+		return
+	} else if errors.Is(err, payments.ErrDeclined) {
+		api.Fail(w, api.NewError(http.StatusPaymentRequired, api.CodePaymentDeclined, "the payment was declined"))
+		return
+	} else if errors.Is(err, repository.ErrUnavailable) {
+		api.Fail(w, api.NewError(http.StatusConflict, api.CodeConflict, "the room is no longer available for these dates"))
+		return
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	res = lines[0]
+
+	w.Header().Set("Location", fmt.Sprintf("%s/reservations/%s", api.Prefix, res.ManageToken))
+	api.Respond(w, http.StatusCreated, struct {
+		Reservation api.Reservation `json:"reservation"`
+		Quote       api.Quote       `json:"quote"`
+	}{api.NewReservation(res, m.manageLink(res)), api.NewQuote(quote, m.App.Currency, deposit)}, nil)
+}
+
+// APIReservations lists the reservations of a property in arrival order. The filters are those of the
+// reservation lists: arrival_from, arrival_to, q and new_only
+func (m *Repository) APIReservations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, e := api.ParsePage(q)
+	if e != nil {
+		api.Fail(w, e)
+		return
+	}
+
+	form := forms.New(nil)
+	f := models.ReservationFilter{
+		NewOnly: q.Get("new_only") == "true",
+		Search:  strings.TrimSpace(q.Get("q")),
+	}
+	for field, day := range map[string]*time.Time{"arrival_from": &f.ArrivalFrom, "arrival_to": &f.ArrivalTo} {
+		if s := q.Get(field); s != "" {
+			d, err := time.Parse(dates.Layout, s)
+			if err != nil {
+				form.Errors.Add(field, "Must be a date such as 2050-01-31")
+			}
+			*day = d
+		}
+	}
+	if !form.Valid() {
+		api.Fail(w, api.Invalid(form.Errors))
+		return
+	}
+
+	reservations, total, err := m.DB.ReservationsPage(helpers.PropertyFromContext(r.Context()).ID, f, page.Offset(), page.PerPage)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	out := make([]api.Reservation, len(reservations))
+	for i, res := range reservations {
+		out[i] = api.NewReservation(res, "")
+	}
+
+	api.Respond(w, http.StatusOK, out, page.Meta(total))
+}
+
+// APIShowReservation shows the reservation a guest manage token points to
+func (m *Repository) APIShowReservation(w http.ResponseWriter, r *http.Request) {
+	res, err := m.DB.GetReservationByManageToken(chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		api.Fail(w, api.NotFound("reservation"))
+		return
+	} else if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	api.Respond(w, http.StatusOK, api.NewReservation(res, m.manageLink(res)), nil)
+}
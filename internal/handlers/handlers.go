@@ -1,23 +1,49 @@
 package handlers
 
 import (
+	"crypto/subtle"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/eldicela/bookings/internal/apikeys"
+	"github.com/eldicela/bookings/internal/assignment"
+	"github.com/eldicela/bookings/internal/audit"
+	"github.com/eldicela/bookings/internal/calsync"
 	"github.com/eldicela/bookings/internal/config"
+	"github.com/eldicela/bookings/internal/dates"
 	"github.com/eldicela/bookings/internal/driver"
+	"github.com/eldicela/bookings/internal/export"
+	"github.com/eldicela/bookings/internal/folio"
 	"github.com/eldicela/bookings/internal/forms"
+	"github.com/eldicela/bookings/internal/frontdesk"
+	"github.com/eldicela/bookings/internal/guests"
 	"github.com/eldicela/bookings/internal/helpers"
+	"github.com/eldicela/bookings/internal/housekeeping"
+	"github.com/eldicela/bookings/internal/ical"
+	"github.com/eldicela/bookings/internal/importer"
+	"github.com/eldicela/bookings/internal/invoice"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/money"
+	"github.com/eldicela/bookings/internal/payments"
+	"github.com/eldicela/bookings/internal/pricing"
+	"github.com/eldicela/bookings/internal/promo"
 	"github.com/eldicela/bookings/internal/render"
+	"github.com/eldicela/bookings/internal/reporting"
 	"github.com/eldicela/bookings/internal/repository"
 	"github.com/eldicela/bookings/internal/repository/dbrepo"
+	"github.com/eldicela/bookings/internal/timeline"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -26,23 +52,26 @@ var Repo *Repository
 
 // Respository is the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App     *config.AppConfig
+	DB      repository.DatabaseRepo
+	Limiter *apikeys.Limiter
 }
 
 // NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewMysqlRepo(db.SQL, a),
+		App:     a,
+		DB:      dbrepo.NewMysqlRepo(db.SQL, a),
+		Limiter: apikeys.NewLimiter(),
 	}
 }
 
 // NewTestRepo creates a new repository
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
+		App:     a,
+		DB:      dbrepo.NewTestingRepo(a),
+		Limiter: apikeys.NewLimiter(),
 	}
 }
 
@@ -81,24 +110,89 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res.Room.RoomName = room.RoomName
+	res.Room = room
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
-	sd := res.StartDate.Format("2006-01-02")
-	ed := res.EndDate.Format("2006-01-02")
+	m.renderMakeReservation(w, r, forms.New(nil), res)
+}
+
+// promoCodeFor returns the promo code the guest entered on the make reservation page, checked against res
+func (m *Repository) promoCodeFor(r *http.Request, res models.Reservation) (models.PromoCode, error) {
+	code := m.App.Session.GetString(r.Context(), "promo_code")
+	if code == "" {
+		return models.PromoCode{}, nil
+	}
+
+	p, err := m.DB.GetPromoCodeByCode(helpers.PropertyFromContext(r.Context()).ID, code)
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+
+	err = promo.Validate(p, res.RoomId, res.StartDate, res.EndDate, helpers.PropertyFromContext(r.Context()).Today())
+	if err != nil {
+		return models.PromoCode{}, err
+	}
+
+	return p, nil
+}
+
+// quoteFor prices res with the discount of code and the active taxes and fees of the property of its room
+func (m *Repository) quoteFor(res models.Reservation, code models.PromoCode) (pricing.Quote, error) {
+	quote := pricing.NewQuote(res.Room, res.StartDate, res.EndDate)
+	quote.ApplyExtraGuests(res.Room, res.Guests())
+
+	if code.ID > 0 {
+		quote.ApplyDiscount(fmt.Sprintf("Promo code %s", code.Code), promo.Discount(code, quote.Total))
+	}
+
+	rules, err := m.DB.ActiveTaxFeeRules(res.Room.PropertyId)
+	if err != nil {
+		return quote, err
+	}
+	quote.ApplyTaxesAndFees(rules, res.Guests())
+
+	return quote, nil
+}
+
+// renderMakeReservation renders the reservation form with the quote and deposit for res
+func (m *Repository) renderMakeReservation(w http.ResponseWriter, r *http.Request, form *forms.Form, res models.Reservation) {
+	code, err := m.promoCodeFor(r, res)
+	if err != nil {
+		m.App.Session.Remove(r.Context(), "promo_code")
+		form.Errors.Add("promo_code", promo.Message(err))
+	}
+
+	lines := m.bookingLines(r, res)
+
+	quote, _, err := m.bookingQuote(r, lines, code)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
 	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	stringMap["start_date"] = res.StartDate.Format(dates.Layout)
+	stringMap["end_date"] = res.EndDate.Format(dates.Layout)
+	stringMap["currency"] = m.App.Currency
+
+	intMap := make(map[string]int)
+	intMap["deposit"] = quote.Deposit(m.App.DepositPercent)
+
+	stringMap["promo_code"] = code.Code
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
+	if len(lines) > 1 {
+		data["lines"] = lines
+	}
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-		Form:      forms.New(nil),
+		Form:      form,
 		Data:      data,
 		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
@@ -143,6 +237,10 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Email = r.Form.Get("email")
+	if _, ok := m.App.Session.Get(r.Context(), "group_rooms").([]models.Reservation); !ok {
+		// the party of a group booking was split over the rooms when they were chosen
+		reservation.Adults, reservation.Children = guestCounts(r)
+	}
 
 	// reservation := models.Reservation{
 	// 	FirstName: r.Form.Get("first_name"),
@@ -154,41 +252,279 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	// 	RoomId:    roomId,
 	// }
 
+	lines := m.bookingLines(r, reservation)
+
+	// the rooms were assigned when the guest picked the types, someone may have booked them since
+	var taken []int
+	for i, line := range lines {
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(line.Stay(), line.RoomId)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !available || containsId(taken, line.RoomId) {
+			room, err := m.assignRoom(helpers.PropertyFromContext(r.Context()).ID, line, taken...)
+			if errors.Is(err, assignment.ErrNoRoom) {
+				m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for your dates")
+				http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+				return
+			} else if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			lines[i].RoomId = room.ID
+			lines[i].Room = room
+		}
+		taken = append(taken, lines[i].RoomId)
+	}
+	reservation = lines[0]
+	if len(lines) > 1 {
+		m.App.Session.Put(r.Context(), "group_rooms", lines[1:])
+	}
+
 	form := forms.New(r.PostForm)
 
+	code, err := m.promoCodeFor(r, reservation)
+	if err != nil {
+		m.App.Session.Remove(r.Context(), "promo_code")
+		form.Errors.Add("promo_code", promo.Message(err))
+	}
+
+	quote, quotes, err := m.bookingQuote(r, lines, code)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	deposit := quote.Deposit(m.App.DepositPercent)
+
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	for _, line := range lines {
+		checkOccupancy(form, line)
+	}
+	if deposit > 0 {
+		form.Required("payment_token")
+	}
 
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
+		m.renderMakeReservation(w, r, form, reservation)
+		return
+	}
 
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
+	lines, err = m.book(r, booking{
+		lines:        lines,
+		quote:        quote,
+		quotes:       quotes,
+		code:         code,
+		paymentToken: r.Form.Get("payment_token"),
+		via:          "online",
+	})
+	var rejected *promoRejectedError
+	if errors.As(err, &rejected) {
+		m.App.Session.Remove(r.Context(), "promo_code")
+		form.Errors.Add("promo_code", promo.Message(err))
+		m.renderMakeReservation(w, r, form, reservation)
+		return
+	} else if errors.Is(err, payments.ErrDeclined) {
+		form.Errors.Add("payment_token", "The payment was declined, please use another card")
+		m.renderMakeReservation(w, r, form, reservation)
+		return
+	} else if errors.Is(err, repository.ErrUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for your dates")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	reservation = lines[0]
+
+	m.App.Session.Remove(r.Context(), "promo_code")
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	if len(lines) > 1 {
+		m.App.Session.Put(r.Context(), "group_rooms", lines[1:])
+	}
+	m.App.Session.Put(r.Context(), "deposit", deposit)
+	http.Redirect(w, r, propertyURL(r, "/reservation-summary"), http.StatusSeeOther)
+}
+
+// booking is a booking checked and priced, ready to be made. The first line is the master reservation
+type booking struct {
+	lines        []models.Reservation
+	quote        pricing.Quote
+	quotes       []pricing.Quote
+	code         models.PromoCode
+	paymentToken string
+	via          string
+}
+
+// promoRejectedError is returned by book when the promo code of a booking can no longer be redeemed,
+// promo.Message tells the guest why
+type promoRejectedError struct {
+	err error
+}
+
+func (e *promoRejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *promoRejectedError) Unwrap() error {
+	return e.err
+}
+
+// book makes a booking, on the site or through the API. It redeems the promo code, authorizes the deposit
+// before anything is written, writes the reservations and their folio at once, captures the deposit and
+// sends the confirmation. A failed step undoes the ones before it and is returned as a
+// *promoRejectedError, payments.ErrDeclined or repository.ErrUnavailable when it is the guest's to fix
+func (m *Repository) book(r *http.Request, b booking) ([]models.Reservation, error) {
+	lines := b.lines
+	reservation := lines[0]
+	deposit := b.quote.Deposit(m.App.DepositPercent)
+
+	var redeemed int
+	if b.code.ID > 0 && b.quote.Discount() > 0 {
+		err := m.DB.RedeemPromoCode(b.code.ID)
+		if errors.Is(err, promo.ErrExhausted) || errors.Is(err, promo.ErrInactive) || errors.Is(err, promo.ErrUnknownCode) {
+			return nil, &promoRejectedError{err: err}
+		} else if err != nil {
+			return nil, err
+		}
+		redeemed = b.code.ID
+
+		for i := range lines {
+			if b.quotes[i].Discount() > 0 {
+				lines[i].PromoCodeId = b.code.ID
+				lines[i].Discount = b.quotes[i].Discount()
+			}
+		}
+	}
+
+	description := reservation.Room.RoomName
+	if len(lines) > 1 {
+		description = fmt.Sprintf("%d rooms", len(lines))
+	}
+
+	// authorize the deposit before anything is written, so a declined card leaves no reservation behind
+	var auth payments.Result
+	if deposit > 0 {
+		var err error
+		auth, err = m.App.Payments.Authorize(payments.Charge{
+			Amount:      deposit,
+			Currency:    m.App.Currency,
+			Token:       b.paymentToken,
+			Description: fmt.Sprintf("Deposit for %s from %s", description, reservation.StartDate.Format(dates.Layout)),
+		})
+		if err != nil {
+			m.abandonBooking(auth, redeemed)
+			return nil, err
+		}
+	}
+
+	guestId, err := m.DB.FindOrCreateGuest(guests.FromReservation(reservation))
+	if err != nil {
+		m.abandonBooking(auth, redeemed)
+		return nil, err
+	}
+
+	for i := range lines {
+		lines[i].GuestId = guestId
+		lines[i].ManageToken, err = helpers.RandomToken(16)
+		if err != nil {
+			m.abandonBooking(auth, redeemed)
+			return nil, err
+		}
+	}
+
+	var groupName string
+	if len(lines) > 1 {
+		groupName = fmt.Sprintf("%s %s party", reservation.FirstName, reservation.LastName)
+	}
+
+	// the whole booking is charged to the folio of the master reservation
+	charges, paid := m.bookingCharges(b.quote, auth, deposit)
+
+	lines, err = m.DB.CreateReservations(groupName, lines, charges, paid)
+	if err != nil {
+		m.abandonBooking(auth, redeemed)
+		return nil, err
+	}
+
+	for _, line := range lines {
+		m.logEvent(r, line.ID, timeline.EventCreated, fmt.Sprintf("Booked %s, %s for %s", b.via, line.Room.RoomName, line.Stay()))
+	}
+
+	m.captureDeposit(auth, deposit)
+
+	// the booking is made by now, an error here must not send the guest to book again
+	err = m.sendBookingEmails(r, lines, b.quote, deposit)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	return lines, nil
+}
+
+// bookingCharges returns what a new booking posts to the folio of its master reservation, written together
+// with its reservations: the lines of its quote and the deposit authorized as auth
+func (m *Repository) bookingCharges(quote pricing.Quote, auth payments.Result, deposit int) ([]models.FolioEntry, []models.Payment) {
+	var entries []models.FolioEntry
+	for _, line := range quote.Lines {
+		entries = append(entries, models.FolioEntry{
+			EntryType:    folio.TypeCharge,
+			Category:     line.Category,
+			Description:  line.Description,
+			Amount:       line.Amount,
+			TaxFeeRuleId: line.RuleId,
 		})
+	}
+
+	if deposit == 0 {
+		return entries, nil
+	}
+
+	entries = append(entries, models.FolioEntry{
+		EntryType:   folio.TypePayment,
+		Category:    folio.CategoryDeposit,
+		Description: fmt.Sprintf("Deposit %s", auth.TransactionID),
+		Amount:      deposit,
+	})
+
+	return entries, []models.Payment{{
+		Provider:      m.App.Payments.Name(),
+		TransactionId: auth.TransactionID,
+		Amount:        deposit,
+		Currency:      m.App.Currency,
+		Status:        auth.Status,
+	}}
+}
+
+// captureDeposit settles the deposit of a booking once the booking is written. A failure is only logged,
+// the booking stands and the authorization can be captured from the gateway dashboard
+func (m *Repository) captureDeposit(auth payments.Result, deposit int) {
+	if deposit == 0 {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	captured, err := m.App.Payments.Capture(auth.TransactionID, deposit)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.ErrorLog.Println(err)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		RoomId:        reservation.RoomId,
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		ReservationId: newReservationID,
-		RestrictionId: 1,
+	err = m.DB.UpdatePaymentStatus(auth.TransactionID, captured.Status)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
 	}
+}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+// sendBookingEmails sends the confirmation of a new booking to the guest and notifies the property
+func (m *Repository) sendBookingEmails(r *http.Request, lines []models.Reservation, quote pricing.Quote, deposit int) error {
+	reservation := lines[0]
+
+	property, err := m.propertyOf(reservation)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		return err
 	}
 
 	// Send Notifications - to guest
@@ -196,590 +532,3764 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	htmlMessage := fmt.Sprintf(`
 	<strong>Reservation Confirmation</strong> <br>
 	Dear %s: <br>
-	This is confirm your reservation from %s to %s
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+	This is confirm your reservation of %s from %s to %s <br>
+	Check-in is from %s, check-out is by %s <br>
+	%s
+	Deposit paid: %s %s <br>
+	You can view your reservation and download your invoice at <a href="%s">%s</a>
+	`, reservation.FirstName, roomNames(lines), reservation.StartDate.Format(dates.Layout), reservation.EndDate.Format(dates.Layout),
+		property.CheckInTime, property.CheckOutTime, quoteTable(quote, m.App.Currency), money.Format(deposit), m.App.Currency,
+		m.manageLink(reservation), m.manageLink(reservation))
 
 	msg := models.MailData{
 		To:       reservation.Email,
-		From:     "me@here.com",
+		From:     property.Email,
 		Subject:  "Reservation Confirmation",
 		Content:  htmlMessage,
 		Template: "basic.html",
 	}
 
+	if m.App.AttachInvoice {
+		inv, err := m.invoiceFor(reservation)
+		if err != nil {
+			return err
+		}
+		msg.Attachments = append(msg.Attachments, models.MailAttachment{
+			Name:     inv.Filename(),
+			MimeType: "application/pdf",
+			Data:     inv.PDF(),
+		})
+	}
+
 	m.App.MailChan <- msg
+	m.logEvent(r, reservation.ID, timeline.EventEmailed, fmt.Sprintf("Confirmation emailed to %s", reservation.Email))
 
 	htmlMessage = fmt.Sprintf(`
 	<strong>Reservation Notification</strong> <br>
 	A reservation has been made for %s from %s to %s
-	`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+	`, roomNames(lines), reservation.StartDate.Format(dates.Layout), reservation.EndDate.Format(dates.Layout))
 
 	msg = models.MailData{
-		To:      "me@here.com",
-		From:    "me@here.com",
+		To:      property.Email,
+		From:    property.Email,
 		Subject: "Reservation Notification",
 		Content: htmlMessage,
 	}
 	m.App.MailChan <- msg
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	return nil
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// quoteTable itemises a quote for emails
+func quoteTable(quote pricing.Quote, currency string) string {
+	var b strings.Builder
+
+	b.WriteString("<table>")
+	for _, l := range quote.Lines {
+		fmt.Fprintf(&b, `<tr><td>%s</td><td align="right">%s %s</td></tr>`, html.EscapeString(l.Description), money.Format(l.Amount), currency)
+	}
+	fmt.Fprintf(&b, `<tr><th align="left">Total</th><th align="right">%s %s</th></tr>`, money.Format(quote.Total), currency)
+	b.WriteString("</table>")
+
+	return b.String()
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// propertyOf returns the property the room of a reservation belongs to
+func (m *Repository) propertyOf(res models.Reservation) (models.Property, error) {
+	return m.DB.GetPropertyByID(res.Room.PropertyId)
 }
 
-// Availability renders the availability page
-func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+// propertyURL prefixes path with the slug of the property the request is about
+func propertyURL(r *http.Request, path string) string {
+	return helpers.PropertyFromContext(r.Context()).URL(path)
 }
 
-// PostAvailability get the post from the form
-func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
+// manageLink returns the link guests use to view their reservation
+func (m *Repository) manageLink(res models.Reservation) string {
+	return fmt.Sprintf("%s/reservations/manage/%s", m.App.BaseURL, res.ManageToken)
+}
 
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
-	if err != nil {
-		helpers.ServerError(w, err)
-	}
-	endDate, err := time.Parse(layout, end)
+// invoiceFor builds the invoice of a reservation from its folio
+func (m *Repository) invoiceFor(res models.Reservation) (invoice.Invoice, error) {
+	entries, err := m.DB.GetFolioEntriesForReservation(res.ID)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		return invoice.Invoice{}, err
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	property, err := m.propertyOf(res)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
-
-	if len(rooms) == 0 {
-		//no availability
-		m.App.Session.Put(r.Context(), "error", "No Availability")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
-		return
+		return invoice.Invoice{}, err
 	}
 
-	data := make(map[string]interface{})
-	data["rooms"] = rooms
-
-	res := models.Reservation{
-		StartDate: startDate,
-		EndDate:   endDate,
+	issuer := invoice.Issuer{
+		Name:    property.Name,
+		Address: property.Address,
+		Email:   property.Email,
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
-
-	render.Template(w, r, "chose-room.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
-
+	return invoice.New(issuer, res, entries, m.App.Currency, time.Now().In(property.Location())), nil
 }
 
-type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomId    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+// writeInvoice sends the invoice PDF as a download
+func writeInvoice(w http.ResponseWriter, inv invoice.Invoice) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", inv.Filename()))
+	w.Write(inv.PDF())
 }
 
-// AvailabilityJson handles reques for availability and send json response
-func (m *Repository) AvailabilityJson(w http.ResponseWriter, r *http.Request) {
-
-	sd := r.Form.Get("start")
-	ed := r.Form.Get("end")
-
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	EndDate, _ := time.Parse(layout, ed)
-
-	roomId, _ := strconv.Atoi(r.Form.Get("room_id"))
-
-	available, _ := m.DB.SearchAvailabilityByDatesByRoomID(startDate, EndDate, roomId)
-	resp := jsonResponse{
-		OK:        available,
-		Message:   "",
-		StartDate: sd,
-		EndDate:   ed,
-		RoomId:    strconv.Itoa(roomId),
+// ManageReservation shows a reservation to the guest holding its manage link
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, err := m.DB.GetReservationByManageToken(chi.URLParam(r, "token"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Reservation not found")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
 	}
 
-	out, err := json.MarshalIndent(resp, "", "     ")
+	entries, err := m.DB.GetFolioEntriesForReservation(res.ID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
-}
-
-// Contact renders the contact page
-func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
-}
-
-// ReservationSummary displays reservation summary page
-func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.ErrorLog.Println("can't get item from session")
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
-	}
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format(dates.Layout)
+	stringMap["end_date"] = res.EndDate.Format(dates.Layout)
+	stringMap["currency"] = m.App.Currency
 
-	m.App.Session.Remove(r.Context(), "reservation")
+	intMap := make(map[string]int)
+	intMap["balance_due"] = folio.Balance(entries)
 
 	data := make(map[string]interface{})
-	data["reservation"] = reservation
-
-	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.EndDate.Format("2006-01-02")
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	data["reservation"] = res
 
-	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
-		Data:      data,
+	render.Template(w, r, "manage-reservation.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
 	})
 }
 
-// ChooseRoom displays list of available rooms
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	roomId, err := strconv.Atoi(chi.URLParam(r, "id"))
+// ManageReservationInvoice downloads the invoice from the guest manage page
+func (m *Repository) ManageReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	res, err := m.DB.GetReservationByManageToken(chi.URLParam(r, "token"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, http.StatusNotFound)
 		return
 	}
 
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
+	inv, err := m.invoiceFor(res)
+	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	res.RoomId = roomId
-
-	m.App.Session.Put(r.Context(), "reservation", res)
-
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	writeInvoice(w, inv)
 }
 
-// BookRoom takes URL parameters, builds a sessional variable, and takes user to make res screen
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+// Calendar feeds cover the nights from feedDaysPast days ago to feedDaysAhead days ahead
+const (
+	feedDaysPast  = 30
+	feedDaysAhead = 730
+)
 
-	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	sd := r.URL.Query().Get("s")
-	ed := r.URL.Query().Get("e")
+// RoomCalendarFeed serves the reservations and blocks of a room as an iCalendar feed, for the other
+// platforms the room is listed on. The feed is not found without the secret token of the room
+func (m *Repository) RoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	roomId, err := strconv.Atoi(chi.URLParam(r, "room"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	room, err := m.DB.GetRoomByID(roomId)
+	token := []byte(r.URL.Query().Get("token"))
+	if err != nil || room.ICalToken == "" || subtle.ConstantTimeCompare(token, []byte(room.ICalToken)) != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
 
-	var res models.Reservation
+	property, err := m.DB.GetPropertyByID(room.PropertyId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	room, err := m.DB.GetRoomByID(roomID)
+	today := property.Today()
+	period := dates.Range{Start: today.AddDate(0, 0, -feedDaysPast), End: today.AddDate(0, 0, feedDaysAhead)}
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, period)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
+	sort.Slice(restrictions, func(i, j int) bool {
+		return restrictions[i].StartDate.Before(restrictions[j].StartDate)
+	})
 
-	res.RoomId = roomID
-	res.StartDate = startDate
-	res.EndDate = endDate
-	res.Room.RoomName = room.RoomName
+	host := r.Host
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	// guests are not named, the feed only tells other platforms the nights are taken
+	cal := ical.Calendar{Name: fmt.Sprintf("%s %s", property.Name, room.RoomName)}
+	for _, rr := range restrictions {
+		summary := "Not available"
+		if rr.ReservationId > 0 {
+			summary = "Reserved"
+		}
+		cal.Events = append(cal.Events, ical.Event{
+			UID:     ical.UID("restriction", rr.ID, host),
+			Summary: summary,
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+			Stamp:   rr.UpdatedAt,
+		})
+	}
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"room-%d.ics\"", room.ID))
+	err = ical.Write(w, cal)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
 }
 
-// ShowLogin Shows the login screen
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+// abandonBooking gives back the deposit authorization and promo code use of a booking that could not be stored
+func (m *Repository) abandonBooking(auth payments.Result, promoCodeId int) {
+	if auth.TransactionID != "" {
+		_, err := m.App.Payments.Refund(auth.TransactionID, auth.Amount)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
 
-	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
-	})
+	if promoCodeId > 0 {
+		err := m.DB.ReleasePromoCode(promoCodeId)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
 }
 
-// PostShowLogin handles logging the user in
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.RenewToken(r.Context())
-
+// ApplyPromoCode checks the promo code entered on the make reservation page and keeps it for the booking
+func (m *Repository) ApplyPromoCode(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		helpers.ServerError(w, err)
+		return
 	}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
-	form := forms.New(r.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
-	if !form.Valid() {
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form: form,
-		})
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "cant get reservation from server")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	code := promo.Normalize(r.Form.Get("promo_code"))
+	if code == "" {
+		m.App.Session.Remove(r.Context(), "promo_code")
+		http.Redirect(w, r, propertyURL(r, "/make-reservation"), http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "promo_code", code)
+
+	_, err = m.promoCodeFor(r, res)
 	if err != nil {
-		log.Println(err)
+		m.App.Session.Remove(r.Context(), "promo_code")
+		m.App.Session.Put(r.Context(), "error", promo.Message(err))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Promo code applied")
+	}
 
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	http.Redirect(w, r, propertyURL(r, "/make-reservation"), http.StatusSeeOther)
+}
+
+// PaymentWebhook receives status notifications from the payment gateway
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	event, err := m.App.Payments.VerifyWebhook(payload, r.Header.Get("X-Payment-Signature"))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !payments.ValidStatus(event.Status) {
+		m.App.ErrorLog.Printf("unknown payment status %q for %s", event.Status, event.TransactionID)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.UpdatePaymentStatus(event.TransactionID, event.Status)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
-// Logout logs a user out
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+// Generals renders the room page
+func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+}
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+// Majors renders the room page
+func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
 }
 
-// AdminDashBoard renders the dashboard in admin tools
-func (m *Repository) AdminDashBoard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+// Availability renders the availability page
+func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
 }
 
-// AdminNewReservations shows all new reservations in admin tool
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+// guestCounts reads the adults and children fields of a search or booking form. A party has at least one adult
+func guestCounts(r *http.Request) (int, int) {
+	adults, _ := strconv.Atoi(r.Form.Get("adults"))
+	children, _ := strconv.Atoi(r.Form.Get("children"))
+
+	if adults < 1 {
+		adults = 1
+	}
+	if children < 0 {
+		children = 0
+	}
+
+	return adults, children
+}
+
+// checkOccupancy adds a form error when the party does not fit the reserved room
+func checkOccupancy(form *forms.Form, res models.Reservation) {
+	if res.Room.MaxOccupancy > 0 && res.Guests() > res.Room.MaxOccupancy {
+		form.Errors.Add("adults", fmt.Sprintf("This room sleeps at most %d guests", res.Room.MaxOccupancy))
+	}
+}
+
+// PostAvailability get the post from the form
+func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	property := helpers.PropertyFromContext(r.Context())
+
+	stay, err := dates.Parse(r.Form.Get("start"), r.Form.Get("end"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid dates")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+	if stay.Start.Before(property.Today()) {
+		m.App.Session.Put(r.Context(), "error", "Arrival can't be in the past")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	adults, children := guestCounts(r)
+
+	wanted, _ := strconv.Atoi(r.Form.Get("rooms"))
+	if wanted < 1 {
+		wanted = 1
+	}
+	if wanted > adults {
+		m.App.Session.Put(r.Context(), "error", "Each room needs at least one adult")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
 
-	reservations, err := m.DB.AllNewReservations()
+	// the party is spread over the rooms, so each room only has to sleep its share
+	perRoom := (adults + children + wanted - 1) / wanted
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(stay, perRoom, property.ID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	if len(rooms) == 0 {
+		//no availability
+		m.App.Session.Put(r.Context(), "error", "No Availability")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["offers"] = assignment.Offers(rooms)
 
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+	intMap := make(map[string]int)
+	intMap["rooms"] = wanted
+
+	res := models.Reservation{
+		StartDate: stay.Start,
+		EndDate:   stay.End,
+		Adults:    adults,
+		Children:  children,
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	m.App.Session.Remove(r.Context(), "group_rooms")
+
+	render.Template(w, r, "chose-room.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
 	})
+
 }
 
-// AdminAllReservations shows all reservations in admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+type jsonResponse struct {
+	OK        bool   `json:"ok"`
+	Message   string `json:"message"`
+	RoomId    string `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// AvailabilityJson handles reques for availability and send json response
+func (m *Repository) AvailabilityJson(w http.ResponseWriter, r *http.Request) {
+
+	sd := r.Form.Get("start")
+	ed := r.Form.Get("end")
+
+	roomId, _ := strconv.Atoi(r.Form.Get("room_id"))
+
+	var available bool
+	stay, err := dates.Parse(sd, ed)
+	if err == nil && !stay.Start.Before(helpers.PropertyFromContext(r.Context()).Today()) {
+		available, _ = m.DB.SearchAvailabilityByDatesByRoomID(stay, roomId)
+	}
+	resp := jsonResponse{
+		OK:        available,
+		Message:   "",
+		StartDate: sd,
+		EndDate:   ed,
+		RoomId:    strconv.Itoa(roomId),
+	}
 
-	reservations, err := m.DB.AllReservations()
+	out, err := json.MarshalIndent(resp, "", "     ")
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// Contact renders the contact page
+func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+}
+
+// ReservationSummary displays reservation summary page
+func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.ErrorLog.Println("can't get item from session")
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	others, _ := m.App.Session.Get(r.Context(), "group_rooms").([]models.Reservation)
+
+	m.App.Session.Remove(r.Context(), "reservation")
+	m.App.Session.Remove(r.Context(), "group_rooms")
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["reservation"] = reservation
+	if len(others) > 0 {
+		data["lines"] = append([]models.Reservation{reservation}, others...)
+	}
 
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+	sd := reservation.StartDate.Format(dates.Layout)
+	ed := reservation.EndDate.Format(dates.Layout)
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = sd
+	stringMap["end_date"] = ed
+	stringMap["currency"] = m.App.Currency
+
+	intMap := make(map[string]int)
+	intMap["deposit"] = m.App.Session.PopInt(r.Context(), "deposit")
+
+	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
-// AdminShowReservation shows the reservation in the admin tool
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+// ChooseRoom books the room type the guest picked and assigns one of its physical rooms
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	roomTypeId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	res.RoomTypeId = roomTypeId
+
+	room, err := m.assignRoom(helpers.PropertyFromContext(r.Context()).ID, res)
+	if errors.Is(err, assignment.ErrNoRoom) {
+		m.App.Session.Put(r.Context(), "error", "No Availability")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res.RoomId = room.ID
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	m.App.Session.Remove(r.Context(), "group_rooms")
+
+	http.Redirect(w, r, propertyURL(r, "/make-reservation"), http.StatusSeeOther)
+}
+
+// ChooseRooms books several rooms at once, as many of each room type as the guest asked for. The party
+// is spread over the rooms and a physical room is assigned to each
+func (m *Repository) ChooseRooms(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		helpers.ServerError(w, errors.New("cannot get from session"))
+		return
+	}
+
+	var typeIds []int
+	for _, v := range r.PostForm["room_type_ids"] {
+		roomTypeId, _ := strconv.Atoi(v)
+		quantity, _ := strconv.Atoi(r.Form.Get(fmt.Sprintf("quantity_%d", roomTypeId)))
+		for i := 0; i < quantity; i++ {
+			typeIds = append(typeIds, roomTypeId)
+		}
+	}
+
+	if len(typeIds) == 0 || len(typeIds) > res.Adults {
+		m.App.Session.Put(r.Context(), "error", "Pick at least one room, and no more rooms than adults")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	adults, children := splitParty(res.Adults, res.Children, len(typeIds))
+
+	var lines []models.Reservation
+	var taken []int
+	for i, roomTypeId := range typeIds {
+		line := res
+		line.RoomTypeId = roomTypeId
+		line.Adults = adults[i]
+		line.Children = children[i]
+
+		room, err := m.assignRoom(helpers.PropertyFromContext(r.Context()).ID, line, taken...)
+		if errors.Is(err, assignment.ErrNoRoom) {
+			m.App.Session.Put(r.Context(), "error", "Sorry, there are not enough rooms of that type to sleep your party")
+			http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		line.RoomId = room.ID
+		line.Room = room
+		taken = append(taken, room.ID)
+		lines = append(lines, line)
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", lines[0])
+	m.App.Session.Put(r.Context(), "group_rooms", lines[1:])
+
+	http.Redirect(w, r, propertyURL(r, "/make-reservation"), http.StatusSeeOther)
+}
+
+// splitParty spreads adults and children over rooms as evenly as possible. Spare children go to the
+// rooms with fewer adults
+func splitParty(adults, children, rooms int) ([]int, []int) {
+	a := make([]int, rooms)
+	c := make([]int, rooms)
+
+	for i := 0; i < rooms; i++ {
+		a[i] = adults / rooms
+		if i < adults%rooms {
+			a[i]++
+		}
+		c[i] = children / rooms
+		if i >= rooms-children%rooms {
+			c[i]++
+		}
+	}
+
+	return a, c
+}
+
+func containsId(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// bookingLines returns the rooms of the booking being made, res first. The other rooms share its
+// dates and contact details
+func (m *Repository) bookingLines(r *http.Request, res models.Reservation) []models.Reservation {
+	lines := []models.Reservation{res}
+
+	others, _ := m.App.Session.Get(r.Context(), "group_rooms").([]models.Reservation)
+	for _, line := range others {
+		line.FirstName = res.FirstName
+		line.LastName = res.LastName
+		line.Email = res.Email
+		line.Phone = res.Phone
+		line.StartDate = res.StartDate
+		line.EndDate = res.EndDate
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// bookingQuote prices each room of a booking and adds them up. A percentage promo code comes off
+// every room it is valid for, a fixed amount only off the first one
+func (m *Repository) bookingQuote(r *http.Request, lines []models.Reservation, code models.PromoCode) (pricing.Quote, []pricing.Quote, error) {
+	today := helpers.PropertyFromContext(r.Context()).Today()
+
+	var quotes []pricing.Quote
+	used := false
+	for _, line := range lines {
+		c := code
+		if c.ID > 0 && (used && c.DiscountType == promo.TypeFixed ||
+			promo.Validate(c, line.RoomId, line.StartDate, line.EndDate, today) != nil) {
+			c = models.PromoCode{}
+		}
+		if c.ID > 0 {
+			used = true
+		}
+
+		q, err := m.quoteFor(line, c)
+		if err != nil {
+			return pricing.Quote{}, nil, err
+		}
+		if len(lines) > 1 {
+			q = q.Labelled(line.Room.RoomName)
+		}
+		quotes = append(quotes, q)
+	}
+
+	return pricing.Combine(quotes...), quotes, nil
+}
+
+// roomNames describes the rooms of a booking for emails and payment descriptions
+func roomNames(lines []models.Reservation) string {
+	names := make([]string, len(lines))
+	for i, line := range lines {
+		names[i] = line.Room.RoomName
+	}
+	return strings.Join(names, ", ")
+}
+
+// assignRoom picks the physical room of the reservation's room type that leaves the calendar least fragmented.
+// Rooms without a type are picked among themselves. Rooms already taken by other rooms of the same booking
+// are skipped
+func (m *Repository) assignRoom(propertyId int, res models.Reservation, taken ...int) (models.Room, error) {
+	rooms, err := m.DB.GetRoomsByType(propertyId, res.RoomTypeId)
+	if err != nil {
+		return models.Room{}, err
+	}
+
+	around := dates.Range{
+		Start: res.StartDate.AddDate(0, 0, -assignment.Window),
+		End:   res.EndDate.AddDate(0, 0, assignment.Window),
+	}
+
+	var candidates []assignment.Candidate
+	for _, room := range rooms {
+		if room.MaxOccupancy > 0 && res.Guests() > room.MaxOccupancy {
+			continue
+		}
+		if containsId(taken, room.ID) {
+			continue
+		}
+
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, around)
+		if err != nil {
+			return models.Room{}, err
+		}
+
+		candidates = append(candidates, assignment.Candidate{
+			Room:  room,
+			Stays: assignment.StaysFrom(restrictions),
+		})
+	}
+
+	return assignment.Pick(candidates, res.StartDate, res.EndDate)
+}
+
+// BookRoom takes URL parameters, builds a sessional variable, and takes user to make res screen
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	stay, err := dates.Parse(r.URL.Query().Get("s"), r.URL.Query().Get("e"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid dates")
+		http.Redirect(w, r, propertyURL(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+
+	var res models.Reservation
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Can't get room from database")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	res.RoomId = roomID
+	res.RoomTypeId = room.RoomTypeId
+	res.StartDate = stay.Start
+	res.EndDate = stay.End
+	res.Adults = 1
+	res.Room.RoomName = room.RoomName
+
+	// the room page books the room's type, so the assignment may settle on a sibling room
+	if res.RoomTypeId > 0 {
+		assigned, err := m.assignRoom(helpers.PropertyFromContext(r.Context()).ID, res)
+		if err == nil {
+			res.RoomId = assigned.ID
+			res.Room.RoomName = assigned.RoomName
+		} else if !errors.Is(err, assignment.ErrNoRoom) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	m.App.Session.Remove(r.Context(), "group_rooms")
+
+	http.Redirect(w, r, propertyURL(r, "/make-reservation"), http.StatusSeeOther)
+}
+
+// ShowLogin Shows the login screen
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin handles logging the user in
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(email, password)
+	if err != nil {
+		log.Println(err)
+
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout logs a user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminDashBoard renders the dashboard in admin tools: the arrivals, departures and in house guests of
+// today and tomorrow
+func (m *Repository) AdminDashBoard(w http.ResponseWriter, r *http.Request) {
+	property := helpers.PropertyFromContext(r.Context())
+	today := property.Today()
+
+	// today then tomorrow
+	var days [][]frontdesk.List
+	for _, day := range []time.Time{today, today.AddDate(0, 0, 1)} {
+		var lists []frontdesk.List
+		for _, name := range frontdesk.Lists {
+			list, err := m.frontDeskList(property.ID, name, day, today)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			lists = append(lists, list)
+		}
+		days = append(days, lists)
+	}
+
+	data := make(map[string]interface{})
+	data["days"] = days
+
+	stringMap := make(map[string]string)
+	stringMap["currency"] = m.App.Currency
+
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPrintFrontDeskList shows one list of the dashboard ready to print
+func (m *Repository) AdminPrintFrontDeskList(w http.ResponseWriter, r *http.Request) {
+	property := helpers.PropertyFromContext(r.Context())
+	today := property.Today()
+
+	name := chi.URLParam(r, "list")
+	if !frontdesk.ValidList(name) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	day := today
+	if d, err := time.Parse(dates.Layout, r.URL.Query().Get("date")); err == nil {
+		day = d
+	}
+
+	list, err := m.frontDeskList(property.ID, name, day, today)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["list"] = list
+	data["property"] = property
+
+	stringMap := make(map[string]string)
+	stringMap["currency"] = m.App.Currency
+
+	render.Template(w, r, "admin-dashboard-print.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// frontDeskList loads one list of the front desk report of a day
+func (m *Repository) frontDeskList(propertyId int, name string, day, today time.Time) (frontdesk.List, error) {
+	list := frontdesk.List{
+		Name:       name,
+		Title:      frontdesk.Titles[name],
+		Day:        day,
+		Actionable: day.Equal(today),
+	}
+
+	var err error
+	switch name {
+	case frontdesk.ListArrivals:
+		list.Reservations, err = m.DB.ArrivalsForDay(propertyId, day)
+	case frontdesk.ListDepartures:
+		list.Reservations, err = m.DB.DeparturesForDay(propertyId, day)
+	case frontdesk.ListInHouse:
+		list.Reservations, err = m.DB.InHouseForDay(propertyId, day)
+	}
+
+	return list, err
+}
+
+// AdminCheckIn records that the guests of a reservation have arrived
+func (m *Repository) AdminCheckIn(w http.ResponseWriter, r *http.Request) {
+	property := helpers.PropertyFromContext(r.Context())
+
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if err := frontdesk.CanCheckIn(res, property.Today()); err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s %s: %s", res.FirstName, res.LastName, err))
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	after := res
+	after.CheckedInAt = time.Now()
+	err := m.DB.CheckInReservation(res.ID, after.CheckedInAt)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, res.ID, res, after)
+	m.logEvent(r, res.ID, timeline.EventCheckIn, fmt.Sprintf("Checked in to %s", res.Room.RoomName))
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s checked in to %s", res.FirstName, res.LastName, res.Room.RoomName))
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// AdminCheckOut records that the guests of a reservation have left. Their room is dirty from then on
+func (m *Repository) AdminCheckOut(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if err := frontdesk.CanCheckOut(res); err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s %s: %s", res.FirstName, res.LastName, err))
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	after := res
+	after.CheckedOutAt = time.Now()
+	err := m.DB.CheckOutReservation(res.ID, after.CheckedOutAt)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, res.ID, res, after)
+	m.logEvent(r, res.ID, timeline.EventCheckOut, fmt.Sprintf("Checked out of %s", res.Room.RoomName))
+
+	room, err := m.DB.GetRoomByID(res.RoomId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if room.HousekeepingStatus != housekeeping.StatusOutOfOrder && room.HousekeepingStatus != housekeeping.StatusDirty {
+		dirty := room
+		dirty.HousekeepingStatus = housekeeping.StatusDirty
+		err = m.DB.UpdateRoomStatus(dirty)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, audit.ActionUpdate, audit.EntityRoom, room.ID, room, dirty)
+	}
+
+	entries, err := m.DB.GetFolioEntriesForReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	msg := fmt.Sprintf("%s %s checked out of %s", res.FirstName, res.LastName, res.Room.RoomName)
+	if balance := folio.Balance(entries); balance > 0 {
+		msg += fmt.Sprintf(", leaving a balance due of %s %s", money.Format(balance), m.App.Currency)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// adminReservation loads the reservation in the URL, writing the error response when there is none
+// in the current property
+func (m *Repository) adminReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	res, err := m.DB.GetReservationById(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && res.Room.PropertyId != helpers.PropertyFromContext(r.Context()).ID) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return res, false
+	}
+
+	return res, true
+}
+
+// adminRoom loads the room in the URL, writing the error response when there is none in the current property
+func (m *Repository) adminRoom(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	room, err := m.DB.GetRoomByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && room.PropertyId != helpers.PropertyFromContext(r.Context()).ID) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+// adminGroup loads the group booking in the URL, writing the error response when there is none
+// in the current property
+func (m *Repository) adminGroup(w http.ResponseWriter, r *http.Request) (models.ReservationGroup, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	group, err := m.DB.GetReservationGroupByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && group.PropertyId != helpers.PropertyFromContext(r.Context()).ID) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return group, false
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return group, false
+	}
+
+	return group, true
+}
+
+// AdminNewReservations shows all new reservations in admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	m.renderReservationList(w, r, "new", "admin-new-reservations.page.tmpl")
+}
+
+// AdminAllReservations shows all reservations in admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.renderReservationList(w, r, "all", "admin-all-reservations.page.tmpl")
+}
+
+func (m *Repository) renderReservationList(w http.ResponseWriter, r *http.Request, src, tmpl string) {
+	var reservations []models.Reservation
+
+	err := m.DB.EachReservation(helpers.PropertyFromContext(r.Context()).ID, reservationFilter(r, src), func(res models.Reservation) error {
+		reservations = append(reservations, res)
+		return nil
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["columns"] = export.Columns
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	render.Template(w, r, tmpl, &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(r.URL.Query()),
+	})
+}
+
+// reservationFilter reads the filters of a reservation list from the from, to and q query parameters
+func reservationFilter(r *http.Request, src string) models.ReservationFilter {
+	q := r.URL.Query()
+
+	f := models.ReservationFilter{
+		NewOnly: src == "new",
+		Search:  strings.TrimSpace(q.Get("q")),
+	}
+	if d, err := time.Parse(dates.Layout, q.Get("from")); err == nil {
+		f.ArrivalFrom = d
+	}
+	if d, err := time.Parse(dates.Layout, q.Get("to")); err == nil {
+		f.ArrivalTo = d
+	}
+
+	return f
+}
+
+// AdminExportReservations downloads a reservation list, with the filters it is shown with, as a CSV or
+// XLSX spreadsheet. Rows are written as they are read from the database
+func (m *Repository) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "src")
+	if src != "new" && src != "all" {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != export.FormatCSV && format != export.FormatXLSX {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	columns, err := export.Select(r.URL.Query()["columns"])
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	property := helpers.PropertyFromContext(r.Context())
+	filename := fmt.Sprintf("reservations-%s-%s.%s", src, property.Today().Format(dates.Layout), format)
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	ew, err := export.NewWriter(w, format, columns)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the response has started, so a failure can only be logged and leaves the file cut short
+	err = m.DB.EachReservation(property.ID, reservationFilter(r, src), ew.Write)
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		m.App.ErrorLog.Println("export of reservations failed:", err)
+	}
+}
+
+// AdminShowReservation shows the reservation in the admin tool
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "src")
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	stringMap["year"] = year
+	stringMap["month"] = month
+
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderShowReservation(w, r, res, stringMap, nil)
+}
+
+// renderShowReservation renders the reservation page with res in the edit form. When another staff
+// member saved the reservation while res was being edited, saved is their version and the differences
+// are shown so the edit can be applied again on top of it
+func (m *Repository) renderShowReservation(w http.ResponseWriter, r *http.Request, res models.Reservation,
+	stringMap map[string]string, saved *models.Reservation) {
+	id := res.ID
+
+	resPayments, err := m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	entries, err := m.DB.GetFolioEntriesForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(helpers.PropertyFromContext(r.Context()).ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	notes, err := m.DB.GetNotesForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	events, err := m.DB.GetEventsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap["currency"] = m.App.Currency
+
+	intMap := make(map[string]int)
+	intMap["balance_due"] = folio.Balance(entries)
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["rooms"] = rooms
+	data["payments"] = resPayments
+	data["folio"] = folio.Ledger(entries)
+	data["folio_categories"] = folio.Categories
+	data["timeline"] = timeline.Build(notes, events)
+
+	if saved != nil {
+		data["saved"] = *saved
+		data["conflict"] = timeline.Diff(*saved, res)
+		for i := len(events) - 1; i >= 0; i-- {
+			if events[i].EventType == timeline.EventEdited {
+				data["last_edit"] = events[i]
+				break
+			}
+		}
+		w.WriteHeader(http.StatusConflict)
+	}
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := res.ID
+	before := res
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+	res.Adults, res.Children = guestCounts(r)
+	res.Version, _ = strconv.Atoi(r.Form.Get("version"))
+
+	form := forms.New(r.PostForm)
+	checkOccupancy(form, res)
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", form.Errors.Get("adults"))
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateReservation(res)
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		// show what the other person saved, with this edit ready to apply on top of it
+		saved, err := m.DB.GetReservationById(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		res.Version = saved.Version
+		stringMap["year"] = r.Form.Get("year")
+		stringMap["month"] = r.Form.Get("month")
+		m.renderShowReservation(w, r, res, stringMap, &saved)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the update may have linked the reservation to another guest profile
+	if saved, err := m.DB.GetReservationById(id); err == nil {
+		res = saved
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, id, before, res)
+	if changes := timeline.Changes(before, res); changes != "" {
+		m.logEvent(r, id, timeline.EventEdited, changes)
+	}
+
+	month := r.Form.Get("month")
+	year := r.Form.Get("year")
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+
+}
+
+// AdminPostReservationNote adds a note to a reservation. Notes cannot be edited or deleted afterwards
+func (m *Repository) AdminPostReservationNote(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := res.ID
+	src := chi.URLParam(r, "src")
+	redirect := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	body := strings.TrimSpace(r.Form.Get("body"))
+	if body == "" {
+		m.App.Session.Put(r.Context(), "error", "The note is empty")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	note := models.ReservationNote{
+		ReservationId: id,
+		UserId:        m.App.Session.GetInt(r.Context(), "user_id"),
+		Body:          body,
+	}
+
+	err = m.DB.InsertReservationNote(note)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCreate, audit.EntityNote, id, nil, note)
+
+	m.App.Session.Put(r.Context(), "flash", "Note added")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// logEvent records an event on the timeline of a reservation, by the staff member signed in if any.
+// The timeline is a record only, so failing to write it is logged and does not fail the request
+func (m *Repository) logEvent(r *http.Request, reservationId int, eventType, description string) {
+	err := m.DB.InsertReservationEvent(models.ReservationEvent{
+		ReservationId: reservationId,
+		UserId:        m.App.Session.GetInt(r.Context(), "user_id"),
+		EventType:     eventType,
+		Description:   description,
+	})
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// audit records a change made from the admin pages in the audit log, with who made it and from where.
+// Like the timeline, a failed write is logged rather than failing the change it describes
+func (m *Repository) audit(r *http.Request, action, entity string, entityId int, before, after interface{}) {
+	err := m.DB.InsertAuditEntry(models.AuditEntry{
+		UserId:   m.App.Session.GetInt(r.Context(), "user_id"),
+		Action:   action,
+		Entity:   entity,
+		EntityId: entityId,
+		Before:   audit.Snapshot(before),
+		After:    audit.Snapshot(after),
+		IP:       audit.IP(r),
+	})
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// AdminReassignRoom moves a reservation to another physical room that is free for its dates
+func (m *Repository) AdminReassignRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	roomId, _ := strconv.Atoi(r.Form.Get("room_id"))
+
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := res.ID
+	show := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	if roomId != res.RoomId {
+		room, err := m.DB.GetRoomByID(roomId)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && room.PropertyId != helpers.PropertyFromContext(r.Context()).ID) {
+			m.App.Session.Put(r.Context(), "error", "Choose a room of this property")
+			http.Redirect(w, r, show, http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		after := res
+		after.Room = room
+		form := forms.New(nil)
+		checkOccupancy(form, after)
+		if !form.Valid() {
+			m.App.Session.Put(r.Context(), "error", form.Errors.Get("adults"))
+			http.Redirect(w, r, show, http.StatusSeeOther)
+			return
+		}
+
+		err = m.DB.ReassignReservation(res, roomId)
+		if errors.Is(err, repository.ErrUnavailable) {
+			m.App.Session.Put(r.Context(), "error", "That room is not free for these dates")
+			http.Redirect(w, r, show, http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		after.RoomId = room.ID
+		m.audit(r, audit.ActionUpdate, audit.EntityReservation, id, res, after)
+		m.logEvent(r, id, timeline.EventRoom, fmt.Sprintf("Moved from %s to %s", res.Room.RoomName, room.RoomName))
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room changed")
+
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+	if year == "" {
+		http.Redirect(w, r, show, http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminRoomTypes lists room types with their physical rooms
+func (m *Repository) AdminRoomTypes(w http.ResponseWriter, r *http.Request) {
+	m.renderRoomTypes(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderRoomTypes(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	types, err := m.DB.AllRoomTypes(helpers.PropertyFromContext(r.Context()).ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(helpers.PropertyFromContext(r.Context()).ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room_types"] = types
+	data["rooms"] = rooms
+
+	stringMap := make(map[string]string)
+	stringMap["base_url"] = m.App.BaseURL
+
+	render.Template(w, r, "admin-room-types.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostRoomType creates a room type
+func (m *Repository) AdminPostRoomType(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	if !form.Valid() {
+		m.renderRoomTypes(w, r, form)
+		return
+	}
+
+	roomType := models.RoomType{
+		PropertyId:  helpers.PropertyFromContext(r.Context()).ID,
+		Name:        form.Get("name"),
+		Description: form.Get("description"),
+	}
+
+	roomType.ID, err = m.DB.InsertRoomType(roomType)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCreate, audit.EntityRoomType, roomType.ID, nil, roomType)
+
+	m.App.Session.Put(r.Context(), "flash", "Room type created")
+	http.Redirect(w, r, "/admin/room-types", http.StatusSeeOther)
+}
+
+// AdminPostRoomRoomType moves a physical room to another room type
+func (m *Repository) AdminPostRoomRoomType(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	roomId := room.ID
+	roomTypeId, _ := strconv.Atoi(r.Form.Get("room_type_id"))
+
+	if roomTypeId > 0 {
+		types, err := m.DB.AllRoomTypes(room.PropertyId)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		found := false
+		for _, t := range types {
+			if t.ID == roomTypeId {
+				found = true
+			}
+		}
+		if !found {
+			m.App.Session.Put(r.Context(), "error", "Choose a room type of this property")
+			http.Redirect(w, r, "/admin/room-types", http.StatusSeeOther)
+			return
+		}
+	}
+
+	err = m.DB.UpdateRoomType(roomId, roomTypeId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	after := room
+	after.RoomTypeId = roomTypeId
+	m.audit(r, audit.ActionUpdate, audit.EntityRoom, roomId, room, after)
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/room-types", http.StatusSeeOther)
+}
+
+// AdminPostRoomICalToken gives a room a new calendar feed URL. The old URL stops working, so platforms
+// still using it have to be given the new one
+func (m *Repository) AdminPostRoomICalToken(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	roomId := room.ID
+
+	token, err := helpers.RandomToken(20)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateRoomICalToken(roomId, token)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	after := room
+	after.ICalToken = token
+	m.audit(r, audit.ActionUpdate, audit.EntityRoom, roomId, room, after)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s has a new calendar feed URL", room.RoomName))
+	http.Redirect(w, r, "/admin/room-types", http.StatusSeeOther)
+}
+
+// AdminReservationInvoice downloads the invoice of a reservation
+func (m *Repository) AdminReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	inv, err := m.invoiceFor(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	writeInvoice(w, inv)
+}
+
+// AdminRefundPayment refunds a payment made for a reservation
+func (m *Repository) AdminRefundPayment(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := reservation.ID
+	paymentId, _ := strconv.Atoi(chi.URLParam(r, "paymentId"))
+	src := chi.URLParam(r, "src")
+
+	p, err := m.DB.GetPaymentById(paymentId)
+	if err != nil || p.ReservationId != id {
+		m.App.Session.Put(r.Context(), "error", "Payment not found")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+
+	// only money taken can be given back, and only once
+	if p.Status != payments.StatusCaptured {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A payment that is %s cannot be refunded", p.Status))
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+
+	res, err := m.App.Payments.Refund(p.TransactionId, p.Amount)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "The gateway refused the refund")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+
+	// a refund is a negative payment, it raises the balance due again
+	err = m.DB.RefundPayment(p.ID, res.Status, models.FolioEntry{
+		ReservationId: id,
+		EntryType:     folio.TypePayment,
+		Category:      folio.CategoryRefund,
+		Description:   fmt.Sprintf("Refund %s", p.TransactionId),
+		Amount:        -p.Amount,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "The payment was refunded already")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	refunded := p
+	refunded.Status = res.Status
+	m.audit(r, audit.ActionRefund, audit.EntityPayment, p.ID, p, refunded)
+	m.logEvent(r, id, timeline.EventRefund, fmt.Sprintf("Refunded %s %s, payment %s", money.Format(p.Amount), p.Currency, p.TransactionId))
+
+	m.App.Session.Put(r.Context(), "flash", "Payment refunded")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+}
+
+// AdminPostFolioEntry posts a charge or payment on a reservation folio
+func (m *Repository) AdminPostFolioEntry(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := res.ID
+	src := chi.URLParam(r, "src")
+	redirect := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	form := forms.New(r.PostForm)
+	form.Required("entry_type", "category", "description", "amount")
+	form.IsAmount("amount")
+
+	entryType := form.Get("entry_type")
+	category := form.Get("category")
+
+	validCategory := false
+	for _, c := range folio.Categories[entryType] {
+		if c == category {
+			validCategory = true
+		}
+	}
+	if !validCategory {
+		form.Errors.Add("category", "Invalid category")
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Invalid folio entry")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	amount, _ := forms.ParseAmount(form.Get("amount"))
+	if category == folio.CategoryRefund {
+		amount = -amount
+	}
+
+	entry := models.FolioEntry{
+		ReservationId: id,
+		EntryType:     entryType,
+		Category:      category,
+		Description:   form.Get("description"),
+		Amount:        amount,
+	}
+
+	entry.ID, err = m.DB.InsertFolioEntry(entry)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCreate, audit.EntityFolioEntry, entry.ID, nil, entry)
+
+	m.logEvent(r, id, timeline.EventFolio, fmt.Sprintf("Posted %s %q of %s", entryType, form.Get("description"), money.Format(amount)))
+
+	m.App.Session.Put(r.Context(), "flash", "Entry posted")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// AdminVoidFolioEntry voids a folio entry by posting its reversal
+func (m *Repository) AdminVoidFolioEntry(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := res.ID
+	entryId, _ := strconv.Atoi(chi.URLParam(r, "entryId"))
+	src := chi.URLParam(r, "src")
+	redirect := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+
+	entries, err := m.DB.GetFolioEntriesForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	void, err := folio.Void(entries, entryId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	void.ID, err = m.DB.InsertFolioEntry(void)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionVoid, audit.EntityFolioEntry, entryId, nil, void)
+
+	m.logEvent(r, id, timeline.EventFolio, fmt.Sprintf("Voided %q", void.Description))
+
+	m.App.Session.Put(r.Context(), "flash", "Entry voided")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// AdminPromoCodes lists promo codes and shows the form to create one
+func (m *Repository) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	m.renderPromoCodes(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderPromoCodes(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	codes, err := m.DB.AllPromoCodes(helpers.PropertyFromContext(r.Context()).ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(helpers.PropertyFromContext(r.Context()).ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["promo_codes"] = codes
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostPromoCode creates a promo code
+func (m *Repository) AdminPostPromoCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "discount_type", "amount")
+
+	property := helpers.PropertyFromContext(r.Context())
+
+	p := models.PromoCode{
+		Code:         promo.Normalize(form.Get("code")),
+		Description:  form.Get("description"),
+		DiscountType: form.Get("discount_type"),
+		PropertyId:   property.ID,
+	}
+
+	if p.DiscountType == promo.TypeFixed {
+		form.IsAmount("amount")
+		p.Amount, _ = forms.ParseAmount(form.Get("amount"))
+	} else {
+		p.Amount, _ = strconv.Atoi(form.Get("amount"))
+	}
+	if promo.CheckValue(p.DiscountType, p.Amount) != nil {
+		form.Errors.Add("amount", "Invalid discount")
+	}
+
+	days := map[string]*time.Time{
+		"valid_from": &p.ValidFrom,
+		"valid_to":   &p.ValidTo,
+		"stay_from":  &p.StayFrom,
+		"stay_to":    &p.StayTo,
+	}
+	for field, d := range days {
+		if form.Get(field) == "" {
+			continue
+		}
+		*d, err = time.Parse(dates.Layout, form.Get(field))
+		if err != nil {
+			form.Errors.Add(field, "Invalid date")
+		}
+	}
+
+	if form.Get("max_uses") != "" {
+		p.MaxUses, err = strconv.Atoi(form.Get("max_uses"))
+		if err != nil || p.MaxUses < 0 {
+			form.Errors.Add("max_uses", "Invalid number")
+		}
+	}
+
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for _, v := range r.PostForm["room_ids"] {
+		roomId, _ := strconv.Atoi(v)
+		for _, room := range rooms {
+			if room.ID == roomId {
+				p.RoomIds = append(p.RoomIds, roomId)
+			}
+		}
+	}
+
+	if !form.Valid() {
+		m.renderPromoCodes(w, r, form)
+		return
+	}
+
+	p.ID, err = m.DB.InsertPromoCode(p)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCreate, audit.EntityPromoCode, p.ID, nil, p)
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code created")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminTogglePromoCode enables or disables a promo code
+func (m *Repository) AdminTogglePromoCode(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	active, _ := strconv.Atoi(chi.URLParam(r, "active"))
+
+	err := m.DB.UpdatePromoCodeActive(helpers.PropertyFromContext(r.Context()).ID, id, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityPromoCode, id, nil, map[string]int{"Active": active})
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminTaxesFees lists the tax and fee rules with what they collected over a period
+func (m *Repository) AdminTaxesFees(w http.ResponseWriter, r *http.Request) {
+	m.renderTaxesFees(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderTaxesFees(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	property := helpers.PropertyFromContext(r.Context())
+
+	// the period runs from the first of the month up to today, both days included
+	end := property.Today()
+	start := end.AddDate(0, 0, 1-end.Day())
+
+	if d, err := time.Parse(dates.Layout, r.URL.Query().Get("start")); err == nil {
+		start = d
+	}
+	if d, err := time.Parse(dates.Layout, r.URL.Query().Get("end")); err == nil {
+		end = d
+	}
+
+	from, to := dates.Range{Start: start, End: end.AddDate(0, 0, 1)}.Bounds(property.Location())
+
+	rules, err := m.DB.AllTaxFeeRules(property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	totals, err := m.DB.TaxFeeReport(from, to, property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var collected int
+	for _, t := range totals {
+		collected += t.Amount
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start"] = start.Format(dates.Layout)
+	stringMap["end"] = end.Format(dates.Layout)
+	stringMap["currency"] = m.App.Currency
+
+	intMap := make(map[string]int)
+	intMap["collected"] = collected
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["totals"] = totals
+	data["bases"] = pricing.Bases
+
+	render.Template(w, r, "admin-taxes-fees.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostTaxFeeRule creates a tax or fee rule
+func (m *Repository) AdminPostTaxFeeRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "category", "basis", "amount")
+	form.IsAmount("amount")
+
+	rule := models.TaxFeeRule{
+		Name:       form.Get("name"),
+		Category:   form.Get("category"),
+		Basis:      form.Get("basis"),
+		PropertyId: helpers.PropertyFromContext(r.Context()).ID,
+	}
+	rule.Amount, _ = forms.ParseAmount(form.Get("amount"))
+
+	if rule.Category != folio.CategoryTax && rule.Category != folio.CategoryFee {
+		form.Errors.Add("category", "Choose tax or fee")
+	}
+
+	validBasis := false
+	for _, b := range pricing.Bases {
+		if rule.Basis == b {
+			validBasis = true
+		}
+	}
+	if !validBasis {
+		form.Errors.Add("basis", "Choose how the rule is charged")
+	}
+
+	if !form.Valid() {
+		m.renderTaxesFees(w, r, form)
+		return
+	}
+
+	rule.ID, err = m.DB.InsertTaxFeeRule(rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCreate, audit.EntityTaxFeeRule, rule.ID, nil, rule)
+
+	m.App.Session.Put(r.Context(), "flash", "Rule created")
+	http.Redirect(w, r, "/admin/taxes-fees", http.StatusSeeOther)
+}
+
+// AdminToggleTaxFeeRule enables or disables a tax or fee rule
+func (m *Repository) AdminToggleTaxFeeRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	active, _ := strconv.Atoi(chi.URLParam(r, "active"))
+
+	err := m.DB.UpdateTaxFeeRuleActive(helpers.PropertyFromContext(r.Context()).ID, id, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityTaxFeeRule, id, nil, map[string]int{"Active": active})
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/taxes-fees", http.StatusSeeOther)
+}
+
+// DefaultProperty puts the property a request is about in its context. Admin pages use the property
+// picked on the properties page, public pages without a property slug the first property
+func (m *Repository) DefaultProperty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p models.Property
+		var err error
+
+		id := m.App.Session.GetInt(r.Context(), "property_id")
+		if id > 0 && strings.HasPrefix(r.URL.Path, "/admin") {
+			p, err = m.DB.GetPropertyByID(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				m.App.Session.Remove(r.Context(), "property_id")
+				id = 0
+			}
+		}
+		if id == 0 || errors.Is(err, sql.ErrNoRows) {
+			p, err = m.DB.GetDefaultProperty()
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithProperty(r.Context(), p)))
+	})
+}
+
+// PropertyBySlug puts the property named by the {property} URL parameter in the request context
+func (m *Repository) PropertyBySlug(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := m.DB.GetPropertyBySlug(chi.URLParam(r, "property"))
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithProperty(r.Context(), p)))
+	})
+}
+
+// staffProperties returns the properties the logged in user may manage. Users with access level 3
+// manage every property, as does anyone while the admin area is not behind a login
+func (m *Repository) staffProperties(r *http.Request) ([]models.Property, error) {
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	if userId == 0 {
+		return m.DB.AllProperties()
+	}
+
+	user, err := m.DB.GetUserByID(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user.AccessLevel >= 3 {
+		return m.DB.AllProperties()
+	}
+
+	return m.DB.PropertiesForUser(userId)
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// reservedSlugs are the top level paths a property slug would clash with
+var reservedSlugs = map[string]bool{
+	"about":                    true,
+	"admin":                    true,
+	"api":                      true,
+	"book-room":                true,
+	"chose-room":               true,
+	"contact":                  true,
+	"generals-quarters":        true,
+	"ical":                     true,
+	"majors-suite":             true,
+	"make-reservation":         true,
+	"payments":                 true,
+	"reservation-summary":      true,
+	"reservations":             true,
+	"search-availability":      true,
+	"search-availability-json": true,
+	"static":                   true,
+	"user":                     true,
+}
+
+// propertyFromForm reads and validates the property settings form
+func propertyFromForm(r *http.Request) (models.Property, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("name", "slug", "email", "timezone", "check_in_time", "check_out_time")
+	form.IsEmail("email")
+
+	p := models.Property{
+		Name:         form.Get("name"),
+		Slug:         strings.ToLower(strings.TrimSpace(form.Get("slug"))),
+		Email:        form.Get("email"),
+		Phone:        form.Get("phone"),
+		Address:      form.Get("address"),
+		Description:  form.Get("description"),
+		Timezone:     form.Get("timezone"),
+		CheckInTime:  form.Get("check_in_time"),
+		CheckOutTime: form.Get("check_out_time"),
+	}
+
+	if _, err := time.LoadLocation(p.Timezone); p.Timezone != "" && err != nil {
+		form.Errors.Add("timezone", "Unknown timezone, use a name like Europe/London")
+	}
+	for _, field := range []string{"check_in_time", "check_out_time"} {
+		if _, err := dates.ParseClock(form.Get(field)); form.Get(field) != "" && err != nil {
+			form.Errors.Add(field, "Use a 24 hour time like 15:00")
+		}
+	}
+
+	if p.Slug != "" && (!slugPattern.MatchString(p.Slug) || reservedSlugs[p.Slug]) {
+		form.Errors.Add("slug", "Use lowercase letters, digits and dashes, and not the name of a page")
+	}
+
+	return p, form
+}
+
+// propertyDefaults fills the new property form
+var propertyDefaults = models.Property{Timezone: "UTC", CheckInTime: "15:00", CheckOutTime: "11:00"}
+
+// AdminProperties lists the properties the user manages, with the settings and staff of the current one
+func (m *Repository) AdminProperties(w http.ResponseWriter, r *http.Request) {
+	current := helpers.PropertyFromContext(r.Context())
+	m.renderProperties(w, r, forms.New(nil), "", current, propertyDefaults)
+}
+
+func (m *Repository) renderProperties(w http.ResponseWriter, r *http.Request, form *forms.Form, posted string, edit, create models.Property) {
+	properties, err := m.staffProperties(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	current := helpers.PropertyFromContext(r.Context())
+	userIds, err := m.DB.GetPropertyUserIds(current.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	assigned := make(map[int]bool)
+	for _, id := range userIds {
+		assigned[id] = true
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["posted"] = posted
+
+	data := make(map[string]interface{})
+	data["properties"] = properties
+	data["users"] = users
+	data["assigned"] = assigned
+	data["edit"] = edit
+	data["create"] = create
+
+	render.Template(w, r, "admin-properties.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminSelectProperty switches the admin area to another property
+func (m *Repository) AdminSelectProperty(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	properties, err := m.staffProperties(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, p := range properties {
+		if p.ID == id {
+			m.App.Session.Put(r.Context(), "property_id", id)
+			m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Now managing %s", p.Name))
+			http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+			return
+		}
+	}
+
+	helpers.ClientError(w, http.StatusForbidden)
+}
+
+// AdminPostProperty creates a property
+func (m *Repository) AdminPostProperty(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	p, form := propertyFromForm(r)
+	if form.Valid() {
+		if _, err := m.DB.GetPropertyBySlug(p.Slug); err == nil {
+			form.Errors.Add("slug", "This slug is already used")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderProperties(w, r, form, "create", helpers.PropertyFromContext(r.Context()), p)
+		return
+	}
+
+	p.ID, err = m.DB.InsertProperty(p)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCreate, audit.EntityProperty, p.ID, nil, p)
+
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	if userId > 0 {
+		err = m.DB.UpdatePropertyUsers(p.ID, []int{userId})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Property created")
+	http.Redirect(w, r, "/admin/properties", http.StatusSeeOther)
+}
+
+// AdminUpdateProperty saves the settings of the current property
+func (m *Repository) AdminUpdateProperty(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	current := helpers.PropertyFromContext(r.Context())
+
+	p, form := propertyFromForm(r)
+	p.ID = current.ID
+	if form.Valid() && p.Slug != current.Slug {
+		if _, err := m.DB.GetPropertyBySlug(p.Slug); err == nil {
+			form.Errors.Add("slug", "This slug is already used")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderProperties(w, r, form, "edit", p, propertyDefaults)
+		return
+	}
+
+	err = m.DB.UpdateProperty(p)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityProperty, p.ID, current, p)
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/properties", http.StatusSeeOther)
+}
+
+// AdminPostPropertyStaff sets which staff work at the current property
+func (m *Repository) AdminPostPropertyStaff(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var userIds []int
+	for _, v := range r.PostForm["user_ids"] {
+		id, err := strconv.Atoi(v)
+		if err == nil {
+			userIds = append(userIds, id)
+		}
+	}
+
+	propertyId := helpers.PropertyFromContext(r.Context()).ID
+
+	before, err := m.DB.GetPropertyUserIds(propertyId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdatePropertyUsers(propertyId, userIds)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityProperty, propertyId,
+		map[string][]int{"UserIds": before}, map[string][]int{"UserIds": userIds})
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/properties", http.StatusSeeOther)
+}
+
+// AdminGuests lists guest profiles, with groups of likely duplicates to merge
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	list, err := m.DB.AllGuests(search)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["q"] = search
+	stringMap["currency"] = m.App.Currency
+
+	data := make(map[string]interface{})
+	data["guests"] = list
+	if search == "" {
+		data["duplicates"] = guests.Duplicates(list)
+	}
+
+	render.Template(w, r, "admin-guests.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminShowGuest shows a guest profile with all their past and upcoming stays
+func (m *Repository) AdminShowGuest(w http.ResponseWriter, r *http.Request) {
+	m.renderGuest(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderGuest(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	guest, err := m.DB.GetGuestByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservations, err := m.DB.GetReservationsForGuest(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// a stay is past once its departure day has come at the property being managed
+	today := helpers.PropertyFromContext(r.Context()).Today()
+	var upcoming, past []models.Reservation
+	for _, res := range reservations {
+		if res.EndDate.After(today) {
+			upcoming = append(upcoming, res)
+		} else {
+			past = append(past, res)
+		}
+	}
+
+	all, err := m.DB.AllGuests("")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var duplicates []models.Guest
+	for _, group := range guests.Duplicates(all) {
+		for _, g := range group {
+			if g.ID == id {
+				duplicates = group
+			}
+		}
+	}
+
+	if r.Method == http.MethodGet {
+		form = forms.New(url.Values{
+			"first_name": {guest.FirstName},
+			"last_name":  {guest.LastName},
+			"email":      {guest.Email},
+			"phone":      {guest.Phone},
+		})
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["currency"] = m.App.Currency
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["upcoming"] = upcoming
+	data["past"] = past
+	data["duplicates"] = duplicates
+
+	render.Template(w, r, "admin-guest-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostGuest saves the contact details of a guest profile
+func (m *Repository) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		m.renderGuest(w, r, form)
+		return
+	}
+
+	before, err := m.DB.GetGuestByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	after := before
+	after.FirstName = form.Get("first_name")
+	after.LastName = form.Get("last_name")
+	after.Email = form.Get("email")
+	after.Phone = form.Get("phone")
+
+	err = m.DB.UpdateGuest(after)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityGuest, id, before, after)
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", id), http.StatusSeeOther)
+}
+
+// AdminMergeGuest folds a duplicate profile into the one in the URL
+func (m *Repository) AdminMergeGuest(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	duplicateId, _ := strconv.Atoi(r.Form.Get("duplicate_id"))
+
+	if duplicateId == 0 || duplicateId == id {
+		m.App.Session.Put(r.Context(), "error", "Pick another profile to merge")
+		http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	duplicate, err := m.DB.GetGuestByID(duplicateId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.MergeGuests(id, duplicateId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionMerge, audit.EntityGuest, duplicateId, duplicate, map[string]int{"MergedInto": id})
+
+	m.App.Session.Put(r.Context(), "flash", "Profiles merged")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", id), http.StatusSeeOther)
+}
+
+// AdminGroups lists the group bookings of the property being managed
+func (m *Repository) AdminGroups(w http.ResponseWriter, r *http.Request) {
+	property := helpers.PropertyFromContext(r.Context())
+
+	groups, err := m.DB.AllReservationGroups(property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["currency"] = m.App.Currency
+
+	data := make(map[string]interface{})
+	data["groups"] = groups
+
+	render.Template(w, r, "admin-groups.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminShowGroup shows the rooms of a group booking. Its charges and payments are on the folio of the
+// master reservation
+func (m *Repository) AdminShowGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := m.adminGroup(w, r)
+	if !ok {
+		return
+	}
+
+	balance := 0
+	for _, res := range group.Reservations {
+		balance += res.BalanceDue
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["currency"] = m.App.Currency
+
+	intMap := make(map[string]int)
+	intMap["balance_due"] = balance
+
+	data := make(map[string]interface{})
+	data["group"] = group
+
+	render.Template(w, r, "admin-group-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostGroup renames a group booking
+func (m *Repository) AdminPostGroup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	group, ok := m.adminGroup(w, r)
+	if !ok {
+		return
+	}
+	id := group.ID
+
+	name := strings.TrimSpace(r.Form.Get("name"))
+	if name == "" {
+		m.App.Session.Put(r.Context(), "error", "The group needs a name")
+		http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateReservationGroupName(id, name)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	after := group
+	after.Name = name
+	m.audit(r, audit.ActionUpdate, audit.EntityGroup, id, group, after)
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+}
+
+// AdminPostGroupReservation adds an existing reservation to a group booking
+func (m *Repository) AdminPostGroupReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	group, ok := m.adminGroup(w, r)
+	if !ok {
+		return
+	}
+	id := group.ID
+	reservationId, _ := strconv.Atoi(r.Form.Get("reservation_id"))
+
+	res, err := m.DB.GetReservationById(reservationId)
+	if errors.Is(err, sql.ErrNoRows) || reservationId == 0 ||
+		(err == nil && res.Room.PropertyId != group.PropertyId) {
+		m.App.Session.Put(r.Context(), "error", "Reservation not found")
+		http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if res.GroupId > 0 && res.GroupId != id {
+		m.App.Session.Put(r.Context(), "error", "That reservation already belongs to another group")
+		http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateReservationGroup(reservationId, id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, reservationId,
+		map[string]int{"GroupId": res.GroupId}, map[string]int{"GroupId": id})
+	m.logEvent(r, reservationId, timeline.EventGroup, fmt.Sprintf("Added to group %d", id))
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation added to the group")
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+}
+
+// AdminRemoveGroupReservation takes a reservation out of a group booking. The master reservation holds
+// the group folio and cannot be removed
+func (m *Repository) AdminRemoveGroupReservation(w http.ResponseWriter, r *http.Request) {
+	group, ok := m.adminGroup(w, r)
+	if !ok {
+		return
+	}
+	id := group.ID
+	reservationId, _ := strconv.Atoi(chi.URLParam(r, "reservationId"))
+
+	if reservationId == group.MasterReservationId {
+		m.App.Session.Put(r.Context(), "error", "The master reservation cannot be removed from its group")
+		http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.RemoveFromReservationGroup(reservationId, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityReservation, reservationId,
+		map[string]int{"GroupId": id}, map[string]int{"GroupId": 0})
+	m.logEvent(r, reservationId, timeline.EventGroup, fmt.Sprintf("Removed from group %s", group.Name))
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation removed from the group")
+	http.Redirect(w, r, fmt.Sprintf("/admin/groups/%d", id), http.StatusSeeOther)
+}
+
+// auditPageSize is how many audit log entries are shown per page
+const auditPageSize = 50
+
+// AdminAuditLog browses the audit log, filtered by staff member, action, entity and date
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	form := forms.New(q)
+
+	filter := models.AuditFilter{
+		Action: q.Get("action"),
+		Entity: q.Get("entity"),
+	}
+	filter.UserId, _ = strconv.Atoi(q.Get("user_id"))
+	filter.EntityId, _ = strconv.Atoi(q.Get("entity_id"))
+
+	// dates are days at the property being managed, the end day included
+	loc := helpers.PropertyFromContext(r.Context()).Location()
+	if v := q.Get("from"); v != "" {
+		day, err := time.ParseInLocation(dates.Layout, v, loc)
+		if err != nil {
+			form.Errors.Add("from", "Invalid date")
+		}
+		filter.From = day
+	}
+	if v := q.Get("to"); v != "" {
+		day, err := time.ParseInLocation(dates.Layout, v, loc)
+		if err != nil {
+			form.Errors.Add("to", "Invalid date")
+		} else {
+			filter.To = day.AddDate(0, 0, 1)
+		}
+	}
+
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	var entries []models.AuditEntry
+	if form.Valid() {
+		var err error
+		entries, err = m.DB.AuditEntries(filter, auditPageSize+1, (page-1)*auditPageSize)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	// one more entry than a page was asked for to know whether there is a next page
+	more := len(entries) > auditPageSize
+	if more {
+		entries = entries[:auditPageSize]
+	}
+
+	changed := make(map[int][]string)
+	for _, e := range entries {
+		changed[e.ID] = audit.Changed(e.Before, e.After)
+	}
+
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the page links keep the filters
+	q.Del("page")
+	stringMap := make(map[string]string)
+	stringMap["filters"] = q.Encode()
+
+	intMap := make(map[string]int)
+	intMap["page"] = page
+	if more {
+		intMap["next"] = page + 1
+	}
+	if page > 1 {
+		intMap["previous"] = page - 1
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["changed"] = changed
+	data["users"] = users
+	data["actions"] = audit.Actions
+	data["entities"] = audit.Entities
+
+	render.Template(w, r, "admin-audit-log.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminReservationsCalendar Displays the reservation calendarss
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+
+	property := helpers.PropertyFromContext(r.Context())
+
+	// Asume that there is no month/year specified
+	now := property.Today()
+
+	if r.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	nextMonth := next.Format("01")
+	nextMonthYear := next.Format("2006")
+
+	lastMonth := last.Format("01")
+	lastMonthYear := last.Format("2006")
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = nextMonth
+	stringMap["next_month_year"] = nextMonthYear
+	stringMap["last_month"] = lastMonth
+	stringMap["last_month_year"] = lastMonthYear
+
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+
+	//get the first and last days of the month
+
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data["rooms"] = rooms
+
+	month := dates.Range{Start: firstOfMonth, End: firstOfMonth.AddDate(0, 1, 0)}
+
+	for _, x := range rooms {
+		// create maps
+		reservationMap := make(map[string]int)
+		blockMap := make(map[string]int)
+		outOfOrderMap := make(map[string]int)
+		externalMap := make(map[string]int)
+
+		// for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
+		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-2")] = 0
+			blockMap[d.Format("2006-01-2")] = 0
+			outOfOrderMap[d.Format("2006-01-2")] = 0
+			externalMap[d.Format("2006-01-2")] = 0
+		}
+
+		//  get all restrictions for the current room
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, month)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, y := range restrictions {
+			if y.ReservationId > 0 {
+				// its a reservation, shown on the nights it covers
+				for _, d := range (dates.Range{Start: y.StartDate, End: y.EndDate}).Days() {
+					reservationMap[d.Format("2006-01-2")] = y.ReservationId
+				}
+			} else if y.RestrictionId == housekeeping.RestrictionOutOfOrder {
+				// the room is out of order, managed from the housekeeping board
+				for _, d := range (dates.Range{Start: y.StartDate, End: y.EndDate}).Days() {
+					outOfOrderMap[d.Format("2006-01-2")] = y.ID
+				}
+			} else if y.RestrictionId == calsync.RestrictionExternal {
+				// booked on another platform, synced from its calendar
+				for _, d := range (dates.Range{Start: y.StartDate, End: y.EndDate}).Days() {
+					externalMap[d.Format("2006-01-2")] = y.ICalSourceId
+				}
+			} else {
+				// its a block
+
+				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("out_of_order_map_%d", x.ID)] = outOfOrderMap
+		data[fmt.Sprintf("external_map_%d", x.ID)] = externalMap
+
+		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+// AdminProcessReservation marks a reservation as processed
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+
+	src := chi.URLParam(r, "src")
+
+	before, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := before.ID
+
+	err := m.DB.UpdateProcessedForReservation(id, 1)
+	if err == nil {
+		after := before
+		after.Processed = 1
+		m.audit(r, audit.ActionProcess, audit.EntityReservation, id, before, after)
+		m.logEvent(r, id, timeline.EventProcessed, "Marked as processed")
+	}
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+
+}
+
+// AdminDeleteReservation delete a reservation
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+
+	src := chi.URLParam(r, "src")
+
+	before, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := before.ID
+
+	// payments and folio entries are kept, so a reservation that has any
+	// can only be cancelled
+	paid, err := m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	entries, err := m.DB.GetFolioEntriesForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(paid) > 0 || len(entries) > 0 {
+		m.App.Session.Put(r.Context(), "error", "A reservation with payments or folio entries cannot be deleted, cancel it instead")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, audit.ActionDelete, audit.EntityReservation, id, before, nil)
+	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+
+}
+
+// AdminCancelReservation cancels a reservation. Unlike a delete the reservation, its folio and its payments
+// are kept, only the nights it held are freed
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	before, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+	id := before.ID
+	redirect := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), id)
+
+	if !before.CheckedInAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "A reservation that is checked in cannot be cancelled")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	after := before
+	after.CancelledAt = time.Now()
+	err := m.DB.CancelReservation(id, after.CancelledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Reservation is already cancelled")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionCancel, audit.EntityReservation, id, before, after)
+	m.logEvent(r, id, timeline.EventCancelled, "Cancelled")
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation cancelled")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// AdminPostReservationsCalendar handles post of reservation calendar
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("y"))
+	month, _ := strconv.Atoi(r.Form.Get("m"))
+
+	// process blocks
+	rooms, err := m.DB.AllRooms(helpers.PropertyFromContext(r.Context()).ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	for _, x := range rooms {
+		// Get the block map from the session. loop thrugh entire map if we have an entry in the map
+		// that does not not exist in our posted data, and if the restriction id> 0, then it is a block
+		//  we need to remove
+
+		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
+		// valCounter := 1
+		for name, value := range curMap {
+
+			if val, ok := curMap[name]; ok {
+				// only pay attention to values > 0, and that are not in the form post
+				// the rest are just placeholders for days without blocks
+
+				if val > 0 {
+					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
+						// delete the restriction by id
+						err := m.DB.DeleteBlockById(value)
+						if err != nil {
+							log.Println(err)
+						} else {
+							m.audit(r, audit.ActionDelete, audit.EntityBlock, value,
+								map[string]interface{}{"RoomId": x.ID, "Date": name}, nil)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// now handle new blocks
+
+	for name, _ := range r.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			exploaded := strings.Split(name, "_")
+			roomID, _ := strconv.Atoi(exploaded[2])
+			t, _ := time.Parse("2006-01-2", exploaded[3])
+			// insert a new block
+			blockId, err := m.DB.InsertBlockForRoom(roomID, t)
+			if err != nil {
+				log.Println(err)
+			} else {
+				m.audit(r, audit.ActionCreate, audit.EntityBlock, blockId,
+					nil, map[string]interface{}{"RoomId": roomID, "Date": t.Format(dates.Layout)})
+			}
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// AdminHousekeeping shows the housekeeping board of a day: each room with its status and the cleaning
+// tasks planned for that day. Tasks are planned in the background or from AdminPostHousekeepingPlan
+func (m *Repository) AdminHousekeeping(w http.ResponseWriter, r *http.Request) {
+	property := helpers.PropertyFromContext(r.Context())
+	today := property.Today()
+
+	day := today
+	if d, err := time.Parse(dates.Layout, r.URL.Query().Get("date")); err == nil {
+		day = d
+	}
+
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	tasks, err := m.DB.HousekeepingTasksForDay(property.ID, day)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	byRoom := make(map[int][]models.HousekeepingTask)
+	for _, t := range tasks {
+		byRoom[t.RoomId] = append(byRoom[t.RoomId], t)
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["tasks"] = byRoom
+	data["counts"] = housekeeping.Counts(rooms)
+	data["statuses"] = housekeeping.Statuses
+
+	stringMap := make(map[string]string)
+	stringMap["date"] = day.Format(dates.Layout)
+	stringMap["prev"] = day.AddDate(0, 0, -1).Format(dates.Layout)
+	stringMap["next"] = day.AddDate(0, 0, 1).Format(dates.Layout)
+	stringMap["today"] = today.Format(dates.Layout)
+	stringMap["tomorrow"] = today.AddDate(0, 0, 1).Format(dates.Layout)
+
+	render.Template(w, r, "admin-housekeeping.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPostHousekeepingPlan plans the cleaning tasks of a day from its stays, without waiting for the
+// background planning
+func (m *Repository) AdminPostHousekeepingPlan(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	property := helpers.PropertyFromContext(r.Context())
+	today := property.Today()
+
+	day := today
+	if d, err := time.Parse(dates.Layout, r.Form.Get("date")); err == nil {
+		day = d
+	}
+
+	err = housekeeping.Run(m.DB, property.ID, day, today)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Tasks planned")
+	http.Redirect(w, r, "/admin/housekeeping?date="+day.Format(dates.Layout), http.StatusSeeOther)
+}
+
+// AdminPostRoomStatus sets the housekeeping status of a room. Out of order rooms are blocked on the
+// calendar until the day they are back in service, so they cannot be booked
+func (m *Repository) AdminPostRoomStatus(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	property := helpers.PropertyFromContext(r.Context())
+	today := property.Today()
+	redirect := "/admin/housekeeping?date=" + url.QueryEscape(r.Form.Get("date"))
+
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.Form.Get("status")
+	if !housekeeping.ValidStatus(status) {
+		m.App.Session.Put(r.Context(), "error", "Choose a room status")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	after := room
+	after.HousekeepingStatus = status
+	after.StatusNote = strings.TrimSpace(r.Form.Get("note"))
+	after.OutOfOrderUntil = time.Time{}
+
+	var block models.RoomRestriction
+	if status == housekeeping.StatusOutOfOrder {
+		until, err := time.Parse(dates.Layout, r.Form.Get("until"))
+		if err != nil || !until.After(today) {
+			m.App.Session.Put(r.Context(), "error", "Out of order rooms need a date after today they are back in service")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+
+		after.OutOfOrderUntil = until
+		block = models.RoomRestriction{
+			StartDate:     today,
+			EndDate:       until,
+			RoomId:        room.ID,
+			RestrictionId: housekeeping.RestrictionOutOfOrder,
+		}
+	}
+
+	after.OutOfOrderBlockId, err = m.DB.SetRoomStatus(after, block)
+	if errors.Is(err, repository.ErrUnavailable) {
+		m.App.Session.Put(r.Context(), "error",
+			fmt.Sprintf("%s is booked or blocked before %s, move those stays first", room.RoomName, after.OutOfOrderUntil.Format(dates.Layout)))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, audit.ActionUpdate, audit.EntityRoom, room.ID, room, after)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s is now %s", room.RoomName, strings.ReplaceAll(status, "_", " ")))
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// AdminCompleteHousekeepingTask marks a housekeeping task done and updates the status of its room
+func (m *Repository) AdminCompleteHousekeepingTask(w http.ResponseWriter, r *http.Request) {
+	property := helpers.PropertyFromContext(r.Context())
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	task, err := m.DB.GetHousekeepingTask(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(task.RoomId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if room.PropertyId != property.ID {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	redirect := "/admin/housekeeping?date=" + task.TaskDate.Format(dates.Layout)
+
+	if task.Status == housekeeping.TaskDone {
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	err = m.DB.CompleteHousekeepingTask(task.ID, userId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	done := task
+	done.Status = housekeeping.TaskDone
+	done.CompletedAt = time.Now()
+	done.CompletedBy = userId
+	m.audit(r, audit.ActionUpdate, audit.EntityTask, task.ID, task, done)
+
+	if status := housekeeping.AfterTask(room.HousekeepingStatus, task.TaskType); status != room.HousekeepingStatus {
+		after := room
+		after.HousekeepingStatus = status
+		err = m.DB.UpdateRoomStatus(after)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, audit.ActionUpdate, audit.EntityRoom, room.ID, room, after)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s done", room.RoomName, task.TaskType))
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// maxReportNights caps the period of a report
+const maxReportNights = 731
+
+// AdminReports shows occupancy and revenue analytics with charts
+func (m *Repository) AdminReports(w http.ResponseWriter, r *http.Request) {
+	report, err := m.report(r)
+	var invalid *reportQueryError
+	if errors.As(err, &invalid) {
+		m.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["report"] = report
+	data["intervals"] = reporting.Intervals
+
+	stringMap := make(map[string]string)
+	stringMap["currency"] = m.App.Currency
+	stringMap["query"] = r.URL.RawQuery
+
+	render.Template(w, r, "admin-reports.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminReportsJSON returns the same analytics as AdminReports as json
+func (m *Repository) AdminReportsJSON(w http.ResponseWriter, r *http.Request) {
+	report, err := m.report(r)
+	var invalid *reportQueryError
+	if errors.As(err, &invalid) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	out, err := json.MarshalIndent(report, "", "     ")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// reportQueryError is returned by report when the query asks for a report that cannot be built
+type reportQueryError struct {
+	msg string
+}
+
+func (e *reportQueryError) Error() string {
+	return e.msg
+}
+
+// report builds the report asked for by the from, to and interval query parameters. It defaults to the
+// current month by day. Queries that cannot be built return a *reportQueryError
+func (m *Repository) report(r *http.Request) (reporting.Report, error) {
+	property := helpers.PropertyFromContext(r.Context())
+	q := r.URL.Query()
+
+	today := property.Today()
+	period := dates.Range{
+		Start: time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC),
+	}
+	period.End = period.Start.AddDate(0, 1, 0)
+
+	if q.Get("from") != "" || q.Get("to") != "" {
+		from, err := time.Parse(dates.Layout, q.Get("from"))
+		if err != nil {
+			return reporting.Report{}, &reportQueryError{"invalid report start"}
+		}
+		// to is the last night of the report
+		to, err := time.Parse(dates.Layout, q.Get("to"))
+		if err != nil || to.Before(from) {
+			return reporting.Report{}, &reportQueryError{"the report must end on or after its start"}
+		}
+		period = dates.Range{Start: from, End: to.AddDate(0, 0, 1)}
+	}
+	if period.Nights() > maxReportNights {
+		return reporting.Report{}, &reportQueryError{fmt.Sprintf("reports cover at most %d nights", maxReportNights)}
+	}
+
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = reporting.IntervalDay
+	}
+	if !reporting.ValidInterval(interval) {
+		return reporting.Report{}, &reportQueryError{fmt.Sprintf("invalid interval %q", interval)}
+	}
+
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		return reporting.Report{}, err
+	}
+
+	stays, err := m.DB.ReportStays(property.ID, period)
+	if err != nil {
+		return reporting.Report{}, err
+	}
+
+	blocks, err := m.DB.BlocksForProperty(property.ID, period)
+	if err != nil {
+		return reporting.Report{}, err
+	}
+
+	return reporting.Build(period, interval, rooms, stays, blocks), nil
+}
+
+// maxImportSize is the largest import file the admin pages accept
+const maxImportSize = 5 << 20
+
+// AdminImport shows the form to upload reservations and blocks from a CSV file
+func (m *Repository) AdminImport(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["columns"] = strings.Join(importer.Columns, ",")
+
+	render.Template(w, r, "admin-import.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      make(map[string]interface{}),
+	})
+}
+
+// AdminPostImport checks an uploaded import file and shows what importing it would do. Once staff confirm,
+// the file comes back in the data field with mode import and its valid rows are imported
+func (m *Repository) AdminPostImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "The import file is too large or could not be read")
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	data := r.Form.Get("data")
+	name := r.Form.Get("name")
+	file, header, err := r.FormFile("file")
+	if err == nil {
+		defer file.Close()
+		b, err := io.ReadAll(file)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data, name = string(b), header.Filename
+	}
+	if strings.TrimSpace(data) == "" {
+		m.App.Session.Put(r.Context(), "error", "Choose a CSV file to import")
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	property := helpers.PropertyFromContext(r.Context())
+	commit := r.Form.Get("mode") == "import"
 
-	exploaded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploaded[4])
+	result, err := importer.Run(m.DB, property.ID, strings.NewReader(data), commit)
+	if errors.Is(err, repository.ErrUnavailable) {
+		// the calendar changed since the dry run, show the conflicts as they are now
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Nothing was imported, %s. Check the file again below", err))
+		result, err = importer.Run(m.DB, property.ID, strings.NewReader(data), false)
+	}
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Cannot import %s: %s", name, err))
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
 		return
 	}
-	src := exploaded[3]
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
-
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
 
-	stringMap["year"] = year
-	stringMap["month"] = month
-
-	// get reservation from database
+	if result.Imported {
+		for _, res := range result.Reservations {
+			m.logEvent(r, res.ID, timeline.EventCreated, fmt.Sprintf("Imported from %s, %s for %s", name, res.Room.RoomName, res.Stay()))
+			m.audit(r, audit.ActionCreate, audit.EntityReservation, res.ID, nil, res)
+		}
+		for _, b := range result.Blocks {
+			m.audit(r, audit.ActionCreate, audit.EntityBlock, b.ID,
+				nil, map[string]interface{}{"RoomId": b.RoomId, "Date": b.StartDate.Format(dates.Layout)})
+		}
 
-	res, err := m.DB.GetReservationById(id)
-	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d reservation(s) and %d blocked night(s) from %s, skipped %d invalid row(s)",
+			len(result.Reservations), len(result.Blocks), name, result.Invalid))
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservation"] = res
+	dataMap := make(map[string]interface{})
+	dataMap["result"] = result
 
-	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+	stringMap := make(map[string]string)
+	stringMap["columns"] = strings.Join(importer.Columns, ",")
+	stringMap["data"] = data
+	stringMap["name"] = name
+
+	render.Template(w, r, "admin-import.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
-		Data:      data,
-		Form:      forms.New(nil),
+		Data:      dataMap,
 	})
 }
 
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+// syncLogSize is how many syncs the calendar sync page shows
+const syncLogSize = 50
 
-	err := r.ParseForm()
+// AdminICalSources shows the outside calendars synced into the room calendars and the latest syncs
+func (m *Repository) AdminICalSources(w http.ResponseWriter, r *http.Request) {
+	m.renderICalSources(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderICalSources(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	property := helpers.PropertyFromContext(r.Context())
+
+	sources, err := m.DB.AllICalSources(property.ID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	exploaded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploaded[4])
+	rooms, err := m.DB.AllRooms(property.ID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
-	src := exploaded[3]
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
 
-	res, err := m.DB.GetReservationById(id)
+	logs, err := m.DB.ICalSyncLogs(property.ID, syncLogSize)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	res.FirstName = r.Form.Get("first_name")
-	res.LastName = r.Form.Get("last_name")
-	res.Email = r.Form.Get("email")
-	res.Phone = r.Form.Get("phone")
+	data := make(map[string]interface{})
+	data["sources"] = sources
+	data["rooms"] = rooms
+	data["logs"] = logs
 
-	err = m.DB.UpdateReservation(res)
+	render.Template(w, r, "admin-ical-sources.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostICalSource adds an outside calendar to a room and syncs it right away when it has a URL
+func (m *Repository) AdminPostICalSource(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	month := r.Form.Get("month")
-	year := r.Form.Get("year")
+	property := helpers.PropertyFromContext(r.Context())
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "name")
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	source := models.ICalSource{
+		Name: r.Form.Get("name"),
+		URL:  strings.TrimSpace(r.Form.Get("url")),
 	}
+	source.RoomId, _ = strconv.Atoi(r.Form.Get("room_id"))
 
-}
-
-// AdminReservationsCalendar Displays the reservation calendarss
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-
-	// Asume that there is no month/year specified
-	now := time.Now()
-
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	room, err := m.DB.GetRoomByID(source.RoomId)
+	if err != nil || room.PropertyId != property.ID {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	if source.URL != "" {
+		u, err := url.Parse(source.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			form.Errors.Add("url", "Enter an http or https URL, or leave it empty to upload files")
+		}
 	}
 
-	data := make(map[string]interface{})
-	data["now"] = now
+	if !form.Valid() {
+		m.renderICalSources(w, r, form)
+		return
+	}
 
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
+	source.ID, err = m.DB.InsertICalSource(source)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	source.Room = room
 
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
+	m.audit(r, audit.ActionCreate, audit.EntityICalSource, source.ID, nil, source)
 
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
+	if source.URL == "" {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s added, upload its calendar file to sync it", source.Name))
+		http.Redirect(w, r, "/admin/ical-sources", http.StatusSeeOther)
+		return
+	}
 
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
+	m.syncICalSource(w, r, source, func() ([]ical.Event, error) {
+		return calsync.Fetch(calsync.Client, source.URL)
+	})
+}
 
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
+// AdminSyncICalSource syncs an outside calendar now, from an uploaded file if there is one and from its
+// URL otherwise
+func (m *Repository) AdminSyncICalSource(w http.ResponseWriter, r *http.Request) {
+	source, ok := m.icalSource(w, r)
+	if !ok {
+		return
+	}
 
-	//get the first and last days of the month
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		m.App.Session.Put(r.Context(), "error", "The calendar file is too large or could not be read")
+		http.Redirect(w, r, "/admin/ical-sources", http.StatusSeeOther)
+		return
+	}
 
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	file, _, err := r.FormFile("file")
+	switch {
+	case err == nil:
+		defer file.Close()
+		m.syncICalSource(w, r, source, func() ([]ical.Event, error) {
+			return ical.Parse(file)
+		})
+	case source.URL != "":
+		m.syncICalSource(w, r, source, func() ([]ical.Event, error) {
+			return calsync.Fetch(calsync.Client, source.URL)
+		})
+	default:
+		m.App.Session.Put(r.Context(), "error", "Choose the calendar file to sync")
+		http.Redirect(w, r, "/admin/ical-sources", http.StatusSeeOther)
+	}
+}
 
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastOfMonth.Day()
+// syncICalSource syncs source and reports the outcome back on the calendar sync page
+func (m *Repository) syncICalSource(w http.ResponseWriter, r *http.Request, source models.ICalSource, load func() ([]ical.Event, error)) {
+	property := helpers.PropertyFromContext(r.Context())
 
-	rooms, err := m.DB.AllRooms()
+	entry, err := calsync.Run(m.DB, source, property.Today(), load)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	data["rooms"] = rooms
-
-	for _, x := range rooms {
-		// create maps
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
-
-		// for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
-		}
+	switch entry.Status {
+	case calsync.StatusError:
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Cannot sync %s: %s", source.Name, entry.Message))
+	case calsync.StatusConflict:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Synced %s, but its bookings overlap the calendar of %s", source.Name, source.Room.RoomName))
+	default:
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Synced %s: %d added, %d changed, %d removed", source.Name, entry.Added, entry.Updated, entry.Removed))
+	}
+	http.Redirect(w, r, "/admin/ical-sources", http.StatusSeeOther)
+}
 
-		//  get all restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
+// AdminDeleteICalSource removes an outside calendar and releases the room nights it blocked
+func (m *Repository) AdminDeleteICalSource(w http.ResponseWriter, r *http.Request) {
+	source, ok := m.icalSource(w, r)
+	if !ok {
+		return
+	}
 
-		for _, y := range restrictions {
-			if y.ReservationId > 0 {
-				// its a reservation
-				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationId
-				}
-			} else {
-				// its a block
+	err := m.DB.DeleteICalSource(source.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
-		}
+	m.audit(r, audit.ActionDelete, audit.EntityICalSource, source.ID, source, nil)
 
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s removed and its blocks released", source.Name))
+	http.Redirect(w, r, "/admin/ical-sources", http.StatusSeeOther)
+}
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+// icalSource loads the outside calendar in the URL, which must be of a room of the current property
+func (m *Repository) icalSource(w http.ResponseWriter, r *http.Request) (models.ICalSource, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
+	source, err := m.DB.GetICalSourceByID(id)
+	if err != nil || source.Room.PropertyId != helpers.PropertyFromContext(r.Context()).ID {
+		helpers.ClientError(w, http.StatusNotFound)
+		return source, false
 	}
 
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		IntMap:    intMap,
-	})
+	return source, true
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+// maxRateLimit is the most requests per minute a key may be allowed
+const maxRateLimit = 10000
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+// AdminAPIKeys shows the keys scripts and partner sites call the JSON API with
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(url.Values{
+		"rate_limit": {strconv.Itoa(apikeys.DefaultRateLimit)},
+		"scopes":     {apikeys.ScopeAvailability},
+	})
+	m.renderAPIKeys(w, r, form)
+}
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
+func (m *Repository) renderAPIKeys(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	keys, err := m.DB.AllAPIKeys()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	checked := make(map[string]bool)
+	for _, s := range form.Values["scopes"] {
+		checked[s] = true
 	}
 
+	data := make(map[string]interface{})
+	data["keys"] = keys
+	data["scopes"] = apikeys.Scopes
+	data["scope_names"] = apikeys.ScopeNames
+	data["checked"] = checked
+	// a new key is only ever shown on the page right after it is issued
+	data["new_key"] = m.App.Session.PopString(r.Context(), "api_key")
+
+	render.Template(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
 }
 
-// AdminDeleteReservation delete a reservation
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
+// AdminPostAPIKey issues a new API key. The key itself is shown once and only its hash is kept
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	_ = m.DB.DeleteReservation(id)
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	form := forms.New(r.PostForm)
+	form.Required("name", "rate_limit")
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	k := models.APIKey{
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+		Scopes: r.Form["scopes"],
+	}
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	k.RateLimit, err = strconv.Atoi(r.Form.Get("rate_limit"))
+	if form.Has("rate_limit") && (err != nil || k.RateLimit < 0 || k.RateLimit > maxRateLimit) {
+		form.Errors.Add("rate_limit", fmt.Sprintf("Enter a whole number from 0 to %d", maxRateLimit))
+	}
+	if len(k.Scopes) == 0 {
+		form.Errors.Add("scopes", "Choose at least one scope")
+	}
+	for _, s := range k.Scopes {
+		if !apikeys.Valid(s) {
+			form.Errors.Add("scopes", "Choose from the scopes listed")
+			break
+		}
 	}
 
-}
+	if !form.Valid() {
+		m.renderAPIKeys(w, r, form)
+		return
+	}
 
-// AdminPostReservationsCalendar handles post of reservation calendar
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	var key string
+	key, k.Prefix, k.KeyHash, err = apikeys.Generate()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
-
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+	k.ID, err = m.DB.InsertAPIKey(k)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
-
-	for _, x := range rooms {
-		// Get the block map from the session. loop thrugh entire map if we have an entry in the map
-		// that does not not exist in our posted data, and if the restriction id> 0, then it is a block
-		//  we need to remove
+	m.audit(r, audit.ActionCreate, audit.EntityAPIKey, k.ID, nil, k)
 
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		// valCounter := 1
-		for name, value := range curMap {
+	m.App.Session.Put(r.Context(), "api_key", key)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Key %s issued", k.Name))
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
 
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
+// AdminRevokeAPIKey stops an API key from being used. Revoked keys stay listed with when they were revoked
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						// delete the restriction by id
-						err := m.DB.DeleteBlockById(value)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-			}
-		}
+	before, err := m.DB.GetAPIKeyByID(id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
 	}
 
-	// now handle new blocks
+	if !before.RevokedAt.IsZero() {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Key %s was already revoked", before.Name))
+		http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+		return
+	}
 
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploaded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploaded[2])
-			t, _ := time.Parse("2006-01-2", exploaded[3])
-			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, t)
-			if err != nil {
-				log.Println(err)
-			}
-		}
+	err = m.DB.RevokeAPIKey(id, time.Now())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	after := before
+	after.RevokedAt = time.Now()
+	m.audit(r, audit.ActionRevoke, audit.EntityAPIKey, id, before, after)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Key %s revoked", before.Name))
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
 }
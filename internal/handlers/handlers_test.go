@@ -1,18 +1,28 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/eldicela/bookings/internal/api"
+	"github.com/eldicela/bookings/internal/apikeys"
 	"github.com/eldicela/bookings/internal/driver"
+	"github.com/eldicela/bookings/internal/helpers"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/payments"
+	"github.com/eldicela/bookings/internal/repository"
+	"github.com/eldicela/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi/v5"
 )
 
 type postData struct {
@@ -20,6 +30,39 @@ type postData struct {
 	value string
 }
 
+// bookingRepo is the test repository, counting the promo codes bookings redeem and release and the
+// reservations they create
+type bookingRepo struct {
+	repository.DatabaseRepo
+	redeemed int
+	released int
+	created  int
+}
+
+func (m *bookingRepo) RedeemPromoCode(id int) error {
+	m.redeemed++
+	return m.DatabaseRepo.RedeemPromoCode(id)
+}
+
+func (m *bookingRepo) ReleasePromoCode(id int) error {
+	m.released++
+	return m.DatabaseRepo.ReleasePromoCode(id)
+}
+
+func (m *bookingRepo) CreateReservations(name string, lines []models.Reservation, charges []models.FolioEntry, payments []models.Payment) ([]models.Reservation, error) {
+	booked, err := m.DatabaseRepo.CreateReservations(name, lines, charges, payments)
+	if err == nil {
+		m.created += len(booked)
+	}
+	return booked, err
+}
+
+// newBookingRepo returns handlers on a bookingRepo
+func newBookingRepo() (*Repository, *bookingRepo) {
+	db := &bookingRepo{DatabaseRepo: dbrepo.NewTestingRepo(&app)}
+	return &Repository{App: &app, DB: db, Limiter: apikeys.NewLimiter()}, db
+}
+
 var theTests = []struct {
 	name               string
 	url                string
@@ -112,181 +155,83 @@ func TestRepository_Reservation(t *testing.T) {
 }
 
 func TestRepository_PostReservation(t *testing.T) {
-	reqBody := "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	rr := httptest.NewRecorder()
-
-	handler := http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
-	}
-
-	// test for missing post body
-	req, _ = http.NewRequest("POST", "/make-reservation", nil)
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for missing post body: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	// test for invalid start date
-	reqBody = "start_date=invalid"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for invalid start date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	// test for invalid end date
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=invalid")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for invalid end date: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
-
-	// test for invalid room id
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=invalid")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler returned wrong response code for invalid room id: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	reservation := models.Reservation{
+		StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, 1, 2, 0, 0, 0, 0, time.UTC),
+		RoomId:    1,
+		Room: models.Room{
+			ID:         1,
+			RoomName:   "General's Quarters",
+			Price:      10000,
+			PropertyId: 1,
+		},
 	}
-
-	// test for invalid data
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=J")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1")
-
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("PostReservation handler returned wrong response code for invalid data: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	taken := reservation
+	taken.RoomId = 3
+	taken.Room.ID = 3
+
+	guest := "first_name=John&last_name=Smith&email=john@smith.com&phone=123456789"
+
+	var tests = []struct {
+		name               string
+		reservation        *models.Reservation
+		promoCode          string
+		body               string
+		expectedStatusCode int
+		expectedLocation   string
+		created            int
+		redeemed           int
+		released           int
+	}{
+		{"booked", &reservation, "", guest + "&payment_token=tok_visa", http.StatusSeeOther, "/reservation-summary", 1, 0, 0},
+		{"booked with promo code", &reservation, "TEST", guest + "&payment_token=tok_visa", http.StatusSeeOther, "/reservation-summary", 1, 1, 0},
+		{"no reservation in session", nil, "", guest + "&payment_token=tok_visa", http.StatusInternalServerError, "", 0, 0, 0},
+		{"missing post body", &reservation, "", "", http.StatusInternalServerError, "", 0, 0, 0},
+		{"invalid data", &reservation, "", "first_name=J&last_name=Smith&email=john@smith.com&payment_token=tok_visa", http.StatusOK, "", 0, 0, 0},
+		{"missing payment token", &reservation, "", guest, http.StatusOK, "", 0, 0, 0},
+		// the page is shown again to take another card, the promo code used is given back
+		{"deposit declined", &reservation, "TEST", guest + "&payment_token=" + payments.DeclineToken, http.StatusOK, "", 0, 1, 1},
+		// room 3 is taken while the guest books it, so they search again and the promo code is given back
+		{"room taken", &taken, "TEST", guest + "&payment_token=tok_visa", http.StatusSeeOther, "/search-availability", 0, 1, 1},
 	}
 
-	// test for failure to insert reservation into database
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=2")
+	for _, e := range tests {
+		repo, db := newBookingRepo()
 
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
-
-	handler.ServeHTTP(rr, req)
+		var body io.Reader
+		if e.body != "" {
+			body = strings.NewReader(e.body)
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
-	}
+		if e.reservation != nil {
+			session.Put(ctx, "reservation", *e.reservation)
+		}
+		if e.promoCode != "" {
+			session.Put(ctx, "promo_code", e.promoCode)
+		}
 
-	// test for failure to insert restriction into database
-	reqBody = "start_date=2050-01-01"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2050-01-02")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=John")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Smith")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=john@smith.com")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=123456789")
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1000")
+		rr := httptest.NewRecorder()
 
-	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
-	ctx = getCtx(req)
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rr = httptest.NewRecorder()
-
-	handler = http.HandlerFunc(Repo.PostReservation)
+		handler := http.HandlerFunc(repo.PostReservation)
 
-	handler.ServeHTTP(rr, req)
+		handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("PostReservation handler failed when trying to fail inserting reservation: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostReservation handler returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: PostReservation redirected to %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+		if db.created != e.created {
+			t.Errorf("%s: PostReservation created %d reservations, wanted %d", e.name, db.created, e.created)
+		}
+		if db.redeemed != e.redeemed || db.released != e.released {
+			t.Errorf("%s: PostReservation redeemed %d and released %d promo codes, wanted %d and %d", e.name, db.redeemed, db.released, e.redeemed, e.released)
+		}
 	}
 }
 
@@ -317,6 +262,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr := httptest.NewRecorder()
@@ -350,6 +298,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -378,6 +329,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -388,9 +342,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// make the request to our handler
 	handler.ServeHTTP(rr, req)
 
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability with empty request body (nil) gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	// without dates, we expect to be sent back to the search with status http.StatusSeeOther
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Post availability with empty request body (nil) gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 	/*****************************************
@@ -408,6 +362,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -418,9 +375,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// make the request to our handler
 	handler.ServeHTTP(rr, req)
 
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability with invalid start date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	// with invalid dates, we expect to be sent back to the search with status http.StatusSeeOther
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Post availability with invalid start date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 	/*****************************************
@@ -438,6 +395,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -448,9 +408,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// make the request to our handler
 	handler.ServeHTTP(rr, req)
 
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability with invalid end date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	// with invalid dates, we expect to be sent back to the search with status http.StatusSeeOther
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Post availability with invalid end date gave wrong status code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
 	/*****************************************
@@ -469,6 +429,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -479,9 +442,9 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// make the request to our handler
 	handler.ServeHTTP(rr, req)
 
-	// since we have rooms available, we expect to get status http.StatusTemporaryRedirect
-	if rr.Code != http.StatusTemporaryRedirect {
-		t.Errorf("Post availability when database query fails gave wrong status code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
+	// since the query failed, we expect to get status http.StatusInternalServerError
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Post availability when database query fails gave wrong status code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
 	}
 }
 
@@ -504,6 +467,9 @@ func TestRepository_AvailabilityJson(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr := httptest.NewRecorder()
@@ -545,6 +511,9 @@ func TestRepository_AvailabilityJson(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -579,6 +548,9 @@ func TestRepository_AvailabilityJson(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -595,8 +567,8 @@ func TestRepository_AvailabilityJson(t *testing.T) {
 		t.Error("failed to parse json!")
 	}
 
-	// since we specified a start date < 2049-12-31, we expect availability
-	if j.OK || j.Message != "Internal server error" {
+	// without dates, we expect no availability
+	if j.OK {
 		t.Error("Got availability when request body was empty")
 	}
 
@@ -616,6 +588,9 @@ func TestRepository_AvailabilityJson(t *testing.T) {
 	// set the request header
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// the form is parsed by the CSRF check in front of the handler
+	_ = req.ParseForm()
+
 	// create our response recorder, which satisfies the requirements
 	// for http.ResponseWriter
 	rr = httptest.NewRecorder()
@@ -632,8 +607,8 @@ func TestRepository_AvailabilityJson(t *testing.T) {
 		t.Error("failed to parse json!")
 	}
 
-	// since we specified a start date < 2049-12-31, we expect availability
-	if j.OK || j.Message != "Error querying database" {
+	// since the query failed, we expect no availability
+	if j.OK {
 		t.Error("Got availability when simulating database error")
 	}
 }
@@ -696,11 +671,12 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	}
 
 	req, _ := http.NewRequest("GET", "/choose-room/1", nil)
-	ctx := getCtx(req)
-	req = req.WithContext(ctx)
-	// set the RequestURI on the request so that we can grab the ID
+	// set the route context on the request so that we can grab the ID
 	// from the URL
-	req.RequestURI = "/choose-room/1"
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
 
 	rr := httptest.NewRecorder()
 	session.Put(ctx, "reservation", reservation)
@@ -717,9 +693,8 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	//// second case -- reservation not in session
 	//*****************************************/
 	req, _ = http.NewRequest("GET", "/choose-room/1", nil)
-	ctx = getCtx(req)
+	ctx = context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
 	req = req.WithContext(ctx)
-	req.RequestURI = "/choose-room/1"
 
 	rr = httptest.NewRecorder()
 
@@ -735,9 +710,10 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	//// third case -- missing url parameter, or malformed parameter
 	//*****************************************/
 	req, _ = http.NewRequest("GET", "/choose-room/fish", nil)
-	ctx = getCtx(req)
+	rctx = chi.NewRouteContext()
+	rctx.URLParams.Add("id", "fish")
+	ctx = context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
 	req = req.WithContext(ctx)
-	req.RequestURI = "/choose-room/fish"
 
 	rr = httptest.NewRecorder()
 
@@ -795,6 +771,279 @@ func TestRepository_BookRoom(t *testing.T) {
 	}
 }
 
+func TestRepository_AdminPostShowReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"saved", "1", http.StatusSeeOther},
+		// reservation 2 was saved by someone else, so the page is shown again with both copies
+		{"conflict", "2", http.StatusConflict},
+	}
+
+	for _, e := range tests {
+		reqBody := "first_name=John&last_name=Smith&email=john@smith.com&phone=123456789&adults=1&version=1"
+		uri := fmt.Sprintf("/admin/reservations/all/%s/show", e.id)
+
+		req, _ := http.NewRequest("POST", uri, strings.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RequestURI = uri
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		ctx = helpers.WithProperty(ctx, testProperty)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostShowReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminReassignRoom(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomId             string
+		expectedStatusCode int
+		expectedKey        string
+		expectedMessage    string
+	}{
+		{"moved", "2", http.StatusSeeOther, "flash", "Room changed"},
+		// room 3 is booked by someone else by the time the reservation is moved
+		{"room taken", "3", http.StatusSeeOther, "error", "That room is not free for these dates"},
+	}
+
+	for _, e := range tests {
+		reqBody := fmt.Sprintf("room_id=%s", e.roomId)
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/1/room", strings.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", "1")
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		ctx = helpers.WithProperty(ctx, testProperty)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReassignRoom)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminReassignRoom returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if msg := session.GetString(ctx, e.expectedKey); msg != e.expectedMessage {
+			t.Errorf("%s: AdminReassignRoom put %q in %s, wanted %q", e.name, msg, e.expectedKey, e.expectedMessage)
+		}
+	}
+}
+
+func TestRepository_AdminPropertyNotFound(t *testing.T) {
+	otherProperty := models.Property{ID: 2, Name: "Other Property", Slug: "other"}
+
+	var tests = []struct {
+		name               string
+		handler            http.HandlerFunc
+		id                 string
+		property           models.Property
+		expectedStatusCode int
+	}{
+		{"reservation shown", Repo.AdminShowReservation, "1", otherProperty, http.StatusNotFound},
+		{"reservation saved", Repo.AdminPostShowReservation, "1", otherProperty, http.StatusNotFound},
+		{"reservation moved", Repo.AdminReassignRoom, "1", otherProperty, http.StatusNotFound},
+		{"reservation cancelled", Repo.AdminCancelReservation, "1", otherProperty, http.StatusNotFound},
+		{"reservation deleted", Repo.AdminDeleteReservation, "1", otherProperty, http.StatusNotFound},
+		{"folio entry posted", Repo.AdminPostFolioEntry, "1", otherProperty, http.StatusNotFound},
+		{"room type set", Repo.AdminPostRoomRoomType, "1", otherProperty, http.StatusNotFound},
+		{"room status set", Repo.AdminPostRoomStatus, "1", otherProperty, http.StatusNotFound},
+		{"group shown", Repo.AdminShowGroup, "1", otherProperty, http.StatusNotFound},
+		{"group saved", Repo.AdminPostGroup, "1", otherProperty, http.StatusNotFound},
+		{"unknown group", Repo.AdminShowGroup, "2", testProperty, http.StatusNotFound},
+		// the same reservation is found in its own property
+		{"reservation cancelled in its property", Repo.AdminCancelReservation, "1", testProperty, http.StatusSeeOther},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		ctx = helpers.WithProperty(ctx, e.property)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+// apiResponse is the envelope of a response of the JSON API, holding either data or an error
+type apiResponse struct {
+	Data  json.RawMessage `json:"data"`
+	Error *api.Error      `json:"error"`
+}
+
+// apiRequest sends a request to the JSON API of repo with key, returning the response and its envelope
+func apiRequest(t *testing.T, repo *Repository, method, path, key string, body interface{}) (*httptest.ResponseRecorder, apiResponse) {
+	var reader io.Reader
+	if body != nil {
+		out, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(out)
+	}
+
+	req, _ := http.NewRequest(method, api.Prefix+path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(apikeys.Header, key)
+	}
+
+	rr := httptest.NewRecorder()
+	getAPIRoutes(repo).ServeHTTP(rr, req)
+
+	var envelope apiResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &envelope)
+	if err != nil {
+		t.Errorf("%s %s did not answer with a JSON envelope: %s", method, path, rr.Body.String())
+	}
+
+	return rr, envelope
+}
+
+func TestAPIScopes(t *testing.T) {
+	var tests = []struct {
+		name               string
+		path               string
+		key                string
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"no key", "/properties", "", http.StatusUnauthorized, api.CodeUnauthorized},
+		{"unknown key", "/properties", "bk_unknown", http.StatusUnauthorized, api.CodeUnauthorized},
+		{"availability key", "/properties", apikeys.ScopeAvailability, http.StatusOK, ""},
+		{"reservations key without availability", "/properties/test/rooms", apikeys.ScopeReservations, http.StatusForbidden, api.CodeForbidden},
+		{"availability key on reservation list", "/properties/test/reservations", apikeys.ScopeAvailability, http.StatusForbidden, api.CodeForbidden},
+		{"admin key on reservation list", "/properties/test/reservations", apikeys.ScopeAdmin, http.StatusOK, ""},
+		{"unknown property", "/properties/other/rooms", apikeys.ScopeAvailability, http.StatusNotFound, api.CodeNotFound},
+		{"unknown endpoint", "/nothing", apikeys.ScopeAdmin, http.StatusNotFound, api.CodeNotFound},
+	}
+
+	for _, e := range tests {
+		rr, envelope := apiRequest(t, Repo, "GET", e.path, e.key, nil)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedCode == "" {
+			if envelope.Error != nil || len(envelope.Data) == 0 {
+				t.Errorf("%s: expected data and no error, got %s", e.name, rr.Body.String())
+			}
+			continue
+		}
+		if envelope.Error == nil || envelope.Error.Code != e.expectedCode || envelope.Error.Status != rr.Code {
+			t.Errorf("%s: expected a %s error with status %d, got %s", e.name, e.expectedCode, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestAPICreateReservation(t *testing.T) {
+	booking := func(roomId int, arrival, promoCode, paymentToken string) map[string]interface{} {
+		return map[string]interface{}{
+			"room_id":       roomId,
+			"arrival":       arrival,
+			"departure":     strings.Replace(arrival, "-01-01", "-01-02", 1),
+			"adults":        1,
+			"first_name":    "John",
+			"last_name":     "Smith",
+			"email":         "john@smith.com",
+			"promo_code":    promoCode,
+			"payment_token": paymentToken,
+		}
+	}
+
+	var tests = []struct {
+		name               string
+		key                string
+		body               map[string]interface{}
+		expectedStatusCode int
+		expectedCode       string
+		created            int
+		redeemed           int
+		released           int
+	}{
+		{"booked", apikeys.ScopeReservations, booking(1, "2040-01-01", "", "tok_visa"), http.StatusCreated, "", 1, 0, 0},
+		{"booked with promo code", apikeys.ScopeReservations, booking(1, "2040-01-01", "TEST", "tok_visa"), http.StatusCreated, "", 1, 1, 0},
+		{"booked with admin key", apikeys.ScopeAdmin, booking(1, "2040-01-01", "", "tok_visa"), http.StatusCreated, "", 1, 0, 0},
+		{"no key", "", booking(1, "2040-01-01", "", "tok_visa"), http.StatusUnauthorized, api.CodeUnauthorized, 0, 0, 0},
+		{"availability key", apikeys.ScopeAvailability, booking(1, "2040-01-01", "", "tok_visa"), http.StatusForbidden, api.CodeForbidden, 0, 0, 0},
+		{"missing payment token", apikeys.ScopeReservations, booking(1, "2040-01-01", "", ""), http.StatusUnprocessableEntity, api.CodeValidation, 0, 0, 0},
+		{"unknown promo code", apikeys.ScopeReservations, booking(1, "2040-01-01", "NOPE", "tok_visa"), http.StatusUnprocessableEntity, api.CodeValidation, 0, 0, 0},
+		{"room not available", apikeys.ScopeReservations, booking(1, "2050-01-01", "", "tok_visa"), http.StatusConflict, api.CodeConflict, 0, 0, 0},
+		// the deposit is declined after the promo code was redeemed, so it is given back
+		{"deposit declined", apikeys.ScopeReservations, booking(1, "2040-01-01", "TEST", payments.DeclineToken), http.StatusPaymentRequired, api.CodePaymentDeclined, 0, 1, 1},
+		// room 3 is taken while it is booked, so nothing is created and the promo code is given back
+		{"room taken", apikeys.ScopeReservations, booking(3, "2040-01-01", "TEST", "tok_visa"), http.StatusConflict, api.CodeConflict, 0, 1, 1},
+	}
+
+	for _, e := range tests {
+		repo, db := newBookingRepo()
+
+		rr, envelope := apiRequest(t, repo, "POST", "/properties/test/reservations", e.key, e.body)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d: %s", e.name, rr.Code, e.expectedStatusCode, rr.Body.String())
+		}
+
+		if e.expectedCode == "" {
+			var data struct {
+				Reservation api.Reservation `json:"reservation"`
+				Quote       api.Quote       `json:"quote"`
+			}
+			err := json.Unmarshal(envelope.Data, &data)
+			if err != nil || envelope.Error != nil || data.Reservation.ID == 0 {
+				t.Errorf("%s: expected the reservation in data, got %s", e.name, rr.Body.String())
+			}
+			if !strings.HasPrefix(rr.Header().Get("Location"), api.Prefix+"/reservations/") {
+				t.Errorf("%s: expected the location of the reservation, got %q", e.name, rr.Header().Get("Location"))
+			}
+		} else if envelope.Error == nil || envelope.Error.Code != e.expectedCode || envelope.Error.Status != rr.Code {
+			t.Errorf("%s: expected a %s error with status %d, got %s", e.name, e.expectedCode, rr.Code, rr.Body.String())
+		}
+
+		if db.created != e.created {
+			t.Errorf("%s: created %d reservations, wanted %d", e.name, db.created, e.created)
+		}
+		if db.redeemed != e.redeemed || db.released != e.released {
+			t.Errorf("%s: redeemed %d and released %d promo codes, wanted %d and %d", e.name, db.redeemed, db.released, e.redeemed, e.released)
+		}
+	}
+}
+
+// testProperty is the property the rows of the test repository belong to
+var testProperty = models.Property{ID: 1, Name: "Test Property", Slug: "test"}
+
 func getCtx(req *http.Request) context.Context {
 	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
 	if err != nil {
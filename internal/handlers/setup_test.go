@@ -12,8 +12,12 @@ import (
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/eldicela/bookings/internal/api"
+	"github.com/eldicela/bookings/internal/apikeys"
 	"github.com/eldicela/bookings/internal/config"
+	"github.com/eldicela/bookings/internal/helpers"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/payments"
 	"github.com/eldicela/bookings/internal/render"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -23,11 +27,12 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = render.Functions
 
 func TestMain(m *testing.M) {
 
 	gob.Register(models.Reservation{})
+	gob.Register([]models.Reservation{})
 
 	//Change this to true in production
 	app.InProduction = false
@@ -46,6 +51,18 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
+	app.Payments = payments.NewFakeGateway("secret")
+	app.Currency = "USD"
+	app.DepositPercent = 20
+
+	// nothing sends the mail in tests, it is read off so bookings do not wait on it
+	mailChan := make(chan models.MailData)
+	app.MailChan = mailChan
+	go func() {
+		for range mailChan {
+		}
+	}()
+
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
@@ -59,6 +76,7 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
@@ -91,6 +109,41 @@ func getRoutes() http.Handler {
 	return mux
 }
 
+// getAPIRoutes returns the routes of the JSON API served by repo
+func getAPIRoutes(repo *Repository) http.Handler {
+	mux := chi.NewRouter()
+
+	mux.Use(SessionLoad)
+
+	mux.Route(api.Prefix, func(mux chi.Router) {
+		mux.Use(repo.APIKey)
+		mux.NotFound(repo.APINotFound)
+		mux.MethodNotAllowed(repo.APIMethodNotAllowed)
+
+		mux.With(repo.APIScope(apikeys.ScopeAvailability)).Get("/properties", repo.APIProperties)
+		mux.Get("/reservations/{token}", repo.APIShowReservation)
+
+		mux.Route("/properties/{property}", func(mux chi.Router) {
+			mux.Use(repo.APIProperty)
+
+			mux.Group(func(mux chi.Router) {
+				mux.Use(repo.APIScope(apikeys.ScopeAvailability))
+
+				mux.Get("/", repo.APIShowProperty)
+				mux.Get("/rooms", repo.APIRooms)
+				mux.Get("/rooms/{id}", repo.APIRoom)
+				mux.Get("/availability", repo.APIAvailability)
+				mux.Post("/quotes", repo.APIQuote)
+			})
+
+			mux.With(repo.APIScope(apikeys.ScopeReservations)).Post("/reservations", repo.APICreateReservation)
+			mux.With(repo.APIScope(apikeys.ScopeAdmin)).Get("/reservations", repo.APIReservations)
+		})
+	})
+
+	return mux
+}
+
 // NoSurf adds CSRFprotection to POST request
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -126,7 +179,7 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 			return myCache, err
 		}
 
-		matches, err := filepath.Glob(fmt.Sprintf("%s*.layout.tmpl", pathToTemplates))
+		matches, err := filepath.Glob(fmt.Sprintf("%s/*.layout.tmpl", pathToTemplates))
 		if err != nil {
 			return myCache, err
 		}
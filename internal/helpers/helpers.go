@@ -1,11 +1,15 @@
 package helpers
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/eldicela/bookings/internal/config"
+	"github.com/eldicela/bookings/internal/models"
 )
 
 var app *config.AppConfig
@@ -30,3 +34,42 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// RandomToken returns a random hex string made from n bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type contextKey string
+
+const (
+	propertyKey contextKey = "property"
+	apiKeyKey   contextKey = "api_key"
+)
+
+// WithProperty returns a copy of ctx carrying the property a request is about
+func WithProperty(ctx context.Context, p models.Property) context.Context {
+	return context.WithValue(ctx, propertyKey, p)
+}
+
+// PropertyFromContext returns the property a request is about, if any
+func PropertyFromContext(ctx context.Context) models.Property {
+	p, _ := ctx.Value(propertyKey).(models.Property)
+	return p
+}
+
+// WithAPIKey returns a copy of ctx carrying the API key a request was made with
+func WithAPIKey(ctx context.Context, k models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey, k)
+}
+
+// APIKeyFromContext returns the API key a request was made with. Its ID is 0 when there was none
+func APIKeyFromContext(ctx context.Context) models.APIKey {
+	k, _ := ctx.Value(apiKeyKey).(models.APIKey)
+	return k
+}
@@ -0,0 +1,166 @@
+package housekeeping
+
+import (
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Room statuses
+const (
+	StatusDirty      = "dirty"
+	StatusClean      = "clean"
+	StatusInspected  = "inspected"
+	StatusOutOfOrder = "out_of_order"
+)
+
+// Statuses lists the room statuses in the order a room goes through them
+var Statuses = []string{StatusDirty, StatusClean, StatusInspected, StatusOutOfOrder}
+
+// RestrictionOutOfOrder is the restriction id of the block that takes an out of order room off sale
+const RestrictionOutOfOrder = 3
+
+// Task types
+const (
+	// TaskDeparture is the full clean of a room its guests leave
+	TaskDeparture = "departure"
+	// TaskStayover is the daily service of a room its guests stay on in
+	TaskStayover = "stayover"
+)
+
+// Task statuses
+const (
+	TaskOpen = "open"
+	TaskDone = "done"
+)
+
+// ValidStatus reports whether status is a room status
+func ValidStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan returns the tasks of day: a departure clean of each room guests leave that day and a stayover
+// service of each room guests stay on in. A room turned over that day only needs the departure clean
+func Plan(day time.Time, stays []models.Reservation) []models.HousekeepingTask {
+	var tasks []models.HousekeepingTask
+	index := make(map[int]int)
+
+	for _, res := range stays {
+		var taskType string
+		switch {
+		case res.EndDate.Equal(day):
+			taskType = TaskDeparture
+		case res.StartDate.Before(day) && res.EndDate.After(day):
+			taskType = TaskStayover
+		default:
+			continue
+		}
+
+		task := models.HousekeepingTask{
+			RoomId:        res.RoomId,
+			ReservationId: res.ID,
+			TaskDate:      day,
+			TaskType:      taskType,
+			Status:        TaskOpen,
+		}
+
+		if i, ok := index[res.RoomId]; ok {
+			if taskType == TaskDeparture {
+				tasks[i] = task
+			}
+			continue
+		}
+
+		index[res.RoomId] = len(tasks)
+		tasks = append(tasks, task)
+	}
+
+	return tasks
+}
+
+// Store is what Run needs from the database
+type Store interface {
+	AllRooms(propertyId int) ([]models.Room, error)
+	GetStaysForDay(propertyId int, day time.Time) ([]models.Reservation, error)
+	CreateHousekeepingTasks(tasks []models.HousekeepingTask) ([]models.HousekeepingTask, error)
+	UpdateRoomStatus(room models.Room) error
+}
+
+// Run plans the tasks of day for a property and brings the room statuses up to date on today. Rooms
+// guests leave turn dirty once the day has come, the first time it is planned, and out of order rooms
+// whose time is up turn dirty to be checked before they are sold again
+func Run(store Store, propertyId int, day, today time.Time) error {
+	rooms, err := store.AllRooms(propertyId)
+	if err != nil {
+		return err
+	}
+
+	stays, err := store.GetStaysForDay(propertyId, day)
+	if err != nil {
+		return err
+	}
+
+	created, err := store.CreateHousekeepingTasks(Plan(day, stays))
+	if err != nil {
+		return err
+	}
+
+	leaving := make(map[int]bool)
+	if !day.After(today) {
+		for _, t := range created {
+			if t.TaskType == TaskDeparture {
+				leaving[t.RoomId] = true
+			}
+		}
+	}
+
+	for _, room := range rooms {
+		status := room.HousekeepingStatus
+		if status == StatusOutOfOrder && !room.OutOfOrderUntil.IsZero() && !room.OutOfOrderUntil.After(today) {
+			room.StatusNote = ""
+			room.OutOfOrderUntil = time.Time{}
+			room.OutOfOrderBlockId = 0
+			status = StatusDirty
+		} else if leaving[room.ID] && status != StatusOutOfOrder {
+			status = StatusDirty
+		}
+
+		if status == room.HousekeepingStatus {
+			continue
+		}
+
+		room.HousekeepingStatus = status
+		err = store.UpdateRoomStatus(room)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AfterTask returns the status of a room once a task on it is done. A departure clean leaves the room
+// clean, ready for inspection. A stayover service and out of order rooms keep their status
+func AfterTask(status, taskType string) string {
+	if taskType == TaskDeparture && status != StatusOutOfOrder {
+		return StatusClean
+	}
+	return status
+}
+
+// Counts returns how many rooms have each status
+func Counts(rooms []models.Room) map[string]int {
+	counts := make(map[string]int, len(Statuses))
+	for _, s := range Statuses {
+		counts[s] = 0
+	}
+	for _, r := range rooms {
+		counts[r.HousekeepingStatus]++
+	}
+	return counts
+}
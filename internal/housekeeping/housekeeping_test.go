@@ -0,0 +1,136 @@
+package housekeeping
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestPlan(t *testing.T) {
+	day := datestest.Day(t, "2050-01-10")
+
+	tasks := Plan(day, []models.Reservation{
+		// staying on
+		{ID: 1, RoomId: 1, StartDate: datestest.Day(t, "2050-01-08"), EndDate: datestest.Day(t, "2050-01-12")},
+		// leaving, and the room is turned over for a new arrival
+		{ID: 2, RoomId: 2, StartDate: datestest.Day(t, "2050-01-10"), EndDate: datestest.Day(t, "2050-01-11")},
+		{ID: 3, RoomId: 2, StartDate: datestest.Day(t, "2050-01-07"), EndDate: datestest.Day(t, "2050-01-10")},
+		// arriving only
+		{ID: 4, RoomId: 3, StartDate: datestest.Day(t, "2050-01-10"), EndDate: datestest.Day(t, "2050-01-13")},
+	})
+
+	if len(tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %+v", tasks)
+	}
+
+	if tasks[0].RoomId != 1 || tasks[0].TaskType != TaskStayover || tasks[0].ReservationId != 1 {
+		t.Errorf("expected a stayover of room 1, got %+v", tasks[0])
+	}
+	if tasks[1].RoomId != 2 || tasks[1].TaskType != TaskDeparture || tasks[1].ReservationId != 3 {
+		t.Errorf("expected a departure clean of room 2, got %+v", tasks[1])
+	}
+	if !tasks[1].TaskDate.Equal(day) || tasks[1].Status != TaskOpen {
+		t.Errorf("wrong task date or status %+v", tasks[1])
+	}
+}
+
+// store plans tasks that do not exist yet, like the database does
+type store struct {
+	rooms   []models.Room
+	stays   []models.Reservation
+	tasks   map[string]bool
+	updated map[int]string
+}
+
+func (s *store) AllRooms(propertyId int) ([]models.Room, error) {
+	return s.rooms, nil
+}
+
+func (s *store) GetStaysForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	return s.stays, nil
+}
+
+func (s *store) CreateHousekeepingTasks(tasks []models.HousekeepingTask) ([]models.HousekeepingTask, error) {
+	var created []models.HousekeepingTask
+	for _, task := range tasks {
+		key := fmt.Sprintf("%s %s %d", task.TaskDate.Format(dates.Layout), task.TaskType, task.RoomId)
+		if !s.tasks[key] {
+			s.tasks[key] = true
+			created = append(created, task)
+		}
+	}
+	return created, nil
+}
+
+func (s *store) UpdateRoomStatus(room models.Room) error {
+	s.updated[room.ID] = room.HousekeepingStatus
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	today := datestest.Day(t, "2050-01-10")
+	s := &store{
+		rooms: []models.Room{
+			{ID: 1, HousekeepingStatus: StatusInspected},
+			{ID: 2, HousekeepingStatus: StatusOutOfOrder, OutOfOrderUntil: today},
+			{ID: 3, HousekeepingStatus: StatusOutOfOrder, OutOfOrderUntil: datestest.Day(t, "2050-01-12")},
+		},
+		stays: []models.Reservation{
+			{ID: 1, RoomId: 1, StartDate: datestest.Day(t, "2050-01-08"), EndDate: today},
+		},
+		tasks:   make(map[string]bool),
+		updated: make(map[int]string),
+	}
+
+	err := Run(s, 1, today, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.updated) != 2 || s.updated[1] != StatusDirty || s.updated[2] != StatusDirty {
+		t.Errorf("expected rooms 1 and 2 dirty, got %v", s.updated)
+	}
+
+	// a room cleaned since is left alone when the day is planned again
+	s.rooms[0].HousekeepingStatus = StatusClean
+	s.updated = make(map[int]string)
+	_ = Run(s, 1, today, today)
+	if s.updated[1] != "" {
+		t.Errorf("expected room 1 left alone, got %v", s.updated)
+	}
+
+	// planning ahead does not dirty rooms that are still occupied
+	s.tasks = make(map[string]bool)
+	s.updated = make(map[int]string)
+	_ = Run(s, 1, today, datestest.Day(t, "2050-01-09"))
+	if s.updated[1] != "" {
+		t.Errorf("expected room 1 left alone the day before, got %v", s.updated)
+	}
+}
+
+func TestAfterTask(t *testing.T) {
+	var tests = []struct {
+		status   string
+		taskType string
+		expected string
+	}{
+		{StatusDirty, TaskDeparture, StatusClean},
+		{StatusInspected, TaskStayover, StatusInspected},
+		{StatusOutOfOrder, TaskDeparture, StatusOutOfOrder},
+	}
+
+	for _, e := range tests {
+		if got := AfterTask(e.status, e.taskType); got != e.expected {
+			t.Errorf("%s after %s: expected %s, got %s", e.status, e.taskType, e.expected, got)
+		}
+	}
+}
+
+func TestValidStatus(t *testing.T) {
+	if !ValidStatus(StatusOutOfOrder) || ValidStatus("sparkling") {
+		t.Error("wrong status validation")
+	}
+}
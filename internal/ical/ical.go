@@ -0,0 +1,270 @@
+// Package ical writes and reads iCalendar (RFC 5545) feeds of all day events, the format booking
+// platforms exchange room availability in
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProdID names this application in the feeds it writes
+const ProdID = "-//eldicela//bookings//EN"
+
+// dateLayout and stampLayout are the DATE and UTC DATE-TIME forms of RFC 5545
+const (
+	dateLayout  = "20060102"
+	stampLayout = "20060102T150405Z"
+)
+
+// maxLine is the longest a content line may be in octets, longer lines are folded
+const maxLine = 75
+
+// ErrNotCalendar is returned when what is read is not an iCalendar object, such as the error page of a
+// platform that is down
+var ErrNotCalendar = errors.New("not an iCalendar feed")
+
+// Event is an all day event. End is the day after the last day, the way a stay ends on the departure day
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+	Stamp   time.Time
+}
+
+// Calendar is a feed of events
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Write writes cal to w as an iCalendar object
+func Write(w io.Writer, cal Calendar) error {
+	lw := &lineWriter{w: w}
+
+	lw.line("BEGIN", "VCALENDAR")
+	lw.line("VERSION", "2.0")
+	lw.line("PRODID", ProdID)
+	lw.line("CALSCALE", "GREGORIAN")
+	lw.line("METHOD", "PUBLISH")
+	if cal.Name != "" {
+		lw.line("X-WR-CALNAME", escape(cal.Name))
+	}
+
+	for _, e := range cal.Events {
+		lw.line("BEGIN", "VEVENT")
+		lw.line("UID", e.UID)
+		lw.line("DTSTAMP", e.Stamp.UTC().Format(stampLayout))
+		lw.line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		lw.line("DTEND;VALUE=DATE", e.End.Format(dateLayout))
+		lw.line("SUMMARY", escape(e.Summary))
+		lw.line("TRANSP", "OPAQUE")
+		lw.line("END", "VEVENT")
+	}
+
+	lw.line("END", "VCALENDAR")
+
+	return lw.err
+}
+
+// lineWriter writes content lines and keeps the first error, so Write can check once at the end
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+// line writes a content line, folded to maxLine octets and ended with CRLF
+func (lw *lineWriter) line(name, value string) {
+	if lw.err != nil {
+		return
+	}
+	_, lw.err = io.WriteString(lw.w, fold(name+":"+value)+"\r\n")
+}
+
+// fold breaks a content line into lines of at most maxLine octets, each continuation starting with a
+// space. Lines are never broken inside a UTF-8 character
+func fold(s string) string {
+	if len(s) <= maxLine {
+		return s
+	}
+
+	var b strings.Builder
+	limit := maxLine
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// the leading space counts towards the length of continuation lines
+		limit = maxLine - 1
+	}
+	b.WriteString(s)
+
+	return b.String()
+}
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// UID builds a unique id for the event of a calendar row, scoped to the host serving the feed
+func UID(kind string, id int, host string) string {
+	return fmt.Sprintf("%s-%d@%s", kind, id, host)
+}
+
+// Parse reads the events of an iCalendar object. Events are read as all day events: timed events cover the
+// days they start and end on, as written, and cancelled events are left out. Recurrence rules are not
+// expanded
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var event *Event
+	var duration string
+	var cancelled, found bool
+	// components nested in an event, such as alarms, have properties of their own
+	var nested int
+
+	for i, line := range lines {
+		name, value := split(line)
+		if name == "BEGIN" || name == "END" {
+			value = strings.ToUpper(value)
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VCALENDAR":
+			found = true
+		case name == "BEGIN" && value == "VEVENT":
+			event, duration, cancelled, nested = &Event{}, "", false, 0
+		case event == nil:
+		case name == "BEGIN":
+			nested++
+		case name == "END" && value != "VEVENT":
+			nested--
+		case nested > 0:
+		case name == "END":
+			if event.Start.IsZero() {
+				return nil, fmt.Errorf("event ending on line %d has no start", i+1)
+			}
+			if event.End.IsZero() {
+				event.End = event.Start.AddDate(0, 0, days(duration))
+			}
+			if !event.End.After(event.Start) {
+				event.End = event.Start.AddDate(0, 0, 1)
+			}
+			if !cancelled {
+				events = append(events, *event)
+			}
+			event = nil
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Summary = unescape(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DURATION":
+			duration = value
+		case name == "DTSTART", name == "DTEND":
+			day, err := parseDay(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", i+1, name, value)
+			}
+			if name == "DTSTART" {
+				event.Start = day
+			} else {
+				event.End = day
+			}
+		}
+	}
+
+	if !found {
+		return nil, ErrNotCalendar
+	}
+
+	return events, nil
+}
+
+// unfold reads the content lines, joining the lines folded by the writer
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, sc.Err()
+}
+
+// split cuts a content line into its upper cased name and its value, dropping the parameters. Parameter
+// values may be quoted and hold colons
+func split(line string) (string, string) {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			name := line[:i]
+			if j := strings.IndexByte(name, ';'); j >= 0 {
+				name = name[:j]
+			}
+			return strings.ToUpper(name), line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), ""
+}
+
+// parseDay reads the day of a DATE or DATE-TIME value. The time and time zone of a DATE-TIME are ignored
+func parseDay(value string) (time.Time, error) {
+	if len(value) < len(dateLayout) {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return time.Parse(dateLayout, value[:len(dateLayout)])
+}
+
+// durationDays matches the weeks and days of a DURATION, smaller parts do not make another day
+var durationDays = regexp.MustCompile(`^\+?P(?:(\d+)W)?(?:(\d+)D)?`)
+
+// days returns the whole days of a DURATION, at least one
+func days(duration string) int {
+	m := durationDays.FindStringSubmatch(duration)
+	if m == nil {
+		return 1
+	}
+	weeks, _ := strconv.Atoi(m[1])
+	n, _ := strconv.Atoi(m[2])
+	if n += 7 * weeks; n < 1 {
+		return 1
+	}
+	return n
+}
+
+// unescape reverses escape
+func unescape(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
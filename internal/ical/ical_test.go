@@ -0,0 +1,112 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates/datestest"
+)
+
+func TestWrite(t *testing.T) {
+	cal := Calendar{
+		Name: "Room 1, Garden",
+		Events: []Event{{
+			UID:     UID("restriction", 12, "example.com"),
+			Summary: "Reserved",
+			Start:   datestest.Day(t, "2050-01-01"),
+			End:     datestest.Day(t, "2050-01-03"),
+			Stamp:   time.Date(2049, 12, 1, 10, 30, 0, 0, time.FixedZone("CET", 3600)),
+		}},
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"X-WR-CALNAME:Room 1\\, Garden\r\n",
+		"UID:restriction-12@example.com\r\n",
+		"DTSTAMP:20491201T093000Z\r\n",
+		"DTSTART;VALUE=DATE:20500101\r\n",
+		"DTEND;VALUE=DATE:20500103\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	s := "SUMMARY:" + strings.Repeat("é", 60)
+	folded := fold(s)
+
+	for _, line := range strings.Split(folded, "\r\n") {
+		if len(line) > maxLine {
+			t.Errorf("line of %d octets", len(line))
+		}
+	}
+	if strings.ReplaceAll(folded, "\r\n ", "") != s {
+		t.Error("expected unfolding to give back the line")
+	}
+	if !strings.HasPrefix(strings.Split(folded, "\r\n")[1], " ") {
+		t.Error("expected continuation lines to start with a space")
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got := escape("a;b,c\\d\ne"); got != `a\;b\,c\\d\ne` {
+		t.Errorf("wrong escape %s", got)
+	}
+}
+
+const feed = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Other//EN\r\n" +
+	"BEGIN:VEVENT\r\nUID:a@other\r\nDTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500104\r\n" +
+	"SUMMARY:Reserved\\, thanks\r\nBEGIN:VALARM\r\nDTSTART:20000101T000000Z\r\nEND:VALARM\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:b@other\r\nDTSTART;TZID=\"Europe/Berlin\":20500110T150000\r\nDURATION:P2D\r\n" +
+	"SUMMARY:A very long summary that the other platform folded onto\r\n  a second line\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:c@other\r\nDTSTART;VALUE=DATE:20500201\r\nSTATUS:CANCELLED\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:d@other\r\nDTSTART:20500301T100000Z\r\nDTEND:20500301T120000Z\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events without the cancelled one, got %+v", events)
+	}
+	if events[0].UID != "a@other" || events[0].Summary != "Reserved, thanks" || events[0].Start != datestest.Day(t, "2050-01-01") || events[0].End != datestest.Day(t, "2050-01-04") {
+		t.Errorf("wrong first event %+v", events[0])
+	}
+	if events[1].Start != datestest.Day(t, "2050-01-10") || events[1].End != datestest.Day(t, "2050-01-12") {
+		t.Errorf("expected a duration of two days, got %+v", events[1])
+	}
+	if !strings.HasSuffix(events[1].Summary, "onto a second line") {
+		t.Errorf("expected the summary to be unfolded, got %q", events[1].Summary)
+	}
+	if events[2].End != datestest.Day(t, "2050-03-02") {
+		t.Errorf("expected a timed event to take its day, got %+v", events[2])
+	}
+
+	// what is written can be read back
+	var buf bytes.Buffer
+	Write(&buf, Calendar{Events: events})
+	again, err := Parse(&buf)
+	if err != nil || len(again) != 3 || again[1].Summary != events[1].Summary {
+		t.Errorf("expected the written feed to read back, got %+v %v", again, err)
+	}
+
+	_, err = Parse(strings.NewReader("<html>Service Unavailable</html>"))
+	if err != ErrNotCalendar {
+		t.Errorf("expected ErrNotCalendar, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+// Package importer loads reservations and blocks from a CSV file, such as an export of the system a
+// property migrates from. Every row is checked before anything is written, so a dry run shows exactly
+// what an import would do
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/forms"
+	"github.com/eldicela/bookings/internal/helpers"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Kinds of row
+const (
+	KindReservation = "reservation"
+	KindBlock       = "block"
+)
+
+// RestrictionBlock is the restriction id of the blocks staff set on the calendar
+const RestrictionBlock = 2
+
+// Columns are the columns an import file can have, in the order of the sample file. Only room, arrival
+// and departure are needed by every row, type defaults to reservation
+var Columns = []string{"type", "room", "first_name", "last_name", "email", "phone", "arrival", "departure", "adults", "children"}
+
+// ErrMissingColumn is returned when the header of a file lacks a column every row needs
+var ErrMissingColumn = errors.New("import file is missing a column")
+
+// Row is one line of an import file. Blocks only use the room and dates of Reservation
+type Row struct {
+	Line        int
+	Kind        string
+	Reservation models.Reservation
+	Errors      []string
+}
+
+// Valid reports whether the row can be imported
+func (r Row) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Result is the outcome of an import, or of a dry run when nothing was imported
+type Result struct {
+	Rows         []Row
+	Valid        int
+	Invalid      int
+	Reservations []models.Reservation
+	Blocks       []models.RoomRestriction
+	Imported     bool
+}
+
+// Store is what an import reads from and writes to
+type Store interface {
+	AllRooms(propertyId int) ([]models.Room, error)
+	GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error)
+	ImportBookings(reservations []models.Reservation, blocks []models.RoomRestriction) ([]models.Reservation, []models.RoomRestriction, error)
+}
+
+// Run reads an import file for a property and checks every row against the rooms and calendars of the
+// property. When commit is set the valid rows are then written in one transaction, guest profiles included,
+// so either all of them are imported or, if the calendars changed in the meantime, none
+func Run(store Store, propertyId int, r io.Reader, commit bool) (Result, error) {
+	var result Result
+
+	rooms, err := store.AllRooms(propertyId)
+	if err != nil {
+		return result, err
+	}
+
+	rows, err := Parse(r, rooms)
+	if err != nil {
+		return result, err
+	}
+
+	existing, err := calendars(store, rows)
+	if err != nil {
+		return result, err
+	}
+	Check(rows, existing)
+
+	result.Rows = rows
+	for _, row := range rows {
+		if row.Valid() {
+			result.Valid++
+		} else {
+			result.Invalid++
+		}
+	}
+
+	if !commit || result.Valid == 0 {
+		return result, nil
+	}
+
+	var reservations []models.Reservation
+	var blocks []models.RoomRestriction
+	for _, row := range rows {
+		if !row.Valid() {
+			continue
+		}
+
+		res := row.Reservation
+		if row.Kind == KindBlock {
+			// one block a night, the way the calendar sets them
+			for _, d := range res.Stay().Days() {
+				blocks = append(blocks, models.RoomRestriction{
+					RoomId:        res.RoomId,
+					StartDate:     d,
+					EndDate:       d.AddDate(0, 0, 1),
+					RestrictionId: RestrictionBlock,
+				})
+			}
+			continue
+		}
+
+		res.ManageToken, err = helpers.RandomToken(16)
+		if err != nil {
+			return result, err
+		}
+		reservations = append(reservations, res)
+	}
+
+	result.Reservations, result.Blocks, err = store.ImportBookings(reservations, blocks)
+	if err != nil {
+		return result, err
+	}
+	result.Imported = true
+
+	return result, nil
+}
+
+// Parse reads the rows of an import file and validates each on its own: required fields, email,
+// dates, room and party size. Rows are matched to rooms by name, ignoring case
+func Parse(r io.Reader, rooms []models.Room) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the header of the import file: %w", err)
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	}
+	for _, required := range []string{"room", "arrival", "departure"} {
+		if !contains(header, required) {
+			return nil, fmt.Errorf("%w: %s", ErrMissingColumn, required)
+		}
+	}
+
+	byName := make(map[string]models.Room, len(rooms))
+	for _, room := range rooms {
+		byName[strings.ToLower(strings.TrimSpace(room.RoomName))] = room
+	}
+
+	var rows []Row
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		values := url.Values{}
+		blank := true
+		for i, h := range header {
+			if i < len(record) {
+				v := strings.TrimSpace(record[i])
+				values.Set(h, v)
+				if v != "" {
+					blank = false
+				}
+			}
+		}
+		if blank {
+			continue
+		}
+
+		rows = append(rows, parseRow(line, values, byName))
+	}
+
+	return rows, nil
+}
+
+// parseRow validates one row with the rules of the reservation form
+func parseRow(line int, values url.Values, rooms map[string]models.Room) Row {
+	row := Row{Line: line, Kind: strings.ToLower(values.Get("type"))}
+	if row.Kind == "" {
+		row.Kind = KindReservation
+	}
+
+	form := forms.New(values)
+	form.Required("room", "arrival", "departure")
+
+	switch row.Kind {
+	case KindReservation:
+		form.Required("first_name", "last_name", "email")
+		form.MinLength("first_name", 3)
+		form.IsEmail("email")
+	case KindBlock:
+	default:
+		form.Errors.Add("type", "Type must be reservation or block")
+	}
+
+	res := models.Reservation{
+		FirstName: values.Get("first_name"),
+		LastName:  values.Get("last_name"),
+		Email:     values.Get("email"),
+		Phone:     values.Get("phone"),
+		Adults:    1,
+	}
+
+	if form.Has("arrival") && form.Has("departure") {
+		stay, err := dates.Parse(values.Get("arrival"), values.Get("departure"))
+		if err != nil {
+			form.Errors.Add("departure", fmt.Sprintf("Invalid stay: %s", err))
+		}
+		res.StartDate, res.EndDate = stay.Start, stay.End
+	}
+
+	room, ok := rooms[strings.ToLower(values.Get("room"))]
+	if form.Has("room") && !ok {
+		form.Errors.Add("room", fmt.Sprintf("No room called %q", values.Get("room")))
+	}
+	res.RoomId = room.ID
+	res.Room = room
+	res.RoomTypeId = room.RoomTypeId
+
+	if row.Kind == KindReservation {
+		for field, n := range map[string]*int{"adults": &res.Adults, "children": &res.Children} {
+			if !form.Has(field) {
+				continue
+			}
+			v, err := strconv.Atoi(values.Get(field))
+			if err != nil || v < 0 {
+				form.Errors.Add(field, "Must be a whole number")
+				continue
+			}
+			*n = v
+		}
+		if res.Adults < 1 {
+			form.Errors.Add("adults", "At least one adult must stay")
+		}
+		if room.MaxOccupancy > 0 && res.Guests() > room.MaxOccupancy {
+			form.Errors.Add("adults", fmt.Sprintf("This room sleeps at most %d guests", room.MaxOccupancy))
+		}
+	}
+
+	row.Reservation = res
+	for _, field := range append([]string{"type"}, Columns...) {
+		for _, msg := range form.Errors[field] {
+			row.Errors = append(row.Errors, fmt.Sprintf("%s: %s", field, msg))
+		}
+	}
+
+	return row
+}
+
+// Check marks the valid rows whose nights are taken, either on the calendar of their room or by an
+// earlier row of the file
+func Check(rows []Row, existing []models.RoomRestriction) {
+	taken := make(map[int][]models.RoomRestriction)
+	for _, rr := range existing {
+		taken[rr.RoomId] = append(taken[rr.RoomId], rr)
+	}
+	claimed := make(map[int][]int)
+
+	for i := range rows {
+		row := &rows[i]
+		if !row.Valid() {
+			continue
+		}
+		stay := row.Reservation.Stay()
+		roomId := row.Reservation.RoomId
+
+		for _, rr := range taken[roomId] {
+			if stay.Overlaps(dates.Range{Start: rr.StartDate, End: rr.EndDate}) {
+				what := "a block"
+				if rr.ReservationId > 0 {
+					what = fmt.Sprintf("reservation %d", rr.ReservationId)
+				}
+				row.Errors = append(row.Errors, fmt.Sprintf("%s is taken by %s from %s to %s", row.Reservation.Room.RoomName,
+					what, rr.StartDate.Format(dates.Layout), rr.EndDate.Format(dates.Layout)))
+				break
+			}
+		}
+
+		for _, j := range claimed[roomId] {
+			if stay.Overlaps(rows[j].Reservation.Stay()) {
+				row.Errors = append(row.Errors, fmt.Sprintf("overlaps line %d", rows[j].Line))
+				break
+			}
+		}
+
+		if row.Valid() {
+			claimed[roomId] = append(claimed[roomId], i)
+		}
+	}
+}
+
+// calendars loads the calendar rows of every room the file books, over the nights it books
+func calendars(store Store, rows []Row) ([]models.RoomRestriction, error) {
+	spans := make(map[int]dates.Range)
+	for _, row := range rows {
+		if !row.Valid() {
+			continue
+		}
+		stay := row.Reservation.Stay()
+		span, ok := spans[row.Reservation.RoomId]
+		if !ok {
+			spans[row.Reservation.RoomId] = stay
+			continue
+		}
+		if stay.Start.Before(span.Start) {
+			span.Start = stay.Start
+		}
+		if stay.End.After(span.End) {
+			span.End = stay.End
+		}
+		spans[row.Reservation.RoomId] = span
+	}
+
+	roomIds := make([]int, 0, len(spans))
+	for id := range spans {
+		roomIds = append(roomIds, id)
+	}
+	sort.Ints(roomIds)
+
+	var existing []models.RoomRestriction
+	for _, id := range roomIds {
+		rr, err := store.GetRestrictionsForRoomByDate(id, spans[id])
+		if err != nil {
+			return nil, err
+		}
+		existing = append(existing, rr...)
+	}
+
+	return existing, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+var rooms = []models.Room{
+	{ID: 1, RoomName: "Garden Suite", MaxOccupancy: 2},
+	{ID: 2, RoomName: "Sea View"},
+}
+
+const file = `type,room,first_name,last_name,email,phone,arrival,departure,adults,children
+reservation,garden suite,John,Smith,john@here.com,555,2050-01-01,2050-01-03,2,0
+,Sea View,Jo,Smith,jo@here.com,,2050-01-01,2050-01-03,,
+reservation,Attic,Jane,Doe,jane,,2050-01-03,2050-01-01,1,
+block,Sea View,,,,,2050-01-05,2050-01-07,,
+reservation,Garden Suite,Mary,Major,mary@here.com,,2050-01-02,2050-01-04,2,1
+,,,,,,,,,
+reservation,Garden Suite,Anne,Other,anne@here.com,,2050-01-05,2050-01-06,1,0
+`
+
+func TestParse(t *testing.T) {
+	rows, err := Parse(strings.NewReader(file), rooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 6 {
+		t.Fatalf("expected 6 rows without the blank one, got %d", len(rows))
+	}
+
+	if !rows[0].Valid() || rows[0].Reservation.RoomId != 1 || rows[0].Reservation.Adults != 2 || rows[0].Line != 2 {
+		t.Errorf("expected a valid first row, got %+v", rows[0])
+	}
+	// first name too short
+	if rows[1].Valid() || rows[1].Kind != KindReservation {
+		t.Errorf("expected the second row to fail, got %+v", rows[1])
+	}
+	// unknown room, bad email and departure before arrival
+	if len(rows[2].Errors) != 3 {
+		t.Errorf("expected 3 errors, got %v", rows[2].Errors)
+	}
+	if !rows[3].Valid() || rows[3].Kind != KindBlock {
+		t.Errorf("expected a valid block, got %+v", rows[3])
+	}
+	// three guests in a room for two
+	if rows[4].Valid() {
+		t.Error("expected the room to be too small")
+	}
+	if rows[5].Line != 8 {
+		t.Errorf("expected line 8, got %d", rows[5].Line)
+	}
+
+	_, err = Parse(strings.NewReader("room,arrival\n"), rooms)
+	if !errors.Is(err, ErrMissingColumn) {
+		t.Errorf("expected ErrMissingColumn, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	row := func(line, room int, start, end string) Row {
+		return Row{Line: line, Kind: KindReservation, Reservation: models.Reservation{RoomId: room, StartDate: datestest.Day(t, start), EndDate: datestest.Day(t, end)}}
+	}
+	rows := []Row{
+		row(2, 1, "2050-01-01", "2050-01-03"),
+		row(3, 1, "2050-01-02", "2050-01-04"),
+		row(4, 1, "2050-01-03", "2050-01-05"),
+		row(5, 2, "2050-01-01", "2050-01-03"),
+	}
+	existing := []models.RoomRestriction{
+		{RoomId: 2, StartDate: datestest.Day(t, "2050-01-02"), EndDate: datestest.Day(t, "2050-01-03"), RestrictionId: RestrictionBlock},
+	}
+
+	Check(rows, existing)
+
+	if !rows[0].Valid() || !rows[2].Valid() {
+		t.Errorf("expected back to back stays to pass, got %v %v", rows[0].Errors, rows[2].Errors)
+	}
+	if rows[1].Valid() || !strings.Contains(rows[1].Errors[0], "line 2") {
+		t.Errorf("expected an overlap with line 2, got %v", rows[1].Errors)
+	}
+	if rows[3].Valid() {
+		t.Error("expected the block to be a conflict")
+	}
+}
+
+type store struct {
+	restrictions []models.RoomRestriction
+	reservations []models.Reservation
+	blocks       []models.RoomRestriction
+}
+
+func (s *store) AllRooms(propertyId int) ([]models.Room, error) {
+	return rooms, nil
+}
+
+func (s *store) GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error) {
+	var found []models.RoomRestriction
+	for _, rr := range s.restrictions {
+		if rr.RoomId == roomId && period.Overlaps(dates.Range{Start: rr.StartDate, End: rr.EndDate}) {
+			found = append(found, rr)
+		}
+	}
+	return found, nil
+}
+
+func (s *store) ImportBookings(reservations []models.Reservation, blocks []models.RoomRestriction) ([]models.Reservation, []models.RoomRestriction, error) {
+	s.reservations = reservations
+	s.blocks = blocks
+	for i := range reservations {
+		reservations[i].GuestId = 7
+	}
+	for i := range blocks {
+		blocks[i].ID = i + 1
+	}
+	return reservations, blocks, nil
+}
+
+func TestRun(t *testing.T) {
+	s := &store{restrictions: []models.RoomRestriction{
+		{RoomId: 1, ReservationId: 9, StartDate: datestest.Day(t, "2050-01-05"), EndDate: datestest.Day(t, "2050-01-08"), RestrictionId: 1},
+	}}
+
+	result, err := Run(s, 1, strings.NewReader(file), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid != 2 || result.Invalid != 4 || result.Imported || s.reservations != nil {
+		t.Errorf("expected a dry run with 2 valid rows, got %+v", result)
+	}
+
+	result, err = Run(s, 1, strings.NewReader(file), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Imported || len(s.reservations) != 1 || len(s.blocks) != 2 || len(result.Blocks) != 2 {
+		t.Fatalf("expected a reservation and a two night block, got %+v %+v", s.reservations, s.blocks)
+	}
+	if result.Reservations[0].GuestId != 7 || result.Reservations[0].ManageToken == "" {
+		t.Errorf("expected a guest and a token, got %+v", result.Reservations[0])
+	}
+	if s.blocks[1].StartDate != datestest.Day(t, "2050-01-06") || s.blocks[1].RestrictionId != RestrictionBlock {
+		t.Errorf("wrong block %+v", s.blocks[1])
+	}
+}
@@ -0,0 +1,151 @@
+package integrity
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// RestrictionReservation is the restriction id of calendar rows made by reservations. Other rows are
+// blocks set by staff
+const RestrictionReservation = 1
+
+// Kinds of issue
+const (
+	// KindOverlap is two calendar rows of one room sharing a night
+	KindOverlap = "overlap"
+	// KindOrphanedRestriction is a reservation calendar row whose reservation does not exist
+	KindOrphanedRestriction = "orphaned_restriction"
+	// KindMissingRestriction is a reservation that is not on its room calendar
+	KindMissingRestriction = "missing_restriction"
+)
+
+// Issue is one inconsistency found in the database. Fixable issues can be repaired without deciding
+// anything a person should decide, like which of two overlapping stays to move
+type Issue struct {
+	Kind           string `json:"kind"`
+	RoomId         int    `json:"room_id"`
+	ReservationId  int    `json:"reservation_id,omitempty"`
+	RestrictionIds []int  `json:"restriction_ids,omitempty"`
+	Message        string `json:"message"`
+	Fixable        bool   `json:"fixable"`
+	Fixed          bool   `json:"fixed"`
+}
+
+// Report is the outcome of a check
+type Report struct {
+	Reservations int     `json:"reservations"`
+	Restrictions int     `json:"restrictions"`
+	Issues       []Issue `json:"issues"`
+}
+
+// Unfixed returns how many issues are left in the database
+func (r Report) Unfixed() int {
+	n := 0
+	for _, i := range r.Issues {
+		if !i.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+// Check looks for room calendar rows that overlap, reservation rows without a reservation and
+// reservations missing from their room calendar
+func Check(reservations []models.Reservation, restrictions []models.RoomRestriction) Report {
+	report := Report{
+		Reservations: len(reservations),
+		Restrictions: len(restrictions),
+		Issues:       []Issue{},
+	}
+
+	byId := make(map[int]models.Reservation, len(reservations))
+	for _, res := range reservations {
+		byId[res.ID] = res
+	}
+
+	byRoom := make(map[int][]models.RoomRestriction)
+	onCalendar := make(map[int]bool)
+	for _, rr := range restrictions {
+		byRoom[rr.RoomId] = append(byRoom[rr.RoomId], rr)
+		if rr.ReservationId > 0 {
+			onCalendar[rr.ReservationId] = true
+		}
+	}
+
+	for _, rr := range restrictions {
+		if rr.RestrictionId != RestrictionReservation {
+			continue
+		}
+		if _, ok := byId[rr.ReservationId]; rr.ReservationId > 0 && ok {
+			continue
+		}
+		report.Issues = append(report.Issues, Issue{
+			Kind:           KindOrphanedRestriction,
+			RoomId:         rr.RoomId,
+			ReservationId:  rr.ReservationId,
+			RestrictionIds: []int{rr.ID},
+			Message: fmt.Sprintf("room %d is taken %s by restriction %d for reservation %d, which does not exist",
+				rr.RoomId, stay(rr), rr.ID, rr.ReservationId),
+			Fixable: true,
+		})
+	}
+
+	rooms := make([]int, 0, len(byRoom))
+	for roomId := range byRoom {
+		rooms = append(rooms, roomId)
+	}
+	sort.Ints(rooms)
+
+	for _, roomId := range rooms {
+		rows := byRoom[roomId]
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i].StartDate.Before(rows[j].StartDate)
+		})
+
+		for i := range rows {
+			for j := i + 1; j < len(rows) && rows[j].StartDate.Before(rows[i].EndDate); j++ {
+				if !stay(rows[i]).Overlaps(stay(rows[j])) {
+					continue
+				}
+				report.Issues = append(report.Issues, Issue{
+					Kind:           KindOverlap,
+					RoomId:         roomId,
+					RestrictionIds: []int{rows[i].ID, rows[j].ID},
+					Message: fmt.Sprintf("room %d is taken %s by restriction %d and %s by restriction %d",
+						roomId, stay(rows[i]), rows[i].ID, stay(rows[j]), rows[j].ID),
+				})
+			}
+		}
+	}
+
+	for _, res := range reservations {
+		if onCalendar[res.ID] {
+			continue
+		}
+
+		// putting the stay back on the calendar is only safe while nothing else has taken the room
+		free := true
+		for _, rr := range byRoom[res.RoomId] {
+			if stay(rr).Overlaps(res.Stay()) {
+				free = false
+			}
+		}
+
+		report.Issues = append(report.Issues, Issue{
+			Kind:          KindMissingRestriction,
+			RoomId:        res.RoomId,
+			ReservationId: res.ID,
+			Message:       fmt.Sprintf("reservation %d for room %d %s is not on the room calendar", res.ID, res.RoomId, res.Stay()),
+			Fixable:       free,
+		})
+	}
+
+	return report
+}
+
+func stay(rr models.RoomRestriction) dates.Range {
+	return dates.Range{Start: rr.StartDate, End: rr.EndDate}
+}
@@ -0,0 +1,91 @@
+package integrity
+
+import (
+	"testing"
+
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func restriction(t testing.TB, id, roomId, reservationId int, start, end string) models.RoomRestriction {
+	t.Helper()
+
+	kind := RestrictionReservation
+	if reservationId == 0 {
+		kind = 2
+	}
+	return models.RoomRestriction{
+		ID:            id,
+		RoomId:        roomId,
+		ReservationId: reservationId,
+		RestrictionId: kind,
+		StartDate:     datestest.Day(t, start),
+		EndDate:       datestest.Day(t, end),
+	}
+}
+
+func TestCheck_Clean(t *testing.T) {
+	reservations := []models.Reservation{
+		{ID: 1, RoomId: 1, StartDate: datestest.Day(t, "2050-01-10"), EndDate: datestest.Day(t, "2050-01-12")},
+	}
+	restrictions := []models.RoomRestriction{
+		restriction(t, 1, 1, 1, "2050-01-10", "2050-01-12"),
+		// a block starting on the departure day does not overlap
+		restriction(t, 2, 1, 0, "2050-01-12", "2050-01-13"),
+	}
+
+	report := Check(reservations, restrictions)
+	if len(report.Issues) != 0 {
+		t.Errorf("expected no issues, got %+v", report.Issues)
+	}
+	if report.Reservations != 1 || report.Restrictions != 2 {
+		t.Errorf("wrong counts %+v", report)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	reservations := []models.Reservation{
+		{ID: 1, RoomId: 1, StartDate: datestest.Day(t, "2050-01-10"), EndDate: datestest.Day(t, "2050-01-12")},
+		{ID: 2, RoomId: 1, StartDate: datestest.Day(t, "2050-01-11"), EndDate: datestest.Day(t, "2050-01-14")},
+		// missing from the calendar, the room is free
+		{ID: 3, RoomId: 2, StartDate: datestest.Day(t, "2050-01-10"), EndDate: datestest.Day(t, "2050-01-12")},
+		// missing from the calendar, a block took the room since
+		{ID: 4, RoomId: 3, StartDate: datestest.Day(t, "2050-01-10"), EndDate: datestest.Day(t, "2050-01-12")},
+	}
+	restrictions := []models.RoomRestriction{
+		restriction(t, 1, 1, 1, "2050-01-10", "2050-01-12"),
+		restriction(t, 2, 1, 2, "2050-01-11", "2050-01-14"),
+		restriction(t, 3, 2, 9, "2050-02-01", "2050-02-03"),
+		restriction(t, 4, 3, 0, "2050-01-11", "2050-01-12"),
+	}
+
+	report := Check(reservations, restrictions)
+
+	kinds := make(map[string][]Issue)
+	for _, i := range report.Issues {
+		kinds[i.Kind] = append(kinds[i.Kind], i)
+	}
+
+	if got := kinds[KindOverlap]; len(got) != 1 || got[0].RoomId != 1 || got[0].Fixable {
+		t.Errorf("expected one unfixable overlap on room 1, got %+v", got)
+	}
+
+	if got := kinds[KindOrphanedRestriction]; len(got) != 1 || got[0].RestrictionIds[0] != 3 || !got[0].Fixable {
+		t.Errorf("expected restriction 3 to be a fixable orphan, got %+v", got)
+	}
+
+	missing := kinds[KindMissingRestriction]
+	if len(missing) != 2 {
+		t.Fatalf("expected 2 reservations missing from the calendar, got %+v", missing)
+	}
+	if missing[0].ReservationId != 3 || !missing[0].Fixable {
+		t.Errorf("expected reservation 3 to be fixable, got %+v", missing[0])
+	}
+	if missing[1].ReservationId != 4 || missing[1].Fixable {
+		t.Errorf("expected reservation 4 not to be fixable, got %+v", missing[1])
+	}
+
+	if report.Unfixed() != 4 {
+		t.Errorf("expected 4 unfixed issues, got %d", report.Unfixed())
+	}
+}
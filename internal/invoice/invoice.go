@@ -0,0 +1,163 @@
+package invoice
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/eldicela/bookings/internal/folio"
+	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/money"
+	"github.com/eldicela/bookings/internal/pdf"
+	"github.com/eldicela/bookings/internal/pricing"
+)
+
+// Issuer is the business the invoice is issued by
+type Issuer struct {
+	Name    string
+	Address string
+	Email   string
+}
+
+// Invoice holds everything printed on an invoice
+type Invoice struct {
+	Number      string
+	IssuedAt    time.Time
+	Currency    string
+	Issuer      Issuer
+	Reservation models.Reservation
+	Charges     []models.FolioEntry
+	Payments    []models.FolioEntry
+	Total       int
+	Paid        int
+}
+
+// New builds the invoice of a reservation from its folio. Voided entries and their voids are left out
+func New(issuer Issuer, res models.Reservation, entries []models.FolioEntry, currency string, issuedAt time.Time) Invoice {
+	inv := Invoice{
+		Number:      fmt.Sprintf("INV-%06d", res.ID),
+		IssuedAt:    issuedAt,
+		Currency:    currency,
+		Issuer:      issuer,
+		Reservation: res,
+	}
+
+	for _, l := range folio.Ledger(entries) {
+		if l.Voided || l.VoidsId > 0 {
+			continue
+		}
+		if l.EntryType == folio.TypePayment {
+			inv.Payments = append(inv.Payments, l.FolioEntry)
+			inv.Paid += l.Amount
+		} else {
+			inv.Charges = append(inv.Charges, l.FolioEntry)
+			inv.Total += l.Amount
+		}
+	}
+
+	return inv
+}
+
+// BalanceDue returns what the guest still owes
+func (inv Invoice) BalanceDue() int {
+	return inv.Total - inv.Paid
+}
+
+// TaxesAndFees returns the part of the total charged as taxes and fees
+func (inv Invoice) TaxesAndFees() int {
+	var t int
+	for _, c := range inv.Charges {
+		if c.Category == folio.CategoryTax || c.Category == folio.CategoryFee {
+			t += c.Amount
+		}
+	}
+	return t
+}
+
+// Title is Receipt once the invoice is settled
+func (inv Invoice) Title() string {
+	if len(inv.Charges) > 0 && inv.BalanceDue() <= 0 {
+		return "Receipt"
+	}
+	return "Invoice"
+}
+
+// Filename returns the name used when downloading or attaching the invoice
+func (inv Invoice) Filename() string {
+	return fmt.Sprintf("%s.pdf", inv.Number)
+}
+
+// PDF renders the invoice
+func (inv Invoice) PDF() []byte {
+	const left, right = 50.0, pdf.PageWidth - 50
+
+	doc := pdf.New()
+	page := doc.AddPage()
+	y := pdf.PageHeight - 60
+
+	newLine := func(step float64) {
+		y -= step
+		if y < 60 {
+			page = doc.AddPage()
+			y = pdf.PageHeight - 60
+		}
+	}
+
+	page.Text(left, y, 20, true, inv.Title())
+	page.TextRight(right, y, 10, false, inv.Number)
+	newLine(16)
+	page.TextRight(right, y, 10, false, fmt.Sprintf("Issued %s", inv.IssuedAt.Format("2006-01-02")))
+
+	newLine(30)
+	page.Text(left, y, 10, true, inv.Issuer.Name)
+	page.Text(300, y, 10, true, "Bill to")
+	newLine(14)
+	page.Text(left, y, 10, false, inv.Issuer.Address)
+	page.Text(300, y, 10, false, fmt.Sprintf("%s %s", inv.Reservation.FirstName, inv.Reservation.LastName))
+	newLine(14)
+	page.Text(left, y, 10, false, inv.Issuer.Email)
+	page.Text(300, y, 10, false, inv.Reservation.Email)
+	newLine(14)
+	page.Text(300, y, 10, false, inv.Reservation.Phone)
+
+	newLine(30)
+	page.Text(left, y, 10, true, "Stay")
+	newLine(14)
+	page.Text(left, y, 10, false, fmt.Sprintf("%s, %s to %s, %d night(s)",
+		inv.Reservation.Room.RoomName,
+		inv.Reservation.StartDate.Format("2006-01-02"),
+		inv.Reservation.EndDate.Format("2006-01-02"),
+		pricing.Nights(inv.Reservation.StartDate, inv.Reservation.EndDate)))
+
+	section := func(title string, entries []models.FolioEntry, total int, totalLabel string) {
+		newLine(30)
+		page.Text(left, y, 10, true, title)
+		page.TextRight(right, y, 10, true, fmt.Sprintf("Amount (%s)", inv.Currency))
+		newLine(6)
+		page.Line(left, y, right, y)
+		for _, e := range entries {
+			newLine(16)
+			page.Text(left, y, 10, false, e.CreatedAt.Format("2006-01-02"))
+			page.Text(left+80, y, 10, false, e.Description)
+			page.TextRight(right, y, 10, false, money.Format(e.Amount))
+		}
+		newLine(8)
+		page.Line(left, y, right, y)
+		newLine(16)
+		page.Text(left+80, y, 10, true, totalLabel)
+		page.TextRight(right, y, 10, true, money.Format(total))
+	}
+
+	section("Charges", inv.Charges, inv.Total, "Total")
+	if t := inv.TaxesAndFees(); t != 0 {
+		newLine(14)
+		page.Text(left+80, y, 10, false, "of which taxes and fees")
+		page.TextRight(right, y, 10, false, money.Format(t))
+	}
+	section("Payments", inv.Payments, inv.Paid, "Total paid")
+
+	newLine(30)
+	page.Text(left+80, y, 12, true, "Balance due")
+	page.TextRight(right, y, 12, true, fmt.Sprintf("%s %s", money.Format(inv.BalanceDue()), inv.Currency))
+
+	return doc.Bytes()
+}
@@ -0,0 +1,73 @@
+package invoice
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/folio"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+var res = models.Reservation{
+	ID:        42,
+	FirstName: "John",
+	LastName:  "Smith",
+	Room:      models.Room{RoomName: "General's Quarters"},
+}
+
+var entries = []models.FolioEntry{
+	{ID: 1, EntryType: folio.TypeCharge, Category: folio.CategoryRoom, Description: "Room", Amount: 20000},
+	{ID: 2, EntryType: folio.TypePayment, Category: folio.CategoryDeposit, Description: "Deposit", Amount: 4000},
+	{ID: 3, EntryType: folio.TypeCharge, Category: folio.CategoryExtra, Description: "Minibar", Amount: 1550},
+	{ID: 4, EntryType: folio.TypeCharge, Category: folio.CategoryExtra, Description: "Void: Minibar", Amount: -1550, VoidsId: 3},
+}
+
+func TestNew(t *testing.T) {
+	inv := New(Issuer{Name: "Fort Smythe"}, res, entries, "USD", time.Now())
+
+	if inv.Number != "INV-000042" {
+		t.Errorf("wrong invoice number %s", inv.Number)
+	}
+	if len(inv.Charges) != 1 {
+		t.Errorf("expected voided entries to be left out, got %d charges", len(inv.Charges))
+	}
+	if inv.BalanceDue() != 16000 {
+		t.Errorf("expected balance due 16000, got %d", inv.BalanceDue())
+	}
+	if inv.Title() != "Invoice" {
+		t.Errorf("expected Invoice, got %s", inv.Title())
+	}
+
+	paid := append(entries, models.FolioEntry{ID: 5, EntryType: folio.TypePayment, Category: folio.CategoryPayment, Amount: 16000})
+	inv = New(Issuer{}, res, paid, "USD", time.Now())
+	if inv.Title() != "Receipt" {
+		t.Errorf("expected Receipt for a settled folio, got %s", inv.Title())
+	}
+}
+
+func TestInvoice_TaxesAndFees(t *testing.T) {
+	taxed := append(entries,
+		models.FolioEntry{ID: 5, EntryType: folio.TypeCharge, Category: folio.CategoryTax, Description: "Occupancy tax", Amount: 600},
+		models.FolioEntry{ID: 6, EntryType: folio.TypeCharge, Category: folio.CategoryFee, Description: "Cleaning fee", Amount: 4500},
+	)
+
+	inv := New(Issuer{}, res, taxed, "USD", time.Now())
+	if inv.TaxesAndFees() != 5100 {
+		t.Errorf("expected taxes and fees 5100, got %d", inv.TaxesAndFees())
+	}
+	if inv.Total != 25100 {
+		t.Errorf("expected total 25100, got %d", inv.Total)
+	}
+}
+
+func TestInvoice_PDF(t *testing.T) {
+	out := New(Issuer{Name: "Fort Smythe"}, res, entries, "USD", time.Now()).PDF()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-")) {
+		t.Error("output is not a PDF")
+	}
+	if !bytes.Contains(out, []byte("(160.00 USD)")) {
+		t.Error("balance due missing from PDF")
+	}
+}
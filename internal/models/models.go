@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/eldicela/bookings/internal/dates"
 )
 
 // User is the user model
@@ -16,12 +18,105 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
+// Property is a building with its own rooms, settings and staff. Slug prefixes its public URLs
+type Property struct {
+	ID           int
+	Name         string
+	Slug         string
+	Email        string
+	Phone        string
+	Address      string
+	Description  string
+	Timezone     string
+	CheckInTime  string
+	CheckOutTime string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Location returns the timezone of the property, UTC when it is not set or unknown
+func (p Property) Location() *time.Location {
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Today returns the current day at the property
+func (p Property) Today() time.Time {
+	return dates.Today(p.Location())
+}
+
+// URL prefixes path with the property slug
+func (p Property) URL(path string) string {
+	if p.Slug == "" {
+		return path
+	}
+	return "/" + p.Slug + path
+}
+
 // Room is the room model
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID              int
+	RoomName        string
+	Price           int
+	MaxOccupancy    int
+	BaseOccupancy   int
+	ExtraGuestPrice int
+	RoomTypeId      int
+	RoomType        RoomType
+	PropertyId      int
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+
+	HousekeepingStatus string
+	StatusNote         string
+	OutOfOrderUntil    time.Time
+	OutOfOrderBlockId  int
+
+	// ICalToken is the secret in the URL of the calendar feed of the room, no feed is served while empty.
+	// It is kept out of JSON, so it never lands in the audit log
+	ICalToken string `json:"-"`
+}
+
+// HousekeepingTask is a room to clean or service on a day. GuestName is who is leaving or staying
+type HousekeepingTask struct {
+	ID            int
+	RoomId        int
+	Room          Room
+	ReservationId int
+	GuestName     string
+	TaskDate      time.Time
+	TaskType      string
+	Status        string
+	CompletedAt   time.Time
+	CompletedBy   int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ReportStay is a reservation as the reports see it. Revenue is the net room revenue posted to its folio,
+// which for a group booking is the folio of the master reservation
+type ReportStay struct {
+	ReservationId int
+	RoomId        int
+	GroupId       int
+	StartDate     time.Time
+	EndDate       time.Time
+	CreatedAt     time.Time
+	CancelledAt   time.Time
+	Revenue       int
+}
+
+// RoomType is what guests book. Each physical room belongs to one type
+type RoomType struct {
+	ID          int
+	Name        string
+	Description string
+	PropertyId  int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 // Restriction is the restrictions model
@@ -32,20 +127,133 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
+// Guest is a guest profile shared by all their reservations. Stays and TotalSpend are filled in
+// by the queries that list guests
+type Guest struct {
+	ID         int
+	FirstName  string
+	LastName   string
+	Email      string
+	Phone      string
+	Stays      int
+	TotalSpend int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
 // Reservation is the reservation model
 type Reservation struct {
+	ID          int
+	FirstName   string
+	LastName    string
+	Email       string
+	Phone       string
+	StartDate   time.Time
+	EndDate     time.Time
+	RoomId      int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Processed   int
+	BalanceDue  int
+	ManageToken string `json:"-"`
+	PromoCodeId int
+	Discount    int
+	Adults      int
+	Children    int
+	RoomTypeId  int
+	GuestId     int
+	GroupId     int
+	Version     int
+
+	CheckedInAt  time.Time
+	CheckedOutAt time.Time
+	CancelledAt  time.Time
+
+	// Channel and ChannelRef name the channel a reservation was booked on and its id there, both are
+	// empty for reservations made here
+	Channel    string
+	ChannelRef string
+}
+
+// Guests returns the size of the party staying
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+// Stay returns the nights the reservation covers
+func (r Reservation) Stay() dates.Range {
+	return dates.Range{Start: r.StartDate, End: r.EndDate}
+}
+
+// ReservationGroup ties together the reservations of a booking covering several rooms. Charges for
+// every room go on the folio of the master reservation, whose room gives the group its property
+type ReservationGroup struct {
+	ID                  int
+	Name                string
+	MasterReservationId int
+	PropertyId          int
+	Master              Reservation
+	Rooms               int
+	Reservations        []Reservation
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// ReservationNote is a note staff left on a reservation. Notes are never edited or deleted
+type ReservationNote struct {
+	ID            int
+	ReservationId int
+	UserId        int
+	Author        string
+	Body          string
+	CreatedAt     time.Time
+}
+
+// ReservationEvent is something that happened to a reservation, recorded for its timeline. UserId is 0
+// for events caused by guests or by the system
+type ReservationEvent struct {
+	ID            int
+	ReservationId int
+	UserId        int
+	Author        string
+	EventType     string
+	Description   string
+	CreatedAt     time.Time
+}
+
+// AuditEntry records a change made from the admin pages. Before and After hold the entity as JSON,
+// empty for the before of a create and the after of a delete
+type AuditEntry struct {
 	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomId    int
+	UserId    int
+	User      string
+	Action    string
+	Entity    string
+	EntityId  int
+	Before    string
+	After     string
+	IP        string
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
-	Processed int
+}
+
+// AuditFilter narrows down the audit log. Zero values match everything, To is exclusive
+type AuditFilter struct {
+	UserId   int
+	Action   string
+	Entity   string
+	EntityId int
+	From     time.Time
+	To       time.Time
+}
+
+// ReservationFilter narrows down the reservation lists. Zero values match everything. Arrivals are
+// matched from ArrivalFrom to ArrivalTo, both included, and Search matches the guest name or email
+type ReservationFilter struct {
+	NewOnly     bool
+	ArrivalFrom time.Time
+	ArrivalTo   time.Time
+	Search      string
 }
 
 // RoomRestriction is the roomRestriction model
@@ -61,13 +269,140 @@ type RoomRestriction struct {
 	Room          Room
 	Reservation   Reservation
 	Restriction   Restriction
+
+	// ICalSourceId and ExternalUID identify the event of an outside calendar a block was synced from
+	ICalSourceId int
+	ExternalUID  string
+}
+
+// ICalSource is an outside calendar, such as the feed of another platform a room is listed on, whose
+// events block the room. Sources without a URL are synced from uploaded files only
+type ICalSource struct {
+	ID           int
+	RoomId       int
+	Room         Room
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastStatus   string
+	LastMessage  string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// ICalSyncLog is the outcome of one sync of an outside calendar
+type ICalSyncLog struct {
+	ID           int
+	ICalSourceId int
+	SourceName   string
+	RoomName     string
+	Status       string
+	Added        int
+	Updated      int
+	Removed      int
+	Message      string
+	CreatedAt    time.Time
+}
+
+// Payment is the payment model, amounts are in cents
+type Payment struct {
+	ID            int
+	ReservationId int
+	Provider      string
+	TransactionId string
+	Amount        int
+	Currency      string
+	Status        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// FolioEntry is a line on a reservation folio, amounts are in cents
+type FolioEntry struct {
+	ID            int
+	ReservationId int
+	EntryType     string
+	Category      string
+	Description   string
+	Amount        int
+	VoidsId       int
+	TaxFeeRuleId  int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// TaxFeeRule is a tax or fee added to every quote. Amount is in hundredths of a percent
+// for percent rules and in cents otherwise
+type TaxFeeRule struct {
+	ID         int
+	Name       string
+	Category   string
+	Basis      string
+	Amount     int
+	Active     int
+	PropertyId int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// TaxFeeTotal is what a tax or fee rule collected over a period
+type TaxFeeTotal struct {
+	RuleId   int
+	Name     string
+	Category string
+	Entries  int
+	Amount   int
+}
+
+// PromoCode is the promo code model. Zero dates leave a window open and no RoomIds means all rooms
+type PromoCode struct {
+	ID           int
+	Code         string
+	Description  string
+	DiscountType string
+	Amount       int
+	ValidFrom    time.Time
+	ValidTo      time.Time
+	StayFrom     time.Time
+	StayTo       time.Time
+	MaxUses      int
+	TimesUsed    int
+	Active       int
+	RoomIds      []int
+	PropertyId   int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// APIKey is a key scripts and partner sites call the JSON API with. Only a hash of the key is kept,
+// Prefix is its first characters so staff can tell keys apart. RateLimit is in requests per minute.
+// Neither the hash nor the manage token of a reservation is ever written out as JSON
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	KeyHash    string `json:"-"`
+	Scopes     []string
+	RateLimit  int
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 // MailData holds an email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Template    string
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file attached to an email
+type MailAttachment struct {
+	Name     string
+	MimeType string
+	Data     []byte
 }
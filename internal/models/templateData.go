@@ -14,4 +14,6 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	Property        Property
+	InProduction    bool
 }
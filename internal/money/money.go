@@ -0,0 +1,14 @@
+// Package money formats amounts, which are kept in cents everywhere
+package money
+
+import "fmt"
+
+// Format formats an amount in cents as 1234.56
+func Format(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,21 @@
+package money
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{12550, "125.50"},
+		{-1999, "-19.99"},
+	}
+
+	for _, e := range tests {
+		if m := Format(e.cents); m != e.expected {
+			t.Errorf("Format(%d): expected %s, got %s", e.cents, e.expected, m)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// DeclineToken is the card token the fake gateway always declines
+const DeclineToken = "tok_decline"
+
+// FakeGateway is an in-memory gateway for local development and tests
+type FakeGateway struct {
+	secret       []byte
+	mu           sync.Mutex
+	seq          int
+	transactions map[string]*Result
+	refunded     map[string]int
+}
+
+// NewFakeGateway creates a fake gateway signing webhooks with secret
+func NewFakeGateway(secret string) *FakeGateway {
+	return &FakeGateway{
+		secret:       []byte(secret),
+		transactions: make(map[string]*Result),
+		refunded:     make(map[string]int),
+	}
+}
+
+// Name returns the provider name stored on payment records
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+// Authorize reserves the amount unless the token is empty or DeclineToken
+func (g *FakeGateway) Authorize(c Charge) (Result, error) {
+	if c.Token == "" || c.Token == DeclineToken || c.Amount < 0 {
+		return Result{Status: StatusFailed}, ErrDeclined
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.seq++
+	res := &Result{
+		TransactionID: fmt.Sprintf("fake_%06d", g.seq),
+		Amount:        c.Amount,
+		Status:        StatusAuthorized,
+	}
+	g.transactions[res.TransactionID] = res
+
+	return *res, nil
+}
+
+// Capture settles an authorized transaction
+func (g *FakeGateway) Capture(transactionID string, amount int) (Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, ok := g.transactions[transactionID]
+	if !ok {
+		return Result{}, ErrUnknownTransaction
+	}
+	if res.Status != StatusAuthorized || amount > res.Amount {
+		return *res, fmt.Errorf("cannot capture %d on %s transaction %s", amount, res.Status, transactionID)
+	}
+
+	res.Amount = amount
+	res.Status = StatusCaptured
+
+	return *res, nil
+}
+
+// Refund returns money on a captured transaction or releases an authorization. Refunds may be partial,
+// the transaction is only refunded once all of it has been returned
+func (g *FakeGateway) Refund(transactionID string, amount int) (Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, ok := g.transactions[transactionID]
+	if !ok {
+		return Result{}, ErrUnknownTransaction
+	}
+	if res.Status == StatusRefunded || amount <= 0 || amount > res.Amount-g.refunded[transactionID] {
+		return *res, fmt.Errorf("cannot refund %d on %s transaction %s", amount, res.Status, transactionID)
+	}
+
+	g.refunded[transactionID] += amount
+	if g.refunded[transactionID] == res.Amount {
+		res.Status = StatusRefunded
+	}
+
+	return Result{TransactionID: transactionID, Amount: amount, Status: res.Status}, nil
+}
+
+// Sign returns the signature the fake gateway sends with a webhook payload
+func (g *FakeGateway) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the payload signature and decodes the event. Without a secret anyone could sign
+// a payload, so every webhook is refused
+func (g *FakeGateway) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	var e Event
+
+	if len(g.secret) == 0 {
+		return e, ErrInvalidSignature
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return e, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return e, ErrInvalidSignature
+	}
+
+	err = json.Unmarshal(payload, &e)
+	if err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
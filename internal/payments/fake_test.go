@@ -0,0 +1,124 @@
+package payments
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFakeGateway_AuthorizeCapture(t *testing.T) {
+	g := NewFakeGateway("secret")
+
+	auth, err := g.Authorize(Charge{Amount: 5000, Currency: "USD", Token: "tok_visa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Status != StatusAuthorized {
+		t.Errorf("expected status %s, got %s", StatusAuthorized, auth.Status)
+	}
+
+	res, err := g.Capture(auth.TransactionID, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusCaptured {
+		t.Errorf("expected status %s, got %s", StatusCaptured, res.Status)
+	}
+
+	_, err = g.Capture(auth.TransactionID, 5000)
+	if err == nil {
+		t.Error("captured the same transaction twice")
+	}
+
+	_, err = g.Capture("fake_999999", 100)
+	if !errors.Is(err, ErrUnknownTransaction) {
+		t.Errorf("expected ErrUnknownTransaction, got %v", err)
+	}
+}
+
+func TestFakeGateway_Decline(t *testing.T) {
+	g := NewFakeGateway("secret")
+
+	_, err := g.Authorize(Charge{Amount: 5000, Token: DeclineToken})
+	if !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected ErrDeclined, got %v", err)
+	}
+
+	_, err = g.Authorize(Charge{Amount: 5000})
+	if !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected ErrDeclined for empty token, got %v", err)
+	}
+}
+
+func TestFakeGateway_Refund(t *testing.T) {
+	g := NewFakeGateway("secret")
+
+	auth, _ := g.Authorize(Charge{Amount: 5000, Token: "tok_visa"})
+	_, _ = g.Capture(auth.TransactionID, 5000)
+
+	_, err := g.Refund(auth.TransactionID, 6000)
+	if err == nil {
+		t.Error("refunded more than captured")
+	}
+
+	res, err := g.Refund(auth.TransactionID, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusCaptured || res.Amount != 2000 {
+		t.Errorf("expected a partial refund to leave the transaction %s, got %+v", StatusCaptured, res)
+	}
+
+	_, err = g.Refund(auth.TransactionID, 4000)
+	if err == nil {
+		t.Error("refunded more than was left")
+	}
+
+	res, err = g.Refund(auth.TransactionID, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusRefunded {
+		t.Errorf("expected status %s, got %s", StatusRefunded, res.Status)
+	}
+
+	_, err = g.Refund(auth.TransactionID, 1)
+	if err == nil {
+		t.Error("refunded a fully refunded transaction")
+	}
+}
+
+func TestFakeGateway_VerifyWebhook(t *testing.T) {
+	g := NewFakeGateway("secret")
+	payload := []byte(`{"type":"payment.refunded","transaction_id":"fake_000001","amount":100,"status":"refunded"}`)
+
+	e, err := g.VerifyWebhook(payload, g.Sign(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.TransactionID != "fake_000001" || e.Status != StatusRefunded {
+		t.Errorf("decoded wrong event: %+v", e)
+	}
+
+	other := NewFakeGateway("other")
+	_, err = g.VerifyWebhook(payload, other.Sign(payload))
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	_, err = g.VerifyWebhook(payload, "not-hex")
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for malformed signature, got %v", err)
+	}
+
+	open := NewFakeGateway("")
+	_, err = open.VerifyWebhook(payload, open.Sign(payload))
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature without a secret, got %v", err)
+	}
+}
+
+func TestValidStatus(t *testing.T) {
+	if !ValidStatus(StatusCaptured) || ValidStatus("paid") || ValidStatus("") {
+		t.Error("wrong status validation")
+	}
+}
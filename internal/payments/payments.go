@@ -0,0 +1,65 @@
+package payments
+
+import "errors"
+
+// Payment statuses as stored on payment records
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusFailed     = "failed"
+)
+
+// Statuses lists the payment statuses
+var Statuses = []string{StatusAuthorized, StatusCaptured, StatusRefunded, StatusFailed}
+
+// ValidStatus reports whether status is a payment status
+func ValidStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// ErrDeclined is returned when the gateway refuses an authorization
+	ErrDeclined = errors.New("payment declined")
+	// ErrUnknownTransaction is returned for a transaction id the gateway does not know
+	ErrUnknownTransaction = errors.New("unknown transaction")
+	// ErrInvalidSignature is returned when a webhook payload fails verification
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Charge describes an amount to authorize against a payment method
+type Charge struct {
+	Amount      int
+	Currency    string
+	Token       string
+	Description string
+}
+
+// Result is the outcome of a gateway operation
+type Result struct {
+	TransactionID string
+	Amount        int
+	Status        string
+}
+
+// Event is a verified notification sent by the gateway
+type Event struct {
+	Type          string `json:"type"`
+	TransactionID string `json:"transaction_id"`
+	Amount        int    `json:"amount"`
+	Status        string `json:"status"`
+}
+
+// Gateway is implemented by every payment provider. Amounts are in minor units (cents)
+type Gateway interface {
+	Name() string
+	Authorize(c Charge) (Result, error)
+	Capture(transactionID string, amount int) (Result, error)
+	Refund(transactionID string, amount int) (Result, error)
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
@@ -0,0 +1,144 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+// Document is a minimal PDF 1.4 document using the standard Helvetica fonts
+type Document struct {
+	pages []*Page
+}
+
+// Page is a single page. Coordinates are in points from the bottom left corner
+type Page struct {
+	content bytes.Buffer
+}
+
+// New creates an empty document
+func New() *Document {
+	return &Document{}
+}
+
+// AddPage appends a new A4 page to the document
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws s with its baseline starting at x, y
+func (p *Page) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// TextRight draws s so that it ends at x
+func (p *Page) TextRight(x, y, size float64, bold bool, s string) {
+	p.Text(x-TextWidth(s, size), y, size, bold, s)
+}
+
+// Line draws a thin line from x1, y1 to x2, y2
+func (p *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// TextWidth approximates the width of s in Helvetica. Digits and punctuation are exact
+func TextWidth(s string, size float64) float64 {
+	var units int
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			units += 556
+		case r == '.' || r == ',' || r == ' ' || r == '/' || r == ':':
+			units += 278
+		case r == '-':
+			units += 333
+		case r >= 'A' && r <= 'Z':
+			units += 667
+		case r == 'i' || r == 'l' || r == 'j':
+			units += 222
+		default:
+			units += 556
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// escape encodes s as WinAnsi and escapes the characters special to PDF strings
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case r == '€':
+			b.WriteString("\\200")
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// WriteTo writes the document to w
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// objects 1 to 4 are fixed, each page then takes two objects: the page and its content stream
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+i*2))
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, 6+i*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.WriteTo(w)
+}
+
+// Bytes returns the encoded document
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	_, _ = d.WriteTo(&buf)
+	return buf.Bytes()
+}
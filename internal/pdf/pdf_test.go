@@ -0,0 +1,68 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument_Bytes(t *testing.T) {
+	d := New()
+	p := d.AddPage()
+	p.Text(50, 800, 12, true, "Invoice (copy)")
+	p.Line(50, 790, 545, 790)
+	d.AddPage().TextRight(545, 800, 10, false, "125.50")
+
+	out := d.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) {
+		t.Error("missing PDF header")
+	}
+	if !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Error("missing EOF marker")
+	}
+	if !bytes.Contains(out, []byte(`(Invoice \(copy\)) Tj`)) {
+		t.Error("text was not escaped")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("wrong page count")
+	}
+
+	// every xref entry must point at the start of its object
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("expected 8 objects in xref, got %d", len(entries))
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		expected := fmt.Sprintf("%d 0 obj", i+1)
+		if !bytes.HasPrefix(out[offset:], []byte(expected)) {
+			t.Errorf("xref entry %d does not point at %q", i+1, expected)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	var tests = []struct {
+		in       string
+		expected string
+	}{
+		{"plain", "plain"},
+		{`a\b`, `a\\b`},
+		{"Café", `Caf\351`},
+		{"日本", "??"},
+	}
+
+	for _, e := range tests {
+		if s := escape(e.in); s != e.expected {
+			t.Errorf("escape(%q): expected %q, got %q", e.in, e.expected, s)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/folio"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Tax and fee bases
+const (
+	BasisPercent  = "percent"
+	BasisPerNight = "per_night"
+	BasisPerStay  = "per_stay"
+	BasisPerGuest = "per_guest"
+)
+
+// Bases lists the bases a tax or fee rule can be charged on
+var Bases = []string{BasisPercent, BasisPerNight, BasisPerStay, BasisPerGuest}
+
+// Line is one itemised amount of a quote, in cents. Category is the folio category it is posted under
+// and RuleId the tax or fee rule that produced it, if any
+type Line struct {
+	Description string
+	Category    string
+	Amount      int
+	RuleId      int
+}
+
+// Quote is the price breakdown for a stay
+type Quote struct {
+	Nights      int
+	NightlyRate int
+	Lines       []Line
+	Total       int
+}
+
+// Nights returns the number of nights between two dates
+func Nights(start, end time.Time) int {
+	return dates.Range{Start: start, End: end}.Nights()
+}
+
+// NewQuote prices a stay in room from start to end
+func NewQuote(room models.Room, start, end time.Time) Quote {
+	q := Quote{
+		Nights:      Nights(start, end),
+		NightlyRate: room.Price,
+	}
+
+	q.add(Line{
+		Description: fmt.Sprintf("%s, %d night(s)", room.RoomName, q.Nights),
+		Category:    folio.CategoryRoom,
+		Amount:      q.Nights * room.Price,
+	})
+
+	return q
+}
+
+// ApplyExtraGuests charges the room's extra guest price per night for each guest above its base occupancy
+func (q *Quote) ApplyExtraGuests(room models.Room, guests int) {
+	extra := guests - room.BaseOccupancy
+	if extra <= 0 || room.ExtraGuestPrice <= 0 {
+		return
+	}
+	q.add(Line{
+		Description: fmt.Sprintf("%d extra guest(s), %d night(s)", extra, q.Nights),
+		Category:    folio.CategoryRoom,
+		Amount:      extra * q.Nights * room.ExtraGuestPrice,
+	})
+}
+
+// ApplyDiscount takes amount off the quote as a discount line
+func (q *Quote) ApplyDiscount(description string, amount int) {
+	if amount <= 0 {
+		return
+	}
+	q.add(Line{Description: description, Category: folio.CategoryDiscount, Amount: -amount})
+}
+
+// ApplyTaxesAndFees adds a line for each rule. Percent rules are charged on the total
+// before any tax or fee, so fees are never taxed
+func (q *Quote) ApplyTaxesAndFees(rules []models.TaxFeeRule, guests int) {
+	if guests < 1 {
+		guests = 1
+	}
+
+	subtotal := q.Total
+	for _, r := range rules {
+		amount := RuleAmount(r, subtotal, q.Nights, guests)
+		if amount == 0 {
+			continue
+		}
+		q.add(Line{
+			Description: ruleDescription(r, q.Nights, guests),
+			Category:    r.Category,
+			Amount:      amount,
+			RuleId:      r.ID,
+		})
+	}
+}
+
+// RuleAmount returns what rule charges on a stay of nights for guests with the given subtotal
+func RuleAmount(rule models.TaxFeeRule, subtotal, nights, guests int) int {
+	switch rule.Basis {
+	case BasisPercent:
+		return (subtotal*rule.Amount + 5000) / 10000
+	case BasisPerNight:
+		return rule.Amount * nights
+	case BasisPerStay:
+		return rule.Amount
+	case BasisPerGuest:
+		return rule.Amount * guests
+	}
+	return 0
+}
+
+func ruleDescription(rule models.TaxFeeRule, nights, guests int) string {
+	switch rule.Basis {
+	case BasisPercent:
+		return fmt.Sprintf("%s (%d.%02d%%)", rule.Name, rule.Amount/100, rule.Amount%100)
+	case BasisPerNight:
+		return fmt.Sprintf("%s, %d night(s)", rule.Name, nights)
+	case BasisPerGuest:
+		return fmt.Sprintf("%s, %d guest(s)", rule.Name, guests)
+	}
+	return rule.Name
+}
+
+// TaxesAndFees returns the total of the tax and fee lines
+func (q Quote) TaxesAndFees() int {
+	var t int
+	for _, l := range q.Lines {
+		if l.Category == folio.CategoryTax || l.Category == folio.CategoryFee {
+			t += l.Amount
+		}
+	}
+	return t
+}
+
+// Discount returns the total of the discount lines as a positive amount
+func (q Quote) Discount() int {
+	var d int
+	for _, l := range q.Lines {
+		if l.Category == folio.CategoryDiscount {
+			d -= l.Amount
+		}
+	}
+	return d
+}
+
+func (q *Quote) add(l Line) {
+	q.Lines = append(q.Lines, l)
+	q.Total += l.Amount
+}
+
+// Labelled returns a copy of the quote with name in front of the lines that do not mention it,
+// so the rooms of a group booking can be told apart
+func (q Quote) Labelled(name string) Quote {
+	lines := make([]Line, len(q.Lines))
+	for i, l := range q.Lines {
+		if !strings.Contains(l.Description, name) {
+			l.Description = fmt.Sprintf("%s: %s", name, l.Description)
+		}
+		lines[i] = l
+	}
+	q.Lines = lines
+	return q
+}
+
+// Combine adds up the quotes of the rooms of one booking
+func Combine(quotes ...Quote) Quote {
+	var c Quote
+	for _, q := range quotes {
+		c.Nights = q.Nights
+		c.NightlyRate += q.NightlyRate
+		c.Lines = append(c.Lines, q.Lines...)
+		c.Total += q.Total
+	}
+	return c
+}
+
+// Deposit returns percent of the quote total, rounded to the nearest cent
+func (q Quote) Deposit(percent int) int {
+	if percent <= 0 {
+		return 0
+	}
+	if percent >= 100 {
+		return q.Total
+	}
+	return (q.Total*percent + 50) / 100
+}
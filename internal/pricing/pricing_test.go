@@ -0,0 +1,161 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/folio"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+var layout = "2006-01-02"
+
+func TestNights(t *testing.T) {
+	start, _ := time.Parse(layout, "2050-01-01")
+	end, _ := time.Parse(layout, "2050-01-04")
+
+	if n := Nights(start, end); n != 3 {
+		t.Errorf("expected 3 nights, got %d", n)
+	}
+
+	if n := Nights(end, start); n != 0 {
+		t.Errorf("expected 0 nights for reversed dates, got %d", n)
+	}
+}
+
+func TestNewQuote(t *testing.T) {
+	start, _ := time.Parse(layout, "2050-01-01")
+	end, _ := time.Parse(layout, "2050-01-03")
+	room := models.Room{ID: 1, RoomName: "General's Quarters", Price: 12550}
+
+	q := NewQuote(room, start, end)
+	if q.Total != 25100 {
+		t.Errorf("expected total 25100, got %d", q.Total)
+	}
+	if len(q.Lines) != 1 {
+		t.Errorf("expected 1 line, got %d", len(q.Lines))
+	}
+}
+
+func TestQuote_ApplyExtraGuests(t *testing.T) {
+	start, _ := time.Parse(layout, "2050-01-01")
+	end, _ := time.Parse(layout, "2050-01-03")
+	room := models.Room{Price: 10000, BaseOccupancy: 2, ExtraGuestPrice: 1500}
+
+	q := NewQuote(room, start, end)
+	q.ApplyExtraGuests(room, 2)
+	if len(q.Lines) != 1 {
+		t.Errorf("expected no extra guest line within base occupancy, got %d lines", len(q.Lines))
+	}
+
+	q.ApplyExtraGuests(room, 4)
+	if q.Total != 26000 {
+		t.Errorf("expected total 26000, got %d", q.Total)
+	}
+}
+
+func TestQuote_ApplyDiscount(t *testing.T) {
+	start, _ := time.Parse(layout, "2050-01-01")
+	end, _ := time.Parse(layout, "2050-01-03")
+
+	q := NewQuote(models.Room{Price: 10000}, start, end)
+	q.ApplyDiscount("Promo code", 2500)
+	q.ApplyDiscount("Nothing", 0)
+
+	if q.Total != 17500 {
+		t.Errorf("expected total 17500, got %d", q.Total)
+	}
+	if len(q.Lines) != 2 {
+		t.Errorf("expected 2 lines, got %d", len(q.Lines))
+	}
+	if q.Discount() != 2500 {
+		t.Errorf("expected discount 2500, got %d", q.Discount())
+	}
+}
+
+func TestQuote_ApplyTaxesAndFees(t *testing.T) {
+	start, _ := time.Parse(layout, "2050-01-01")
+	end, _ := time.Parse(layout, "2050-01-04")
+
+	q := NewQuote(models.Room{Price: 10000}, start, end)
+	q.ApplyDiscount("Promo code", 5000)
+	q.ApplyTaxesAndFees([]models.TaxFeeRule{
+		{ID: 1, Name: "Sales tax", Category: folio.CategoryTax, Basis: BasisPercent, Amount: 725},
+		{ID: 2, Name: "Occupancy tax", Category: folio.CategoryTax, Basis: BasisPerNight, Amount: 200},
+		{ID: 3, Name: "Cleaning fee", Category: folio.CategoryFee, Basis: BasisPerStay, Amount: 4500},
+		{ID: 4, Name: "Resort fee", Category: folio.CategoryFee, Basis: BasisPerGuest, Amount: 1000},
+		{ID: 5, Name: "Nothing", Category: folio.CategoryFee, Basis: BasisPerStay, Amount: 0},
+	}, 0)
+
+	// 7.25% of 250.00 rounds to 18.13, fees are not taxed
+	var expected = []int{30000, -5000, 1813, 600, 4500, 1000}
+	if len(q.Lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(q.Lines))
+	}
+	for i, e := range expected {
+		if q.Lines[i].Amount != e {
+			t.Errorf("line %d: expected %d, got %d", i, e, q.Lines[i].Amount)
+		}
+	}
+
+	if q.Lines[2].RuleId != 1 || q.Lines[2].Category != folio.CategoryTax {
+		t.Errorf("tax line not linked to its rule: %+v", q.Lines[2])
+	}
+	if q.TaxesAndFees() != 7913 {
+		t.Errorf("expected taxes and fees 7913, got %d", q.TaxesAndFees())
+	}
+	if q.Total != 32913 {
+		t.Errorf("expected total 32913, got %d", q.Total)
+	}
+}
+
+func TestQuote_Deposit(t *testing.T) {
+	q := Quote{Total: 25100}
+
+	var tests = []struct {
+		percent  int
+		expected int
+	}{
+		{0, 0},
+		{20, 5020},
+		{33, 8283},
+		{100, 25100},
+		{150, 25100},
+	}
+
+	for _, e := range tests {
+		if d := q.Deposit(e.percent); d != e.expected {
+			t.Errorf("deposit of %d%%: expected %d, got %d", e.percent, e.expected, d)
+		}
+	}
+}
+
+func TestCombine(t *testing.T) {
+	start, _ := time.Parse(layout, "2050-01-01")
+	end, _ := time.Parse(layout, "2050-01-03")
+
+	generals := NewQuote(models.Room{RoomName: "General's Quarters", Price: 10000}, start, end)
+	generals.ApplyDiscount("Promo code SPRING", 2000)
+	majors := NewQuote(models.Room{RoomName: "Major's Suite", Price: 15000}, start, end)
+
+	q := Combine(generals.Labelled("General's Quarters"), majors.Labelled("Major's Suite"))
+
+	if q.Total != 48000 {
+		t.Errorf("expected total 48000, got %d", q.Total)
+	}
+	if q.Nights != 2 || q.NightlyRate != 25000 {
+		t.Errorf("wrong nights or rate: %d, %d", q.Nights, q.NightlyRate)
+	}
+	if len(q.Lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(q.Lines))
+	}
+	if q.Lines[0].Description != "General's Quarters, 2 night(s)" {
+		t.Errorf("room line should not be labelled twice: %s", q.Lines[0].Description)
+	}
+	if q.Lines[1].Description != "General's Quarters: Promo code SPRING" {
+		t.Errorf("discount line not labelled: %s", q.Lines[1].Description)
+	}
+	if generals.Lines[1].Description != "Promo code SPRING" {
+		t.Error("Labelled changed the original quote")
+	}
+}
@@ -0,0 +1,114 @@
+package promo
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Discount types
+const (
+	TypePercent = "percent"
+	TypeFixed   = "fixed"
+)
+
+var (
+	ErrInactive     = errors.New("this promo code is no longer available")
+	ErrNotYetValid  = errors.New("this promo code is not valid yet")
+	ErrExpired      = errors.New("this promo code has expired")
+	ErrStayDates    = errors.New("this promo code is not valid for your dates")
+	ErrRoom         = errors.New("this promo code is not valid for this room")
+	ErrExhausted    = errors.New("this promo code has been fully redeemed")
+	ErrUnknownCode  = errors.New("this promo code does not exist")
+	ErrInvalidValue = errors.New("invalid discount")
+)
+
+// Message returns the text shown to a guest whose promo code was refused
+func Message(err error) string {
+	for _, e := range []error{ErrInactive, ErrNotYetValid, ErrExpired, ErrStayDates, ErrRoom, ErrExhausted, ErrUnknownCode} {
+		if errors.Is(err, e) {
+			return e.Error()
+		}
+	}
+	return "this promo code cannot be used"
+}
+
+// Normalize returns the form codes are stored and looked up in
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks that p can be used on a booking of roomId from start to end made on day today.
+// Zero dates leave a window open on that side
+func Validate(p models.PromoCode, roomId int, start, end, today time.Time) error {
+	if p.Active == 0 {
+		return ErrInactive
+	}
+	if !p.ValidFrom.IsZero() && today.Before(p.ValidFrom) {
+		return ErrNotYetValid
+	}
+	if !p.ValidTo.IsZero() && today.After(p.ValidTo) {
+		return ErrExpired
+	}
+	if !p.StayFrom.IsZero() && start.Before(p.StayFrom) {
+		return ErrStayDates
+	}
+	if !p.StayTo.IsZero() && end.After(p.StayTo) {
+		return ErrStayDates
+	}
+	if len(p.RoomIds) > 0 {
+		found := false
+		for _, id := range p.RoomIds {
+			if id == roomId {
+				found = true
+			}
+		}
+		if !found {
+			return ErrRoom
+		}
+	}
+	if p.MaxUses > 0 && p.TimesUsed >= p.MaxUses {
+		return ErrExhausted
+	}
+
+	return nil
+}
+
+// Discount returns the amount p takes off subtotal, never more than subtotal
+func Discount(p models.PromoCode, subtotal int) int {
+	var d int
+
+	switch p.DiscountType {
+	case TypePercent:
+		d = (subtotal*p.Amount + 50) / 100
+	case TypeFixed:
+		d = p.Amount
+	}
+
+	if d > subtotal {
+		return subtotal
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// CheckValue validates the discount settings entered for a new code
+func CheckValue(discountType string, amount int) error {
+	switch discountType {
+	case TypePercent:
+		if amount <= 0 || amount > 100 {
+			return ErrInvalidValue
+		}
+	case TypeFixed:
+		if amount <= 0 {
+			return ErrInvalidValue
+		}
+	default:
+		return ErrInvalidValue
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package promo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestValidate(t *testing.T) {
+	p := models.PromoCode{
+		Code:      "SUMMER",
+		Active:    1,
+		ValidFrom: datestest.Day(t, "2050-01-01"),
+		ValidTo:   datestest.Day(t, "2050-03-31"),
+		StayFrom:  datestest.Day(t, "2050-06-01"),
+		StayTo:    datestest.Day(t, "2050-08-31"),
+		RoomIds:   []int{1},
+		MaxUses:   10,
+		TimesUsed: 3,
+	}
+
+	var tests = []struct {
+		name     string
+		roomId   int
+		start    string
+		end      string
+		today    string
+		expected error
+	}{
+		{"valid", 1, "2050-07-01", "2050-07-05", "2050-02-01", nil},
+		{"last stay day", 1, "2050-08-29", "2050-08-31", "2050-02-01", nil},
+		{"too early", 1, "2050-07-01", "2050-07-05", "2049-12-31", ErrNotYetValid},
+		{"expired", 1, "2050-07-01", "2050-07-05", "2050-04-01", ErrExpired},
+		{"stay before window", 1, "2050-05-30", "2050-06-02", "2050-02-01", ErrStayDates},
+		{"stay after window", 1, "2050-08-30", "2050-09-02", "2050-02-01", ErrStayDates},
+		{"wrong room", 2, "2050-07-01", "2050-07-05", "2050-02-01", ErrRoom},
+	}
+
+	for _, e := range tests {
+		err := Validate(p, e.roomId, datestest.Day(t, e.start), datestest.Day(t, e.end), datestest.Day(t, e.today))
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+
+	p.TimesUsed = 10
+	if err := Validate(p, 1, datestest.Day(t, "2050-07-01"), datestest.Day(t, "2050-07-05"), datestest.Day(t, "2050-02-01")); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected ErrExhausted, got %v", err)
+	}
+
+	open := models.PromoCode{Active: 1}
+	if err := Validate(open, 5, datestest.Day(t, "2050-07-01"), datestest.Day(t, "2050-07-05"), datestest.Day(t, "2050-02-01")); err != nil {
+		t.Errorf("code without restrictions should be valid, got %v", err)
+	}
+
+	open.Active = 0
+	if err := Validate(open, 5, datestest.Day(t, "2050-07-01"), datestest.Day(t, "2050-07-05"), datestest.Day(t, "2050-02-01")); !errors.Is(err, ErrInactive) {
+		t.Errorf("expected ErrInactive, got %v", err)
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	var tests = []struct {
+		discountType string
+		amount       int
+		subtotal     int
+		expected     int
+	}{
+		{TypePercent, 10, 25100, 2510},
+		{TypePercent, 15, 999, 150},
+		{TypeFixed, 5000, 25100, 5000},
+		{TypeFixed, 50000, 25100, 25100},
+		{"unknown", 10, 25100, 0},
+	}
+
+	for _, e := range tests {
+		d := Discount(models.PromoCode{DiscountType: e.discountType, Amount: e.amount}, e.subtotal)
+		if d != e.expected {
+			t.Errorf("%s %d on %d: expected %d, got %d", e.discountType, e.amount, e.subtotal, e.expected, d)
+		}
+	}
+}
+
+func TestCheckValue(t *testing.T) {
+	if CheckValue(TypePercent, 101) == nil {
+		t.Error("accepted a discount over 100%")
+	}
+	if CheckValue(TypeFixed, 0) == nil {
+		t.Error("accepted a zero fixed discount")
+	}
+	if CheckValue(TypePercent, 25) != nil {
+		t.Error("rejected a valid percentage")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if Normalize("  summer10 ") != "SUMMER10" {
+		t.Error("code was not normalized")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	if Message(ErrExpired) != ErrExpired.Error() {
+		t.Error("wrong message for a promo error")
+	}
+	if Message(errors.New("connection refused")) == "connection refused" {
+		t.Error("leaked an internal error to the guest")
+	}
+}
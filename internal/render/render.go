@@ -10,16 +10,20 @@ import (
 	"time"
 
 	"github.com/eldicela/bookings/internal/config"
+	"github.com/eldicela/bookings/internal/helpers"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/money"
 	"github.com/justinas/nosurf"
 	// "github.com/eldicela/mygoprogram/pkg/handlers"
 )
 
-var functions = template.FuncMap{
+// Functions are the functions templates can call
+var Functions = template.FuncMap{
 	"humanDate":  HumanDate,
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"money":      money.Format,
 }
 var app *config.AppConfig
 var pathToTemplates = "./templates"
@@ -64,6 +68,8 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 		td.IsAuthenticated = 1
 	}
 
+	td.Property = helpers.PropertyFromContext(r.Context())
+	td.InProduction = app.InProduction
 	td.CSRFToken = nosurf.Token(r)
 	return td
 }
@@ -111,7 +117,7 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 
 	for _, page := range pages {
 		name := filepath.Base(page)
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := template.New(name).Funcs(Functions).ParseFiles(page)
 		if err != nil {
 			return myCache, err
 		}
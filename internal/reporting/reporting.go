@@ -0,0 +1,325 @@
+package reporting
+
+import (
+	"time"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Intervals the period figures can be broken down by
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// Intervals lists the intervals a report can be broken down by
+var Intervals = []string{IntervalDay, IntervalWeek, IntervalMonth}
+
+// ValidInterval reports whether interval is one of Intervals
+func ValidInterval(interval string) bool {
+	for _, i := range Intervals {
+		if i == interval {
+			return true
+		}
+	}
+	return false
+}
+
+// Figures are the occupancy and revenue of some room nights. Money is in cents and Occupancy is a percentage
+type Figures struct {
+	Available int     `json:"available"`
+	Sold      int     `json:"sold"`
+	Revenue   int     `json:"revenue"`
+	Occupancy float64 `json:"occupancy"`
+	ADR       int     `json:"adr"`
+	RevPAR    int     `json:"revpar"`
+}
+
+func (f *Figures) add(g Figures) {
+	f.Available += g.Available
+	f.Sold += g.Sold
+	f.Revenue += g.Revenue
+}
+
+// rates works out the ratios once the counts are in
+func (f *Figures) rates() {
+	if f.Available > 0 {
+		f.Occupancy = float64(f.Sold) * 100 / float64(f.Available)
+		f.RevPAR = f.Revenue / f.Available
+	}
+	if f.Sold > 0 {
+		f.ADR = f.Revenue / f.Sold
+	}
+}
+
+// RoomFigures are the figures of one room
+type RoomFigures struct {
+	RoomId   int    `json:"room_id"`
+	RoomName string `json:"room_name"`
+	Figures
+}
+
+// PeriodFigures are the figures of one interval of the report, from its first to its last night
+type PeriodFigures struct {
+	Label string `json:"label"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Figures
+}
+
+// Bucket is one bar of a distribution
+type Bucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// Report holds the analytics of a property over a period. From and To are the first and last nights
+type Report struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Interval string          `json:"interval"`
+	Totals   Figures         `json:"totals"`
+	Rooms    []RoomFigures   `json:"rooms"`
+	Periods  []PeriodFigures `json:"periods"`
+
+	// Bookings, lead time and length of stay are of the reservations arriving in the period
+	Bookings            int      `json:"bookings"`
+	Cancellations       int      `json:"cancellations"`
+	CancellationRate    float64  `json:"cancellation_rate"`
+	LeadTime            []Bucket `json:"lead_time"`
+	AverageLeadTime     float64  `json:"average_lead_time"`
+	LengthOfStay        []Bucket `json:"length_of_stay"`
+	AverageLengthOfStay float64  `json:"average_length_of_stay"`
+}
+
+// leadTimes are the upper bounds in days of the lead time buckets, the last one is open ended
+var leadTimes = []struct {
+	label string
+	max   int
+}{
+	{"Same day", 0},
+	{"1-7 days", 7},
+	{"8-30 days", 30},
+	{"31-90 days", 90},
+	{"91+ days", -1},
+}
+
+// stayLabels are the length of stay buckets, the last one is open ended
+var stayLabels = []string{"1 night", "2 nights", "3 nights", "4 nights", "5 nights", "6 nights", "7+ nights"}
+
+// Build works out the report of period for rooms. A room night is available unless a block or an out of
+// order period covers it and it was not sold anyway. Cancelled stays count towards the cancellation rate
+// only
+func Build(period dates.Range, interval string, rooms []models.Room, stays []models.ReportStay, blocks []models.RoomRestriction) Report {
+	report := Report{
+		From:     period.Start.Format(dates.Layout),
+		To:       period.End.AddDate(0, 0, -1).Format(dates.Layout),
+		Interval: interval,
+	}
+
+	// sold and revenue by room and night
+	type key struct {
+		room int
+		day  time.Time
+	}
+	sold := make(map[key]int)
+
+	revenue := allocate(stays)
+	for _, stay := range stays {
+		if !stay.CancelledAt.IsZero() {
+			continue
+		}
+		nights := dates.Range{Start: stay.StartDate, End: stay.EndDate}.Days()
+		for i, d := range nights {
+			if period.Contains(d) {
+				sold[key{stay.RoomId, d}] += share(revenue[stay.ReservationId], len(nights), i)
+			}
+		}
+	}
+
+	blocked := make(map[key]bool)
+	for _, b := range blocks {
+		for _, d := range (dates.Range{Start: b.StartDate, End: b.EndDate}).Days() {
+			blocked[key{b.RoomId, d}] = true
+		}
+	}
+
+	periods := split(period, interval)
+	byPeriod := make([]Figures, len(periods))
+
+	for _, room := range rooms {
+		figures := RoomFigures{RoomId: room.ID, RoomName: room.RoomName}
+
+		for p, span := range periods {
+			for _, d := range span.Days() {
+				var night Figures
+				amount, ok := sold[key{room.ID, d}]
+				switch {
+				case ok:
+					night = Figures{Available: 1, Sold: 1, Revenue: amount}
+				case !blocked[key{room.ID, d}]:
+					night = Figures{Available: 1}
+				}
+				figures.add(night)
+				byPeriod[p].add(night)
+			}
+		}
+
+		figures.rates()
+		report.Totals.add(figures.Figures)
+		report.Rooms = append(report.Rooms, figures)
+	}
+	report.Totals.rates()
+
+	for p, span := range periods {
+		byPeriod[p].rates()
+		report.Periods = append(report.Periods, PeriodFigures{
+			Label:   label(span.Start, interval),
+			Start:   span.Start.Format(dates.Layout),
+			End:     span.End.AddDate(0, 0, -1).Format(dates.Layout),
+			Figures: byPeriod[p],
+		})
+	}
+
+	report.arrivals(period, stays)
+
+	return report
+}
+
+// arrivals works out the booking figures of the stays arriving in period
+func (report *Report) arrivals(period dates.Range, stays []models.ReportStay) {
+	report.LeadTime = make([]Bucket, len(leadTimes))
+	for i, b := range leadTimes {
+		report.LeadTime[i].Label = b.label
+	}
+	report.LengthOfStay = make([]Bucket, len(stayLabels))
+	for i, l := range stayLabels {
+		report.LengthOfStay[i].Label = l
+	}
+
+	var kept, leadDays, nights int
+	for _, stay := range stays {
+		if !period.Contains(stay.StartDate) {
+			continue
+		}
+		report.Bookings++
+		if !stay.CancelledAt.IsZero() {
+			report.Cancellations++
+			continue
+		}
+		kept++
+
+		lead := int(stay.StartDate.Sub(dates.Day(stay.CreatedAt)).Hours() / 24)
+		if lead < 0 {
+			lead = 0
+		}
+		leadDays += lead
+		for i, b := range leadTimes {
+			if b.max < 0 || lead <= b.max {
+				report.LeadTime[i].Count++
+				break
+			}
+		}
+
+		n := dates.Range{Start: stay.StartDate, End: stay.EndDate}.Nights()
+		nights += n
+		i := n - 1
+		if i >= len(stayLabels) {
+			i = len(stayLabels) - 1
+		}
+		if i >= 0 {
+			report.LengthOfStay[i].Count++
+		}
+	}
+
+	if report.Bookings > 0 {
+		report.CancellationRate = float64(report.Cancellations) * 100 / float64(report.Bookings)
+	}
+	if kept > 0 {
+		report.AverageLeadTime = float64(leadDays) / float64(kept)
+		report.AverageLengthOfStay = float64(nights) / float64(kept)
+	}
+}
+
+// allocate returns the room revenue of each stay. The revenue of a group booking is posted on the master
+// folio, so the group total is shared among the stays kept in proportion to their nights
+func allocate(stays []models.ReportStay) map[int]int {
+	revenue := make(map[int]int)
+	groups := make(map[int][]models.ReportStay)
+
+	for _, stay := range stays {
+		if stay.GroupId == 0 {
+			revenue[stay.ReservationId] = stay.Revenue
+			continue
+		}
+		groups[stay.GroupId] = append(groups[stay.GroupId], stay)
+	}
+
+	for _, members := range groups {
+		var total, nights int
+		var kept []models.ReportStay
+		for _, stay := range members {
+			total += stay.Revenue
+			if stay.CancelledAt.IsZero() {
+				kept = append(kept, stay)
+				nights += dates.Range{Start: stay.StartDate, End: stay.EndDate}.Nights()
+			}
+		}
+		if nights == 0 {
+			continue
+		}
+
+		// hand out whole cents, the rounding is given to the last stay
+		left := total
+		for i, stay := range kept {
+			amount := total * dates.Range{Start: stay.StartDate, End: stay.EndDate}.Nights() / nights
+			if i == len(kept)-1 {
+				amount = left
+			}
+			revenue[stay.ReservationId] = amount
+			left -= amount
+		}
+	}
+
+	return revenue
+}
+
+// share returns the part of amount earned on night i of n, so that the nights add up to amount exactly
+func share(amount, n, i int) int {
+	return amount*(i+1)/n - amount*i/n
+}
+
+// split cuts period into intervals. Weeks start on Monday and months on the 1st, the first and last
+// intervals are cut to the period
+func split(period dates.Range, interval string) []dates.Range {
+	var spans []dates.Range
+
+	for start := period.Start; start.Before(period.End); {
+		var end time.Time
+		switch interval {
+		case IntervalWeek:
+			end = start.AddDate(0, 0, 7-(int(start.Weekday())+6)%7)
+		case IntervalMonth:
+			end = time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, start.Location())
+		default:
+			end = start.AddDate(0, 0, 1)
+		}
+		if end.After(period.End) {
+			end = period.End
+		}
+		spans = append(spans, dates.Range{Start: start, End: end})
+		start = end
+	}
+
+	return spans
+}
+
+// label names the interval starting on start
+func label(start time.Time, interval string) string {
+	if interval == IntervalMonth {
+		return start.Format("Jan 2006")
+	}
+	return start.Format(dates.Layout)
+}
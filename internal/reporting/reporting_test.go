@@ -0,0 +1,103 @@
+package reporting
+
+import (
+	"testing"
+
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/dates/datestest"
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestBuild(t *testing.T) {
+	period := dates.Range{Start: datestest.Day(t, "2050-01-01"), End: datestest.Day(t, "2050-01-11")}
+	rooms := []models.Room{{ID: 1, RoomName: "A"}, {ID: 2, RoomName: "B"}}
+
+	stays := []models.ReportStay{
+		// 4 nights in the period, booked 10 days ahead
+		{ReservationId: 1, RoomId: 1, StartDate: datestest.Day(t, "2050-01-02"), EndDate: datestest.Day(t, "2050-01-06"), CreatedAt: datestest.Day(t, "2049-12-23"), Revenue: 40000},
+		// only its last 2 nights are in the period
+		{ReservationId: 2, RoomId: 2, StartDate: datestest.Day(t, "2049-12-30"), EndDate: datestest.Day(t, "2050-01-03"), CreatedAt: datestest.Day(t, "2049-12-01"), Revenue: 40000},
+		// cancelled
+		{ReservationId: 3, RoomId: 2, StartDate: datestest.Day(t, "2050-01-05"), EndDate: datestest.Day(t, "2050-01-07"), CreatedAt: datestest.Day(t, "2050-01-01"), CancelledAt: datestest.Day(t, "2050-01-02"), Revenue: 20000},
+	}
+	blocks := []models.RoomRestriction{
+		{RoomId: 2, StartDate: datestest.Day(t, "2050-01-08"), EndDate: datestest.Day(t, "2050-01-10")},
+	}
+
+	report := Build(period, IntervalDay, rooms, stays, blocks)
+
+	if report.Totals.Available != 18 {
+		t.Errorf("expected 18 available room nights, got %d", report.Totals.Available)
+	}
+	if report.Totals.Sold != 6 {
+		t.Errorf("expected 6 sold room nights, got %d", report.Totals.Sold)
+	}
+	if report.Totals.Revenue != 60000 {
+		t.Errorf("expected 60000 revenue, got %d", report.Totals.Revenue)
+	}
+	if report.Totals.ADR != 10000 {
+		t.Errorf("expected an ADR of 10000, got %d", report.Totals.ADR)
+	}
+	if report.Totals.RevPAR != 3333 {
+		t.Errorf("expected a RevPAR of 3333, got %d", report.Totals.RevPAR)
+	}
+
+	if len(report.Rooms) != 2 || report.Rooms[0].Sold != 4 || report.Rooms[0].Occupancy != 40 {
+		t.Errorf("wrong room figures %+v", report.Rooms)
+	}
+	if len(report.Periods) != 10 || report.Periods[1].Sold != 2 || report.Periods[8].Available != 1 {
+		t.Errorf("wrong period figures %+v", report.Periods)
+	}
+
+	if report.Bookings != 2 || report.Cancellations != 1 || report.CancellationRate != 50 {
+		t.Errorf("wrong cancellations %d of %d", report.Cancellations, report.Bookings)
+	}
+	if report.AverageLeadTime != 10 || report.LeadTime[2].Count != 1 {
+		t.Errorf("wrong lead time %v %+v", report.AverageLeadTime, report.LeadTime)
+	}
+	if report.AverageLengthOfStay != 4 || report.LengthOfStay[3].Count != 1 {
+		t.Errorf("wrong length of stay %v %+v", report.AverageLengthOfStay, report.LengthOfStay)
+	}
+}
+
+func TestGroupRevenue(t *testing.T) {
+	period := dates.Range{Start: datestest.Day(t, "2050-01-01"), End: datestest.Day(t, "2050-01-04")}
+	rooms := []models.Room{{ID: 1}, {ID: 2}}
+
+	// the master folio carries the room revenue of both rooms
+	stays := []models.ReportStay{
+		{ReservationId: 1, RoomId: 1, GroupId: 7, StartDate: datestest.Day(t, "2050-01-01"), EndDate: datestest.Day(t, "2050-01-04"), Revenue: 60001},
+		{ReservationId: 2, RoomId: 2, GroupId: 7, StartDate: datestest.Day(t, "2050-01-01"), EndDate: datestest.Day(t, "2050-01-04")},
+	}
+
+	report := Build(period, IntervalDay, rooms, stays, nil)
+
+	if report.Rooms[0].Revenue+report.Rooms[1].Revenue != 60001 {
+		t.Errorf("expected the group revenue to add up, got %+v", report.Rooms)
+	}
+	if report.Rooms[1].Revenue < 30000 {
+		t.Errorf("expected the revenue to be shared, got %+v", report.Rooms)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	// 2050-01-05 is a Wednesday
+	period := dates.Range{Start: datestest.Day(t, "2050-01-05"), End: datestest.Day(t, "2050-02-03")}
+
+	weeks := split(period, IntervalWeek)
+	if len(weeks) != 5 || weeks[0].End.Format(dates.Layout) != "2050-01-10" || weeks[4].End != period.End {
+		t.Errorf("wrong weeks %v", weeks)
+	}
+
+	months := split(period, IntervalMonth)
+	if len(months) != 2 || months[1].Start.Format(dates.Layout) != "2050-02-01" {
+		t.Errorf("wrong months %v", months)
+	}
+	if label(months[1].Start, IntervalMonth) != "Feb 2050" {
+		t.Errorf("wrong label %s", label(months[1].Start, IntervalMonth))
+	}
+
+	if len(split(period, IntervalDay)) != period.Nights() {
+		t.Error("expected one interval a night")
+	}
+}
@@ -2,6 +2,7 @@ package dbrepo
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/eldicela/bookings/internal/config"
 	"github.com/eldicela/bookings/internal/repository"
@@ -41,3 +42,18 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullInt stores a zero id as NULL
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i > 0}
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
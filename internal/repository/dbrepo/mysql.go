@@ -4,29 +4,28 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/eldicela/bookings/internal/dates"
+	"github.com/eldicela/bookings/internal/folio"
+	"github.com/eldicela/bookings/internal/guests"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/payments"
+	"github.com/eldicela/bookings/internal/promo"
+	"github.com/eldicela/bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *mysqlDBRepo) AllUsers() bool {
-	return true
-}
-
-// InsertReservtion Inserts a reservation into database
-func (m *mysqlDBRepo) InsertReservation(res models.Reservation) (int, error) {
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	// var newId int
-
-	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-			values (?, ?, ?, ?, ?, ?, ?, ?, ? );  `
+const insertReservation = `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id,
+		manage_token, promo_code_id, discount, adults, children, room_type_id, guest_id, group_id, channel, channel_ref,
+		created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+func reservationValues(res models.Reservation) []interface{} {
+	return []interface{}{
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -34,9 +33,65 @@ func (m *mysqlDBRepo) InsertReservation(res models.Reservation) (int, error) {
 		res.StartDate,
 		res.EndDate,
 		res.RoomId,
+		res.ManageToken,
+		nullInt(res.PromoCodeId),
+		res.Discount,
+		res.Adults,
+		res.Children,
+		nullInt(res.RoomTypeId),
+		nullInt(res.GuestId),
+		nullInt(res.GroupId),
+		nullString(res.Channel),
+		nullString(res.ChannelRef),
 		time.Now(),
 		time.Now(),
-	)
+	}
+}
+
+const insertPayment = `insert into payments (reservation_id, provider, transaction_id, amount, currency, status,
+		created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+func paymentValues(p models.Payment) []interface{} {
+	return []interface{}{
+		p.ReservationId,
+		p.Provider,
+		p.TransactionId,
+		p.Amount,
+		p.Currency,
+		p.Status,
+		time.Now(),
+		time.Now(),
+	}
+}
+
+const insertFolioEntry = `insert into folio_entries (reservation_id, entry_type, category, description, amount, voids_id,
+		tax_fee_rule_id, created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func folioEntryValues(e models.FolioEntry) []interface{} {
+	return []interface{}{
+		e.ReservationId,
+		e.EntryType,
+		e.Category,
+		e.Description,
+		e.Amount,
+		nullInt(e.VoidsId),
+		nullInt(e.TaxFeeRuleId),
+		time.Now(),
+		time.Now(),
+	}
+}
+
+// InsertReservtion Inserts a reservation into database
+func (m *mysqlDBRepo) InsertReservation(res models.Reservation) (int, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// var newId int
+
+	_, err := m.DB.ExecContext(ctx, insertReservation, reservationValues(res)...)
 
 	// newId, errr := repository.DatabaseRepo.GetLastInsertedID()
 	newId, errr := GetLastInsertedID(m.DB)
@@ -108,8 +163,9 @@ func GetLastInsertedID(conn *sql.DB) (int, error) {
 	return newId, nil
 }
 
-// SearchAvailabilityByDatesByRoomID Returns true if availability exist for roomId and false if no availability
-func (m *mysqlDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error) {
+// SearchAvailabilityByDatesByRoomID Returns true if availability exist for roomId and false if no availability.
+// A stay may start on the day another one ends
+func (m *mysqlDBRepo) SearchAvailabilityByDatesByRoomID(stay dates.Range, roomId int) (bool, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -122,7 +178,7 @@ func (m *mysqlDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, ro
 		room_id = ? 
 		and ? < end_date and ? > start_date;`
 
-	row := m.DB.QueryRowContext(ctx, query, roomId, start, end)
+	row := m.DB.QueryRowContext(ctx, query, roomId, stay.Start, stay.End)
 	err := row.Scan(&numRows)
 	if err != nil {
 		return false, err
@@ -136,20 +192,23 @@ func (m *mysqlDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, ro
 }
 
 // SearchAvailabilityForAllRooms return a slice of available rooms, if any, for given date range
-func (m *mysqlDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// that can sleep the number of guests, a max occupancy of 0 meaning no limit
+func (m *mysqlDBRepo) SearchAvailabilityForAllRooms(stay dates.Range, guests, propertyId int) ([]models.Room, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var rooms []models.Room
 	query := `
-	SELECT r.id, r.room_name
+	SELECT r.id, r.room_name, r.price, r.max_occupancy, r.base_occupancy, r.extra_guest_price, coalesce(rt.id, 0),
+		coalesce(rt.name, ''), coalesce(rt.description, ''), r.property_id
 	FROM rooms r
-	WHERE r.id not in 
+	LEFT JOIN room_types rt ON (rt.id = r.room_type_id)
+	WHERE r.property_id = ? and (r.max_occupancy = 0 or r.max_occupancy >= ?) and r.id not in 
 		(	SELECT rr.room_id from room_restrictions rr where ? < rr.end_date and ? > rr.start_date);
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, guests, stay.Start, stay.End)
 	if err != nil {
 		return rooms, err
 	}
@@ -158,10 +217,19 @@ func (m *mysqlDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mod
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.Price,
+			&room.MaxOccupancy,
+			&room.BaseOccupancy,
+			&room.ExtraGuestPrice,
+			&room.RoomTypeId,
+			&room.RoomType.Name,
+			&room.RoomType.Description,
+			&room.PropertyId,
 		)
 		if err != nil {
 			return rooms, err
 		}
+		room.RoomType.ID = room.RoomTypeId
 
 		rooms = append(rooms, room)
 	}
@@ -181,19 +249,36 @@ func (m *mysqlDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
 
 	query := `
-		SELECT id, room_name, created_at, updated_at FROM rooms WHERE id = ?; 
+		SELECT id, room_name, price, max_occupancy, base_occupancy, extra_guest_price, coalesce(room_type_id, 0),
+		coalesce(property_id, 0), created_at, updated_at, housekeeping_status, status_note, out_of_order_until,
+		coalesce(out_of_order_block_id, 0), ical_token
+		FROM rooms WHERE id = ?; 
 	`
 
+	var until sql.NullTime
+
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Price,
+		&room.MaxOccupancy,
+		&room.BaseOccupancy,
+		&room.ExtraGuestPrice,
+		&room.RoomTypeId,
+		&room.PropertyId,
 		&room.CreatedAt,
 		&room.UpdatedAt,
+		&room.HousekeepingStatus,
+		&room.StatusNote,
+		&until,
+		&room.OutOfOrderBlockId,
+		&room.ICalToken,
 	)
 	if err != nil {
 		return room, err
 	}
+	room.OutOfOrderUntil = until.Time
 
 	return room, nil
 }
@@ -274,106 +359,142 @@ func (m *mysqlDBRepo) Authenticate(email, testPassword string) (int, string, err
 	return id, hashedPassword, nil
 }
 
-// AllReservations Returns a slice of all reservations
-func (m *mysqlDBRepo) AllReservations() ([]models.Reservation, error) {
+// reservationListColumns are the columns of the reservation lists, read by scanListedReservation
+const reservationListColumns = `r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+			r.created_at, r.updated_at, r.processed, r.adults, r.children, r.checked_in_at, r.checked_out_at,
+			r.cancelled_at, rm.id, rm.room_name,
+			coalesce((SELECT sum(case when f.entry_type = 'payment' then -f.amount else f.amount end)
+			FROM folio_entries f WHERE f.reservation_id = r.id), 0) as balance_due`
+
+// reservationListFrom selects the reservations of a property matching a filter, with reservationListArgs
+const reservationListFrom = `FROM reservations r
+			LEFT JOIN rooms rm on (r.room_id = rm.id)
+			WHERE rm.property_id = ?
+			AND (? = 0 OR (r.processed = 0 AND r.cancelled_at IS NULL))
+			AND (? IS NULL OR r.start_date >= ?)
+			AND (? IS NULL OR r.start_date <= ?)
+			AND (? = '' OR concat(r.first_name, ' ', r.last_name) LIKE ? OR r.email LIKE ?)`
+
+func reservationListArgs(propertyId int, f models.ReservationFilter) []interface{} {
+	newOnly := 0
+	if f.NewOnly {
+		newOnly = 1
+	}
+	from, to := nullTime(f.ArrivalFrom), nullTime(f.ArrivalTo)
+	like := "%" + f.Search + "%"
+
+	return []interface{}{
+		propertyId,
+		newOnly,
+		from, from,
+		to, to,
+		f.Search, like, like,
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+func scanListedReservation(rows *sql.Rows) (models.Reservation, error) {
+	var i models.Reservation
+	var checkedIn, checkedOut, cancelled sql.NullTime
+	err := rows.Scan(
+		&i.ID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Email,
+		&i.Phone,
+		&i.StartDate,
+		&i.EndDate,
+		&i.RoomId,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Processed,
+		&i.Adults,
+		&i.Children,
+		&checkedIn,
+		&checkedOut,
+		&cancelled,
+		&i.Room.ID,
+		&i.Room.RoomName,
+		&i.BalanceDue,
+	)
+	if err != nil {
+		return i, err
+	}
+	i.CheckedInAt = checkedIn.Time
+	i.CheckedOutAt = checkedOut.Time
+	i.CancelledAt = cancelled.Time
 
-	var reservations []models.Reservation
+	return i, nil
+}
 
-	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.processed , rm.id, rm.room_name
-	FROM reservations r
-	LEFT JOIN rooms rm on (r.room_id = rm.id)
-	ORDER BY r.start_date asc;
-	`
+// EachReservation calls fn with every reservation of a property matching f, in arrival order, as the
+// rows are read. It stops at the first error fn returns. Exports stream the rows to the client, so the
+// query may run for as long as a download takes
+func (m *mysqlDBRepo) EachReservation(propertyId int, f models.ReservationFilter, fn func(models.Reservation) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	query := `SELECT ` + reservationListColumns + `
+			` + reservationListFrom + `
+			ORDER BY r.start_date, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationListArgs(propertyId, f)...)
 	if err != nil {
-		return reservations, err
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomId,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
+		i, err := scanListedReservation(rows)
 		if err != nil {
-			return reservations, err
+			return err
 		}
-		reservations = append(reservations, i)
-	}
 
-	if err = rows.Err(); err != nil {
-		return reservations, err
+		if err = fn(i); err != nil {
+			return err
+		}
 	}
 
-	return reservations, nil
+	return rows.Err()
 }
 
-// AllNewReservations Returns a slice of all reservations
-func (m *mysqlDBRepo) AllNewReservations() ([]models.Reservation, error) {
-
+// ReservationsPage returns limit reservations of a property matching f from offset on, in arrival order,
+// and how many match in all
+func (m *mysqlDBRepo) ReservationsPage(propertyId int, f models.ReservationFilter, offset, limit int) ([]models.Reservation, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var reservations []models.Reservation
+	args := reservationListArgs(propertyId, f)
 
-	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, rm.id, rm.room_name
-	FROM reservations r
-	LEFT JOIN rooms rm on (r.room_id = rm.id)
-	WHERE processed =0
-	ORDER BY r.start_date asc;
-	`
+	var total int
+	err := m.DB.QueryRowContext(ctx, `SELECT count(*) `+reservationListFrom, args...).Scan(&total)
+	if err != nil {
+		return reservations, 0, err
+	}
+	if offset >= total {
+		return reservations, total, nil
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	query := `SELECT ` + reservationListColumns + `
+			` + reservationListFrom + `
+			ORDER BY r.start_date, r.id
+			LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, append(args, limit, offset)...)
 	if err != nil {
-		return reservations, err
+		return reservations, 0, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomId,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
+		i, err := scanListedReservation(rows)
 		if err != nil {
-			return reservations, err
+			return reservations, 0, err
 		}
 		reservations = append(reservations, i)
 	}
 
-	if err = rows.Err(); err != nil {
-		return reservations, err
-	}
-
-	return reservations, nil
+	return reservations, total, rows.Err()
 }
 
 // GetReservationById returns one reservation by ID
@@ -382,9 +503,13 @@ func (m *mysqlDBRepo) GetReservationById(id int) (models.Reservation, error) {
 	defer cancel()
 
 	var res models.Reservation
+	var checkedIn, checkedOut, cancelled sql.NullTime
 
 	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at,
-			 r.updated_at, r.processed, rm.id, rm.room_name
+			 r.updated_at, r.processed, r.manage_token, r.adults, r.children,
+			 coalesce(r.room_type_id, 0), coalesce(r.guest_id, 0), coalesce(r.group_id, 0), r.version,
+			 r.checked_in_at, r.checked_out_at, r.cancelled_at, coalesce(r.channel, ''), coalesce(r.channel_ref, ''),
+			 rm.id, rm.room_name, rm.max_occupancy, coalesce(rm.property_id, 0)
 			 FROM reservations r
 			 LEFT JOIN rooms rm ON (r.room_id = rm.id)
 			 WHERE r.id =?
@@ -403,38 +528,123 @@ func (m *mysqlDBRepo) GetReservationById(id int) (models.Reservation, error) {
 		&res.CreatedAt,
 		&res.UpdatedAt,
 		&res.Processed,
+		&res.ManageToken,
+		&res.Adults,
+		&res.Children,
+		&res.RoomTypeId,
+		&res.GuestId,
+		&res.GroupId,
+		&res.Version,
+		&checkedIn,
+		&checkedOut,
+		&cancelled,
+		&res.Channel,
+		&res.ChannelRef,
 		&res.Room.ID,
 		&res.Room.RoomName,
+		&res.Room.MaxOccupancy,
+		&res.Room.PropertyId,
 	)
 	if err != nil {
 		return res, err
 	}
+	res.CheckedInAt = checkedIn.Time
+	res.CheckedOutAt = checkedOut.Time
+	res.CancelledAt = cancelled.Time
 
 	return res, nil
 }
 
-// UpdateReservation updates a user in the database
+// GetReservationByManageToken returns the reservation a guest manage link points to
+func (m *mysqlDBRepo) GetReservationByManageToken(token string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+
+	if token == "" {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	row := m.DB.QueryRowContext(ctx, "SELECT id FROM reservations WHERE manage_token = ?", token)
+	err := row.Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return m.GetReservationById(id)
+}
+
+// GetReservationByChannelRef returns the reservation booked from a channel booking, sql.ErrNoRows when
+// the booking has not been booked yet
+func (m *mysqlDBRepo) GetReservationByChannelRef(channel, ref string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+
+	row := m.DB.QueryRowContext(ctx, "SELECT id FROM reservations WHERE channel = ? AND channel_ref = ?", channel, ref)
+	err := row.Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return m.GetReservationById(id)
+}
+
+// UpdateReservation updates a reservation in the database and links it to the guest profile of its
+// contact details, which may be another profile than before. It returns a *repository.ConflictError
+// when the reservation was saved since u was read, in which case nothing is written
 func (m *mysqlDBRepo) UpdateReservation(u models.Reservation) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `UPDATE reservations set first_name = ?, last_name = ?, email = ?, phone = ?, updated_at = ?
-		WHERE id = ? `
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the version only matches if nobody saved the reservation since u was read
+	query := `UPDATE reservations set first_name = ?, last_name = ?, email = ?, phone = ?, adults = ?, children = ?,
+		updated_at = ?, version = version + 1
+		WHERE id = ? AND version = ?`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	result, err := tx.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.Phone,
+		u.Adults,
+		u.Children,
 		time.Now(),
 		u.ID,
+		u.Version,
 	)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &repository.ConflictError{Entity: "reservation", ID: u.ID, Version: u.Version}
+	}
+
+	guestId, err := findOrCreateGuest(ctx, tx, guests.FromReservation(u))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE reservations SET guest_id = ? WHERE id = ?", guestId, u.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // DeleteReservation one reservation by Id
@@ -451,6 +661,41 @@ func (m *mysqlDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
+// CancelReservation marks a reservation cancelled and frees the nights it held on the calendar. The
+// reservation, its folio and its payments are kept. It returns sql.ErrNoRows when the reservation was
+// cancelled already
+func (m *mysqlDBRepo) CancelReservation(id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE reservations SET cancelled_at = ?, updated_at = ? WHERE id = ? AND cancelled_at IS NULL",
+		at, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM room_restrictions WHERE reservation_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (m *mysqlDBRepo) UpdateProcessedForReservation(id, processed int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -464,15 +709,22 @@ func (m *mysqlDBRepo) UpdateProcessedForReservation(id, processed int) error {
 	return nil
 }
 
-func (m *mysqlDBRepo) AllRooms() ([]models.Room, error) {
+// AllRooms returns the rooms of a property
+func (m *mysqlDBRepo) AllRooms(propertyId int) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var rooms []models.Room
 
-	query := `SELECT id, room_name, created_at, updated_at FROM rooms ORDER BY room_name`
+	query := `SELECT r.id, r.room_name, r.price, r.max_occupancy, r.base_occupancy, r.extra_guest_price,
+			coalesce(r.room_type_id, 0), coalesce(rt.name, ''), r.property_id, r.created_at, r.updated_at,
+			r.housekeeping_status, r.status_note, r.out_of_order_until, coalesce(r.out_of_order_block_id, 0), r.ical_token
+			FROM rooms r
+			LEFT JOIN room_types rt ON (rt.id = r.room_type_id)
+			WHERE r.property_id = ?
+			ORDER BY r.room_name`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, propertyId)
 	if err != nil {
 		return rooms, err
 	}
@@ -480,15 +732,29 @@ func (m *mysqlDBRepo) AllRooms() ([]models.Room, error) {
 
 	for rows.Next() {
 		var rm models.Room
+		var until sql.NullTime
 		err := rows.Scan(
 			&rm.ID,
 			&rm.RoomName,
+			&rm.Price,
+			&rm.MaxOccupancy,
+			&rm.BaseOccupancy,
+			&rm.ExtraGuestPrice,
+			&rm.RoomTypeId,
+			&rm.RoomType.Name,
+			&rm.PropertyId,
 			&rm.CreatedAt,
 			&rm.UpdatedAt,
+			&rm.HousekeepingStatus,
+			&rm.StatusNote,
+			&until,
+			&rm.OutOfOrderBlockId,
+			&rm.ICalToken,
 		)
 		if err != nil {
 			return rooms, err
 		}
+		rm.OutOfOrderUntil = until.Time
 
 		rooms = append(rooms, rm)
 	}
@@ -501,19 +767,20 @@ func (m *mysqlDBRepo) AllRooms() ([]models.Room, error) {
 
 }
 
-// GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *mysqlDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+// GetRestrictionsForRoomByDate returns restrictions for a room taking any night of period
+func (m *mysqlDBRepo) GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var restrictions []models.RoomRestriction
 
-	query := `SELECT id, coalesce(reservation_id, 0) as res, restriction_id, room_id, start_date, end_date
-			FROM room_restrictions WHERE ? <= end_date and ? >= start_date
+	query := `SELECT id, coalesce(reservation_id, 0) as res, restriction_id, room_id, start_date, end_date,
+			created_at, updated_at, coalesce(ical_source_id, 0)
+			FROM room_restrictions WHERE ? < end_date and ? > start_date
 			AND room_id = ?
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomId)
+	rows, err := m.DB.QueryContext(ctx, query, period.Start, period.End, roomId)
 	if err != nil {
 		return nil, err
 	}
@@ -529,6 +796,9 @@ func (m *mysqlDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.T
 			&r.RoomId,
 			&r.StartDate,
 			&r.EndDate,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.ICalSourceId,
 		)
 
 		if err != nil {
@@ -543,8 +813,8 @@ func (m *mysqlDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.T
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *mysqlDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// InsertBlockForRoom blocks a room for the night of startDate and returns the id of the block
+func (m *mysqlDBRepo) InsertBlockForRoom(id int, startDate time.Time) (int, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -553,12 +823,18 @@ func (m *mysqlDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 			VALUES (?, ?, ?, ?, ?, ?)
 		`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	result, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
 	if err != nil {
 		log.Println(err)
-		return err
+		return 0, err
 	}
-	return nil
+
+	blockId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(blockId), nil
 }
 
 // InsertBlockForRoom deletes a room restriction
@@ -577,3 +853,2743 @@ func (m *mysqlDBRepo) DeleteBlockById(id int) error {
 	}
 	return nil
 }
+
+// InsertPayment inserts a payment record for a reservation
+func (m *mysqlDBRepo) InsertPayment(p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, insertPayment, paymentValues(p)...)
+	if err != nil {
+		return 0, err
+	}
+
+	newId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newId), nil
+}
+
+// GetPaymentsForReservation returns all payments made for a reservation
+func (m *mysqlDBRepo) GetPaymentsForReservation(reservationId int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `SELECT id, reservation_id, provider, transaction_id, amount, currency, status, created_at, updated_at
+			FROM payments WHERE reservation_id = ? ORDER BY created_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(
+			&p.ID,
+			&p.ReservationId,
+			&p.Provider,
+			&p.TransactionId,
+			&p.Amount,
+			&p.Currency,
+			&p.Status,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+
+	return payments, nil
+}
+
+// GetPaymentById returns one payment by id
+func (m *mysqlDBRepo) GetPaymentById(id int) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.Payment
+
+	query := `SELECT id, reservation_id, provider, transaction_id, amount, currency, status, created_at, updated_at
+			FROM payments WHERE id = ?`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&p.ID,
+		&p.ReservationId,
+		&p.Provider,
+		&p.TransactionId,
+		&p.Amount,
+		&p.Currency,
+		&p.Status,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// UpdatePaymentStatus sets the status of the payment with the given gateway transaction id
+func (m *mysqlDBRepo) UpdatePaymentStatus(transactionId, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE payments SET status = ?, updated_at = ? WHERE transaction_id = ?`
+
+	_, err := m.DB.ExecContext(ctx, query, status, time.Now(), transactionId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RefundPayment records a refund the gateway made: the payment takes status and the refund is posted on
+// the folio, both or neither. sql.ErrNoRows is returned when the payment was refunded already
+func (m *mysqlDBRepo) RefundPayment(paymentId int, status string, e models.FolioEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE payments SET status = ?, updated_at = ? WHERE id = ? AND status <> ?",
+		status, time.Now(), paymentId, payments.StatusRefunded)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, insertFolioEntry, folioEntryValues(e)...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertFolioEntry posts an entry on a reservation folio. Entries are never updated
+func (m *mysqlDBRepo) InsertFolioEntry(e models.FolioEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, insertFolioEntry, folioEntryValues(e)...)
+	if err != nil {
+		return 0, err
+	}
+
+	newId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newId), nil
+}
+
+// GetFolioEntriesForReservation returns the folio of a reservation in posting order
+func (m *mysqlDBRepo) GetFolioEntriesForReservation(reservationId int) ([]models.FolioEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.FolioEntry
+
+	query := `SELECT id, reservation_id, entry_type, category, description, amount, coalesce(voids_id, 0),
+			coalesce(tax_fee_rule_id, 0), created_at, updated_at
+			FROM folio_entries WHERE reservation_id = ? ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.FolioEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.ReservationId,
+			&e.EntryType,
+			&e.Category,
+			&e.Description,
+			&e.Amount,
+			&e.VoidsId,
+			&e.TaxFeeRuleId,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// scanPromoCode reads a promo code row, turning NULL dates into zero times
+func scanPromoCode(row interface{ Scan(...interface{}) error }) (models.PromoCode, error) {
+	var p models.PromoCode
+	var validFrom, validTo, stayFrom, stayTo sql.NullTime
+
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.DiscountType,
+		&p.Amount,
+		&validFrom,
+		&validTo,
+		&stayFrom,
+		&stayTo,
+		&p.MaxUses,
+		&p.TimesUsed,
+		&p.Active,
+		&p.PropertyId,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return p, err
+	}
+
+	p.ValidFrom = validFrom.Time
+	p.ValidTo = validTo.Time
+	p.StayFrom = stayFrom.Time
+	p.StayTo = stayTo.Time
+
+	return p, nil
+}
+
+// promoCodeRoomIds returns the rooms a promo code is restricted to
+func (m *mysqlDBRepo) promoCodeRoomIds(ctx context.Context, promoCodeId int) ([]int, error) {
+	var ids []int
+
+	rows, err := m.DB.QueryContext(ctx, "SELECT room_id FROM promo_code_rooms WHERE promo_code_id = ?", promoCodeId)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return ids, err
+	}
+
+	return ids, nil
+}
+
+// AllPromoCodes returns the promo codes of a property
+func (m *mysqlDBRepo) AllPromoCodes(propertyId int) ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	query := `SELECT id, code, description, discount_type, amount, valid_from, valid_to, stay_from, stay_to,
+			max_uses, times_used, active, property_id, created_at, updated_at
+			FROM promo_codes WHERE property_id = ? ORDER BY code`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	for i := range codes {
+		codes[i].RoomIds, err = m.promoCodeRoomIds(ctx, codes[i].ID)
+		if err != nil {
+			return codes, err
+		}
+	}
+
+	return codes, nil
+}
+
+// GetPromoCodeByCode returns a promo code of a property with its room restrictions
+func (m *mysqlDBRepo) GetPromoCodeByCode(propertyId int, code string) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, code, description, discount_type, amount, valid_from, valid_to, stay_from, stay_to,
+			max_uses, times_used, active, property_id, created_at, updated_at
+			FROM promo_codes WHERE property_id = ? AND code = ?`
+
+	p, err := scanPromoCode(m.DB.QueryRowContext(ctx, query, propertyId, promo.Normalize(code)))
+	if err == sql.ErrNoRows {
+		return p, promo.ErrUnknownCode
+	} else if err != nil {
+		return p, err
+	}
+
+	p.RoomIds, err = m.promoCodeRoomIds(ctx, p.ID)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// InsertPromoCode inserts a promo code and its room restrictions
+func (m *mysqlDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into promo_codes (code, description, discount_type, amount, valid_from, valid_to, stay_from, stay_to,
+			max_uses, times_used, active, property_id, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 1, ?, ?, ?)`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		promo.Normalize(p.Code),
+		p.Description,
+		p.DiscountType,
+		p.Amount,
+		nullTime(p.ValidFrom),
+		nullTime(p.ValidTo),
+		nullTime(p.StayFrom),
+		nullTime(p.StayTo),
+		p.MaxUses,
+		p.PropertyId,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, roomId := range p.RoomIds {
+		_, err = tx.ExecContext(ctx, `insert into promo_code_rooms (promo_code_id, room_id, created_at, updated_at) values (?, ?, ?, ?)`,
+			newId, roomId, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(newId), nil
+}
+
+// UpdatePromoCodeActive enables or disables a promo code of a property
+func (m *mysqlDBRepo) UpdatePromoCodeActive(propertyId, id, active int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE promo_codes SET active = ?, updated_at = ? WHERE id = ? AND property_id = ?",
+		active, time.Now(), id, propertyId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RedeemPromoCode counts one use of a promo code. The limit is checked in the same statement,
+// so concurrent bookings cannot redeem a code past max_uses
+func (m *mysqlDBRepo) RedeemPromoCode(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE promo_codes SET times_used = times_used + 1, updated_at = ?
+			WHERE id = ? AND active = 1 AND (max_uses = 0 OR times_used < max_uses)`
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// nothing was redeemed, tell a code switched off from one used up
+	var active int
+	err = m.DB.QueryRowContext(ctx, "SELECT active FROM promo_codes WHERE id = ?", id).Scan(&active)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return promo.ErrUnknownCode
+	case err != nil:
+		return err
+	case active != 1:
+		return promo.ErrInactive
+	}
+	return promo.ErrExhausted
+}
+
+// ReleasePromoCode gives back a use when the booking that redeemed it failed
+func (m *mysqlDBRepo) ReleasePromoCode(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE promo_codes SET times_used = times_used - 1 WHERE id = ? AND times_used > 0", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllTaxFeeRules returns every tax and fee rule of a property
+func (m *mysqlDBRepo) AllTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error) {
+	return m.taxFeeRules(`SELECT id, name, category, basis, amount, active, property_id, created_at, updated_at
+			FROM tax_fee_rules WHERE property_id = ? ORDER BY category, name`, propertyId)
+}
+
+// ActiveTaxFeeRules returns the rules of a property applied to new quotes, in the order they are itemised
+func (m *mysqlDBRepo) ActiveTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error) {
+	return m.taxFeeRules(`SELECT id, name, category, basis, amount, active, property_id, created_at, updated_at
+			FROM tax_fee_rules WHERE property_id = ? AND active = 1 ORDER BY category desc, id`, propertyId)
+}
+
+func (m *mysqlDBRepo) taxFeeRules(query string, args ...interface{}) ([]models.TaxFeeRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.TaxFeeRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.TaxFeeRule
+		err := rows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.Category,
+			&t.Basis,
+			&t.Amount,
+			&t.Active,
+			&t.PropertyId,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// InsertTaxFeeRule creates a tax or fee rule
+func (m *mysqlDBRepo) InsertTaxFeeRule(rule models.TaxFeeRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into tax_fee_rules (name, category, basis, amount, active, property_id, created_at, updated_at)
+			values (?, ?, ?, ?, 1, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		rule.Name,
+		rule.Category,
+		rule.Basis,
+		rule.Amount,
+		rule.PropertyId,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newId), nil
+}
+
+// UpdateTaxFeeRuleActive enables or disables a tax or fee rule of a property. Folios already posted
+// are not changed
+func (m *mysqlDBRepo) UpdateTaxFeeRuleActive(propertyId, id, active int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE tax_fee_rules SET active = ?, updated_at = ? WHERE id = ? AND property_id = ?",
+		active, time.Now(), id, propertyId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TaxFeeReport totals the taxes and fees posted to folios of a property from one moment up to another,
+// per rule. Voids are netted out and entries posted by staff without a rule are grouped by category
+func (m *mysqlDBRepo) TaxFeeReport(from, to time.Time, propertyId int) ([]models.TaxFeeTotal, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var totals []models.TaxFeeTotal
+
+	query := `SELECT coalesce(fe.tax_fee_rule_id, 0), coalesce(t.name, 'Posted by staff'), fe.category,
+			sum(case when fe.voids_id is null then 1 else -1 end), sum(fe.amount)
+			FROM folio_entries fe
+			JOIN reservations r ON (r.id = fe.reservation_id)
+			JOIN rooms rm ON (rm.id = r.room_id)
+			LEFT JOIN tax_fee_rules t ON (t.id = fe.tax_fee_rule_id)
+			WHERE rm.property_id = ? AND fe.category IN (?, ?) AND fe.created_at >= ? AND fe.created_at < ?
+			GROUP BY coalesce(fe.tax_fee_rule_id, 0), coalesce(t.name, 'Posted by staff'), fe.category
+			ORDER BY fe.category desc, 2`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, folio.CategoryTax, folio.CategoryFee, from, to)
+	if err != nil {
+		return totals, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.TaxFeeTotal
+		err := rows.Scan(
+			&t.RuleId,
+			&t.Name,
+			&t.Category,
+			&t.Entries,
+			&t.Amount,
+		)
+		if err != nil {
+			return totals, err
+		}
+		totals = append(totals, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return totals, err
+	}
+
+	return totals, nil
+}
+
+// AllRoomTypes returns the room types of a property
+func (m *mysqlDBRepo) AllRoomTypes(propertyId int) ([]models.RoomType, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var types []models.RoomType
+
+	query := `SELECT id, name, coalesce(description, ''), property_id, created_at, updated_at
+			FROM room_types WHERE property_id = ? ORDER BY name`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId)
+	if err != nil {
+		return types, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.RoomType
+		err := rows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.Description,
+			&t.PropertyId,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return types, err
+		}
+		types = append(types, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return types, err
+	}
+
+	return types, nil
+}
+
+// InsertRoomType creates a room type
+func (m *mysqlDBRepo) InsertRoomType(t models.RoomType) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into room_types (name, description, property_id, created_at, updated_at) values (?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, t.Name, t.Description, t.PropertyId, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	newId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newId), nil
+}
+
+// GetRoomsByType returns the physical rooms of a room type at a property. Type 0 returns the rooms
+// without a type
+func (m *mysqlDBRepo) GetRoomsByType(propertyId, roomTypeId int) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `SELECT id, room_name, price, max_occupancy, base_occupancy, extra_guest_price, coalesce(room_type_id, 0),
+			property_id, created_at, updated_at
+			FROM rooms WHERE property_id = ? AND coalesce(room_type_id, 0) = ? ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, roomTypeId)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+		err := rows.Scan(
+			&rm.ID,
+			&rm.RoomName,
+			&rm.Price,
+			&rm.MaxOccupancy,
+			&rm.BaseOccupancy,
+			&rm.ExtraGuestPrice,
+			&rm.RoomTypeId,
+			&rm.PropertyId,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// UpdateRoomType moves a physical room to a room type
+func (m *mysqlDBRepo) UpdateRoomType(roomId, roomTypeId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE rooms SET room_type_id = ?, updated_at = ? WHERE id = ?",
+		nullInt(roomTypeId), time.Now(), roomId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateRoomICalToken sets the secret of the calendar feed of a room, which stops the old feed URL working
+func (m *mysqlDBRepo) UpdateRoomICalToken(roomId int, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE rooms SET ical_token = ?, updated_at = ? WHERE id = ?",
+		token, time.Now(), roomId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReassignReservation moves a reservation and its room restriction to another physical room,
+// returning repository.ErrUnavailable when that room is taken for the stay
+func (m *mysqlDBRepo) ReassignReservation(res models.Reservation, roomId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, roomId, res.Stay())
+	if err != nil {
+		return err
+	}
+
+	// the reservation takes the type of its new room, so reports by type follow the move
+	_, err = tx.ExecContext(ctx, `UPDATE reservations
+			SET room_id = ?, room_type_id = (SELECT room_type_id FROM rooms WHERE id = ?), updated_at = ?
+			WHERE id = ?`,
+		roomId, roomId, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE room_restrictions SET room_id = ?, updated_at = ? WHERE reservation_id = ?",
+		roomId, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanProperty(row interface{ Scan(...interface{}) error }) (models.Property, error) {
+	var p models.Property
+
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.Slug,
+		&p.Email,
+		&p.Phone,
+		&p.Address,
+		&p.Description,
+		&p.Timezone,
+		&p.CheckInTime,
+		&p.CheckOutTime,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	return p, err
+}
+
+const propertyColumns = `p.id, p.name, p.slug, p.email, p.phone, p.address, coalesce(p.description, ''),
+	p.timezone, p.check_in_time, p.check_out_time, p.created_at, p.updated_at`
+
+// AllProperties returns every property
+func (m *mysqlDBRepo) AllProperties() ([]models.Property, error) {
+	return m.properties(`SELECT ` + propertyColumns + ` FROM properties p ORDER BY p.name`)
+}
+
+// PropertiesForUser returns the properties a member of staff is assigned to
+func (m *mysqlDBRepo) PropertiesForUser(userId int) ([]models.Property, error) {
+	return m.properties(`SELECT `+propertyColumns+` FROM properties p
+			JOIN property_users pu ON (pu.property_id = p.id)
+			WHERE pu.user_id = ? ORDER BY p.name`, userId)
+}
+
+func (m *mysqlDBRepo) properties(query string, args ...interface{}) ([]models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var properties []models.Property
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return properties, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProperty(rows)
+		if err != nil {
+			return properties, err
+		}
+		properties = append(properties, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return properties, err
+	}
+
+	return properties, nil
+}
+
+// GetPropertyByID returns one property
+func (m *mysqlDBRepo) GetPropertyByID(id int) (models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanProperty(m.DB.QueryRowContext(ctx, `SELECT `+propertyColumns+` FROM properties p WHERE p.id = ?`, id))
+}
+
+// GetPropertyBySlug returns the property a public URL points to
+func (m *mysqlDBRepo) GetPropertyBySlug(slug string) (models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanProperty(m.DB.QueryRowContext(ctx, `SELECT `+propertyColumns+` FROM properties p WHERE p.slug = ?`, slug))
+}
+
+// GetDefaultProperty returns the first property, which serves the URLs without a property slug
+func (m *mysqlDBRepo) GetDefaultProperty() (models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanProperty(m.DB.QueryRowContext(ctx, `SELECT `+propertyColumns+` FROM properties p ORDER BY p.id LIMIT 1`))
+}
+
+// InsertProperty creates a property
+func (m *mysqlDBRepo) InsertProperty(p models.Property) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into properties (name, slug, email, phone, address, description, timezone, check_in_time,
+			check_out_time, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		p.Name,
+		p.Slug,
+		p.Email,
+		p.Phone,
+		p.Address,
+		p.Description,
+		p.Timezone,
+		p.CheckInTime,
+		p.CheckOutTime,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newId), nil
+}
+
+// UpdateProperty saves the settings of a property
+func (m *mysqlDBRepo) UpdateProperty(p models.Property) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE properties SET name = ?, slug = ?, email = ?, phone = ?, address = ?, description = ?,
+			timezone = ?, check_in_time = ?, check_out_time = ?, updated_at = ?
+			WHERE id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		p.Name,
+		p.Slug,
+		p.Email,
+		p.Phone,
+		p.Address,
+		p.Description,
+		p.Timezone,
+		p.CheckInTime,
+		p.CheckOutTime,
+		time.Now(),
+		p.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllUsers returns every member of staff
+func (m *mysqlDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var users []models.User
+
+	query := `SELECT id, first_name, last_name, email, access_level, created_at, updated_at FROM users ORDER BY last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// GetPropertyUserIds returns the ids of the staff assigned to a property
+func (m *mysqlDBRepo) GetPropertyUserIds(propertyId int) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var ids []int
+
+	rows, err := m.DB.QueryContext(ctx, "SELECT user_id FROM property_users WHERE property_id = ?", propertyId)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return ids, err
+	}
+
+	return ids, nil
+}
+
+// UpdatePropertyUsers replaces the staff assigned to a property
+func (m *mysqlDBRepo) UpdatePropertyUsers(propertyId int, userIds []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM property_users WHERE property_id = ?", propertyId)
+	if err != nil {
+		return err
+	}
+
+	for _, userId := range userIds {
+		_, err = tx.ExecContext(ctx, `insert into property_users (property_id, user_id, created_at, updated_at) values (?, ?, ?, ?)`,
+			propertyId, userId, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FindOrCreateGuest returns the profile matching g by email, or failing that by phone, with its details
+// refreshed from g. A new profile is created when none matches
+func (m *mysqlDBRepo) FindOrCreateGuest(g models.Guest) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := findOrCreateGuest(ctx, tx, g)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// findOrCreateGuest does the work of FindOrCreateGuest within tx. The lookups lock what they read, so two
+// bookings of a new guest at once cannot both create a profile. Guests without an email or a usable phone
+// number cannot be told apart and always get a new profile
+func findOrCreateGuest(ctx context.Context, tx *sql.Tx, g models.Guest) (int, error) {
+	emailKey := guests.EmailKey(g.Email)
+	phoneKey := guests.PhoneKey(g.Phone)
+
+	var id int
+	err := sql.ErrNoRows
+	if emailKey != "" {
+		err = tx.QueryRowContext(ctx, "SELECT id FROM guests WHERE email_key = ? ORDER BY id LIMIT 1 FOR UPDATE", emailKey).Scan(&id)
+	}
+	if errors.Is(err, sql.ErrNoRows) && phoneKey != "" {
+		err = tx.QueryRowContext(ctx, "SELECT id FROM guests WHERE phone_key = ? ORDER BY id LIMIT 1 FOR UPDATE", phoneKey).Scan(&id)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		stmt := `insert into guests (first_name, last_name, email, phone, email_key, phone_key, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?)`
+
+		result, err := tx.ExecContext(ctx, stmt, g.FirstName, g.LastName, g.Email, g.Phone, emailKey, phoneKey,
+			time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+
+		newId, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+
+		return int(newId), nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	// the latest booking has the freshest name and phone, the email stays the one the profile was found by
+	stmt := `UPDATE guests SET first_name = ?, last_name = ?, phone = ?, phone_key = ?, updated_at = ? WHERE id = ?`
+	_, err = tx.ExecContext(ctx, stmt, g.FirstName, g.LastName, g.Phone, phoneKey, time.Now(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+const guestColumns = `g.id, g.first_name, g.last_name, g.email, g.phone,
+	(SELECT count(*) FROM reservations r WHERE r.guest_id = g.id),
+	coalesce((SELECT sum(f.amount) FROM folio_entries f JOIN reservations r ON (r.id = f.reservation_id)
+		WHERE r.guest_id = g.id AND f.entry_type = 'charge'), 0),
+	g.created_at, g.updated_at`
+
+func scanGuest(row interface{ Scan(...interface{}) error }) (models.Guest, error) {
+	var g models.Guest
+
+	err := row.Scan(
+		&g.ID,
+		&g.FirstName,
+		&g.LastName,
+		&g.Email,
+		&g.Phone,
+		&g.Stays,
+		&g.TotalSpend,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
+
+	return g, err
+}
+
+// AllGuests returns the guest profiles whose name, email or phone contains search, or every profile
+// when search is empty
+func (m *mysqlDBRepo) AllGuests(search string) ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list []models.Guest
+
+	like := "%" + search + "%"
+	query := `SELECT ` + guestColumns + ` FROM guests g
+			WHERE ? = '' OR concat(g.first_name, ' ', g.last_name) LIKE ? OR g.email LIKE ? OR g.phone LIKE ?
+			ORDER BY g.last_name, g.first_name, g.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, search, like, like, like)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		g, err := scanGuest(rows)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return list, err
+	}
+
+	return list, nil
+}
+
+// GetGuestByID returns one guest profile
+func (m *mysqlDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanGuest(m.DB.QueryRowContext(ctx, `SELECT `+guestColumns+` FROM guests g WHERE g.id = ?`, id))
+}
+
+// UpdateGuest saves the contact details of a guest profile
+func (m *mysqlDBRepo) UpdateGuest(g models.Guest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE guests SET first_name = ?, last_name = ?, email = ?, phone = ?, email_key = ?, phone_key = ?,
+			updated_at = ? WHERE id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		g.FirstName,
+		g.LastName,
+		g.Email,
+		g.Phone,
+		guests.EmailKey(g.Email),
+		guests.PhoneKey(g.Phone),
+		time.Now(),
+		g.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetReservationsForGuest returns every stay of a guest at any property, latest first
+func (m *mysqlDBRepo) GetReservationsForGuest(guestId int) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+		r.created_at, r.updated_at, r.processed, rm.id, rm.room_name, coalesce(rm.property_id, 0),
+		coalesce((SELECT sum(case when f.entry_type = 'payment' then -f.amount else f.amount end)
+		FROM folio_entries f WHERE f.reservation_id = r.id), 0) as balance_due
+	FROM reservations r
+	LEFT JOIN rooms rm on (r.room_id = rm.id)
+	WHERE r.guest_id = ?
+	ORDER BY r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, guestId)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomId,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.Room.ID,
+			&i.Room.RoomName,
+			&i.Room.PropertyId,
+			&i.BalanceDue,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		i.GuestId = guestId
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// MergeGuests moves the reservations of the duplicate profile onto the kept one and deletes the duplicate
+func (m *mysqlDBRepo) MergeGuests(keepId, duplicateId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE reservations SET guest_id = ?, updated_at = ? WHERE guest_id = ?",
+		keepId, time.Now(), duplicateId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM guests WHERE id = ?", duplicateId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateReservations books every room of one booking, with its room restriction, in a single transaction.
+// If any room has been taken in the meantime nothing is written and ErrUnavailable is returned. A booking
+// of more than one room gets a reservation group named name, whose master is the first reservation. The
+// charges and payments of the booking are posted to the master reservation in the same transaction, so a
+// booking is never left without them
+func (m *mysqlDBRepo) CreateReservations(name string, lines []models.Reservation, charges []models.FolioEntry,
+	payments []models.Payment) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var groupId int
+	if len(lines) > 1 {
+		result, err := tx.ExecContext(ctx, "insert into reservation_groups (name, created_at, updated_at) values (?, ?, ?)",
+			name, time.Now(), time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		groupId = int(id)
+	}
+
+	booked := make([]models.Reservation, len(lines))
+	for i, res := range lines {
+		res.GroupId = groupId
+		booked[i], err = bookRoom(ctx, tx, res)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if groupId > 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE reservation_groups SET master_reservation_id = ? WHERE id = ?", booked[0].ID, groupId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range charges {
+		e.ReservationId = booked[0].ID
+		_, err = tx.ExecContext(ctx, insertFolioEntry, folioEntryValues(e)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range payments {
+		p.ReservationId = booked[0].ID
+		_, err = tx.ExecContext(ctx, insertPayment, paymentValues(p)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return booked, nil
+}
+
+// lockRoom locks the row of a room, so that concurrent bookings of the same room wait for each other,
+// and returns ErrUnavailable when any of the nights of stay are taken
+func lockRoom(ctx context.Context, tx *sql.Tx, roomId int, stay dates.Range) error {
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM rooms WHERE id = ? FOR UPDATE", roomId).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, `SELECT count(id) FROM room_restrictions
+		WHERE room_id = ? and ? < end_date and ? > start_date`, roomId, stay.Start, stay.End).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrUnavailable
+	}
+
+	return nil
+}
+
+// bookRoom inserts a reservation and the restriction holding its room, once the room is free
+func bookRoom(ctx context.Context, tx *sql.Tx, res models.Reservation) (models.Reservation, error) {
+	err := lockRoom(ctx, tx, res.RoomId, res.Stay())
+	if err != nil {
+		return res, err
+	}
+
+	result, err := tx.ExecContext(ctx, insertReservation, reservationValues(res)...)
+	if err != nil {
+		return res, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return res, err
+	}
+	res.ID = int(id)
+
+	_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+		created_at, updated_at, restriction_id) values (?, ?, ?, ?, ?, ?, ?)`,
+		res.StartDate, res.EndDate, res.RoomId, res.ID, time.Now(), time.Now(), 1)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// ImportBookings inserts imported reservations and blocks in one transaction, linking each reservation to
+// the guest profile of its contact details. When a room turns out to be taken nothing is imported, not
+// even the new guest profiles, and the error wraps ErrUnavailable
+func (m *mysqlDBRepo) ImportBookings(reservations []models.Reservation, blocks []models.RoomRestriction) ([]models.Reservation, []models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	booked := make([]models.Reservation, len(reservations))
+	for i, res := range reservations {
+		res.GuestId, err = findOrCreateGuest(ctx, tx, guests.FromReservation(res))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		booked[i], err = bookRoom(ctx, tx, res)
+		if err != nil {
+			return nil, nil, fmt.Errorf("room %d from %s: %w", res.RoomId, res.Stay(), err)
+		}
+	}
+
+	blocked := make([]models.RoomRestriction, len(blocks))
+	for i, b := range blocks {
+		stay := dates.Range{Start: b.StartDate, End: b.EndDate}
+		err = lockRoom(ctx, tx, b.RoomId, stay)
+		if err != nil {
+			return nil, nil, fmt.Errorf("block of room %d from %s: %w", b.RoomId, stay, err)
+		}
+
+		result, err := tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id,
+			created_at, updated_at, restriction_id) values (?, ?, ?, ?, ?, ?)`,
+			b.StartDate, b.EndDate, b.RoomId, time.Now(), time.Now(), b.RestrictionId)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, nil, err
+		}
+		b.ID = int(id)
+		blocked[i] = b
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return booked, blocked, nil
+}
+
+// AllReservationGroups returns the groups booked at a property, with their master reservation and
+// the balance due on its folio
+func (m *mysqlDBRepo) AllReservationGroups(propertyId int) ([]models.ReservationGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var groups []models.ReservationGroup
+
+	query := `SELECT g.id, g.name, coalesce(g.master_reservation_id, 0), g.created_at, g.updated_at,
+		(SELECT count(*) FROM reservations x WHERE x.group_id = g.id),
+		coalesce(r.first_name, ''), coalesce(r.last_name, ''), coalesce(r.start_date, g.created_at),
+		coalesce(r.end_date, g.created_at),
+		coalesce((SELECT sum(case when f.entry_type = 'payment' then -f.amount else f.amount end)
+		FROM folio_entries f WHERE f.reservation_id = r.id), 0)
+	FROM reservation_groups g
+	LEFT JOIN reservations r ON (r.id = g.master_reservation_id)
+	LEFT JOIN rooms rm ON (rm.id = r.room_id)
+	WHERE rm.property_id = ?
+	ORDER BY r.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId)
+	if err != nil {
+		return groups, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.ReservationGroup
+		err := rows.Scan(
+			&g.ID,
+			&g.Name,
+			&g.MasterReservationId,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+			&g.Rooms,
+			&g.Master.FirstName,
+			&g.Master.LastName,
+			&g.Master.StartDate,
+			&g.Master.EndDate,
+			&g.Master.BalanceDue,
+		)
+		if err != nil {
+			return groups, err
+		}
+		g.Master.ID = g.MasterReservationId
+		g.PropertyId = propertyId
+		groups = append(groups, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return groups, err
+	}
+
+	return groups, nil
+}
+
+// GetReservationGroupByID returns a group with all its reservations, the master first
+func (m *mysqlDBRepo) GetReservationGroupByID(id int) (models.ReservationGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var g models.ReservationGroup
+
+	err := m.DB.QueryRowContext(ctx, `SELECT g.id, g.name, coalesce(g.master_reservation_id, 0),
+		coalesce(rm.property_id, 0), g.created_at, g.updated_at
+		FROM reservation_groups g
+		LEFT JOIN reservations r ON (r.id = g.master_reservation_id)
+		LEFT JOIN rooms rm ON (rm.id = r.room_id)
+		WHERE g.id = ?`, id).Scan(
+		&g.ID,
+		&g.Name,
+		&g.MasterReservationId,
+		&g.PropertyId,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
+	if err != nil {
+		return g, err
+	}
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+		r.adults, r.children, r.processed, rm.id, rm.room_name, coalesce(rm.property_id, 0),
+		coalesce((SELECT sum(case when f.entry_type = 'payment' then -f.amount else f.amount end)
+		FROM folio_entries f WHERE f.reservation_id = r.id), 0)
+	FROM reservations r
+	LEFT JOIN rooms rm ON (rm.id = r.room_id)
+	WHERE r.group_id = ?
+	ORDER BY r.id = ? desc, rm.room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, id, g.MasterReservationId)
+	if err != nil {
+		return g, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err := rows.Scan(
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.Email,
+			&r.Phone,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomId,
+			&r.Adults,
+			&r.Children,
+			&r.Processed,
+			&r.Room.ID,
+			&r.Room.RoomName,
+			&r.Room.PropertyId,
+			&r.BalanceDue,
+		)
+		if err != nil {
+			return g, err
+		}
+		r.GroupId = id
+		g.Reservations = append(g.Reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return g, err
+	}
+
+	g.Rooms = len(g.Reservations)
+	if g.Rooms > 0 && g.Reservations[0].ID == g.MasterReservationId {
+		g.Master = g.Reservations[0]
+	}
+
+	return g, nil
+}
+
+// UpdateReservationGroupName renames a group
+func (m *mysqlDBRepo) UpdateReservationGroupName(id int, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE reservation_groups SET name = ?, updated_at = ? WHERE id = ?", name, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateReservationGroup moves a reservation into a group, or out of any group when groupId is 0
+func (m *mysqlDBRepo) UpdateReservationGroup(reservationId, groupId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE reservations SET group_id = ?, updated_at = ? WHERE id = ?",
+		nullInt(groupId), time.Now(), reservationId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveFromReservationGroup takes a reservation out of its group. sql.ErrNoRows is returned when the
+// reservation is not in that group
+func (m *mysqlDBRepo) RemoveFromReservationGroup(reservationId, groupId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "UPDATE reservations SET group_id = NULL, updated_at = ? WHERE id = ? AND group_id = ?",
+		time.Now(), reservationId, groupId)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// InsertReservationNote adds a staff note to a reservation
+func (m *mysqlDBRepo) InsertReservationNote(n models.ReservationNote) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into reservation_notes (reservation_id, user_id, body, created_at, updated_at)
+			values (?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, n.ReservationId, nullInt(n.UserId), n.Body, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetNotesForReservation returns the notes on a reservation with the name of their author, oldest first
+func (m *mysqlDBRepo) GetNotesForReservation(reservationId int) ([]models.ReservationNote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var notes []models.ReservationNote
+
+	query := `SELECT n.id, n.reservation_id, coalesce(n.user_id, 0),
+			trim(concat(coalesce(u.first_name, ''), ' ', coalesce(u.last_name, ''))), n.body, n.created_at
+			FROM reservation_notes n
+			LEFT JOIN users u ON (u.id = n.user_id)
+			WHERE n.reservation_id = ? ORDER BY n.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		return notes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.ReservationNote
+		err := rows.Scan(
+			&n.ID,
+			&n.ReservationId,
+			&n.UserId,
+			&n.Author,
+			&n.Body,
+			&n.CreatedAt,
+		)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return notes, err
+	}
+
+	return notes, nil
+}
+
+// InsertReservationEvent records an event on the timeline of a reservation
+func (m *mysqlDBRepo) InsertReservationEvent(e models.ReservationEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into reservation_events (reservation_id, user_id, event_type, description, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, e.ReservationId, nullInt(e.UserId), e.EventType, e.Description,
+		time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetEventsForReservation returns the events of a reservation with the name of the staff member who
+// caused them, oldest first
+func (m *mysqlDBRepo) GetEventsForReservation(reservationId int) ([]models.ReservationEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var events []models.ReservationEvent
+
+	query := `SELECT e.id, e.reservation_id, coalesce(e.user_id, 0),
+			trim(concat(coalesce(u.first_name, ''), ' ', coalesce(u.last_name, ''))), e.event_type, e.description,
+			e.created_at
+			FROM reservation_events e
+			LEFT JOIN users u ON (u.id = e.user_id)
+			WHERE e.reservation_id = ? ORDER BY e.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.ReservationEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.ReservationId,
+			&e.UserId,
+			&e.Author,
+			&e.EventType,
+			&e.Description,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
+
+// InsertAuditEntry writes an entry to the audit log
+func (m *mysqlDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into audit_log (user_id, action, entity, entity_id, before_json, after_json, ip, created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		nullInt(e.UserId),
+		e.Action,
+		e.Entity,
+		e.EntityId,
+		nullString(e.Before),
+		nullString(e.After),
+		e.IP,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AuditEntries returns the audit log entries matching f, newest first
+func (m *mysqlDBRepo) AuditEntries(f models.AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	query := `SELECT a.id, coalesce(a.user_id, 0), trim(concat(coalesce(u.first_name, ''), ' ', coalesce(u.last_name, ''))),
+			a.action, a.entity, a.entity_id, coalesce(a.before_json, ''), coalesce(a.after_json, ''), a.ip, a.created_at
+			FROM audit_log a
+			LEFT JOIN users u ON (u.id = a.user_id)
+			WHERE (? = 0 OR a.user_id = ?)
+			AND (? = '' OR a.action = ?)
+			AND (? = '' OR a.entity = ?)
+			AND (? = 0 OR a.entity_id = ?)
+			AND (? IS NULL OR a.created_at >= ?)
+			AND (? IS NULL OR a.created_at < ?)
+			ORDER BY a.id desc
+			LIMIT ? OFFSET ?`
+
+	from, to := nullTime(f.From), nullTime(f.To)
+
+	rows, err := m.DB.QueryContext(ctx, query,
+		f.UserId, f.UserId,
+		f.Action, f.Action,
+		f.Entity, f.Entity,
+		f.EntityId, f.EntityId,
+		from, from,
+		to, to,
+		limit, offset,
+	)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.UserId,
+			&e.User,
+			&e.Action,
+			&e.Entity,
+			&e.EntityId,
+			&e.Before,
+			&e.After,
+			&e.IP,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// AllRoomRestrictions returns every row of every room calendar, reservations and blocks alike
+func (m *mysqlDBRepo) AllRoomRestrictions() ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `SELECT id, room_id, coalesce(reservation_id, 0), restriction_id, start_date, end_date
+			FROM room_restrictions ORDER BY room_id, start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomId,
+			&r.ReservationId,
+			&r.RestrictionId,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// AllReservationStays returns the room and dates of every reservation
+func (m *mysqlDBRepo) AllReservationStays() ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, "SELECT id, room_id, start_date, end_date FROM reservations WHERE cancelled_at IS NULL ORDER BY id")
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomId,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// DeleteRoomRestriction removes a row from a room calendar
+func (m *mysqlDBRepo) DeleteRoomRestriction(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM room_restrictions WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetRoomStatus saves the housekeeping status of a room and puts block on its calendar in place of the
+// block it had, when block has a room. The room is locked first, so repository.ErrUnavailable is returned
+// when a stay or another block holds it in the meantime. It returns the id of the new block
+func (m *mysqlDBRepo) SetRoomStatus(room models.Room, block models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var oldBlockId int
+	err = tx.QueryRowContext(ctx, "SELECT coalesce(out_of_order_block_id, 0) FROM rooms WHERE id = ? FOR UPDATE",
+		room.ID).Scan(&oldBlockId)
+	if err != nil {
+		return 0, err
+	}
+
+	if oldBlockId > 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM room_restrictions WHERE id = ?", oldBlockId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	blockId := 0
+	if block.RoomId > 0 {
+		err = lockRoom(ctx, tx, block.RoomId, dates.Range{Start: block.StartDate, End: block.EndDate})
+		if err != nil {
+			return 0, err
+		}
+
+		result, err := tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+			created_at, updated_at) values (?, ?, ?, ?, ?, ?)`,
+			block.StartDate, block.EndDate, block.RoomId, block.RestrictionId, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		blockId = int(id)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE rooms SET housekeeping_status = ?, status_note = ?, out_of_order_until = ?,
+			out_of_order_block_id = ?, updated_at = ?
+			WHERE id = ?`,
+		room.HousekeepingStatus,
+		room.StatusNote,
+		nullTime(room.OutOfOrderUntil),
+		nullInt(blockId),
+		time.Now(),
+		room.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return blockId, nil
+}
+
+// UpdateRoomStatus saves the housekeeping status of a room
+func (m *mysqlDBRepo) UpdateRoomStatus(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE rooms SET housekeeping_status = ?, status_note = ?, out_of_order_until = ?,
+			out_of_order_block_id = ?, updated_at = ?
+			WHERE id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		room.HousekeepingStatus,
+		room.StatusNote,
+		nullTime(room.OutOfOrderUntil),
+		nullInt(room.OutOfOrderBlockId),
+		time.Now(),
+		room.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetStaysForDay returns the reservations of a property that are in house on day or leave that day
+func (m *mysqlDBRepo) GetStaysForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stays []models.Reservation
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.room_id, r.start_date, r.end_date
+			FROM reservations r
+			LEFT JOIN rooms rm ON (rm.id = r.room_id)
+			WHERE rm.property_id = ? AND r.cancelled_at IS NULL AND r.start_date <= ? AND r.end_date >= ?
+			ORDER BY r.end_date, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, day, day)
+	if err != nil {
+		return stays, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err := rows.Scan(
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.RoomId,
+			&r.StartDate,
+			&r.EndDate,
+		)
+		if err != nil {
+			return stays, err
+		}
+		stays = append(stays, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stays, err
+	}
+
+	return stays, nil
+}
+
+// CreateHousekeepingTasks stores the tasks that do not exist yet and returns them. A room has at
+// most one task of each type a day, so planning a day twice adds nothing
+func (m *mysqlDBRepo) CreateHousekeepingTasks(tasks []models.HousekeepingTask) ([]models.HousekeepingTask, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var created []models.HousekeepingTask
+
+	stmt := `insert ignore into housekeeping_tasks (room_id, reservation_id, task_date, task_type, status,
+			created_at, updated_at)
+			values (?, ?, ?, ?, ?, ?, ?)`
+
+	for _, t := range tasks {
+		result, err := m.DB.ExecContext(ctx, stmt,
+			t.RoomId,
+			nullInt(t.ReservationId),
+			t.TaskDate,
+			t.TaskType,
+			t.Status,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return created, err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return created, err
+		}
+		if n == 0 {
+			continue
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return created, err
+		}
+		t.ID = int(id)
+		created = append(created, t)
+	}
+
+	return created, nil
+}
+
+const housekeepingTaskColumns = `t.id, t.room_id, rm.room_name, coalesce(t.reservation_id, 0),
+	trim(concat(coalesce(r.first_name, ''), ' ', coalesce(r.last_name, ''))), t.task_date, t.task_type, t.status,
+	t.completed_at, coalesce(t.completed_by, 0), t.created_at, t.updated_at`
+
+// scanHousekeepingTask reads a row selected with housekeepingTaskColumns
+func scanHousekeepingTask(row interface{ Scan(...interface{}) error }) (models.HousekeepingTask, error) {
+	var t models.HousekeepingTask
+	var completedAt sql.NullTime
+
+	err := row.Scan(
+		&t.ID,
+		&t.RoomId,
+		&t.Room.RoomName,
+		&t.ReservationId,
+		&t.GuestName,
+		&t.TaskDate,
+		&t.TaskType,
+		&t.Status,
+		&completedAt,
+		&t.CompletedBy,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	t.Room.ID = t.RoomId
+	t.CompletedAt = completedAt.Time
+
+	return t, err
+}
+
+// HousekeepingTasksForDay returns the tasks of a property on day, by room
+func (m *mysqlDBRepo) HousekeepingTasksForDay(propertyId int, day time.Time) ([]models.HousekeepingTask, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var tasks []models.HousekeepingTask
+
+	query := `SELECT ` + housekeepingTaskColumns + `
+			FROM housekeeping_tasks t
+			LEFT JOIN rooms rm ON (rm.id = t.room_id)
+			LEFT JOIN reservations r ON (r.id = t.reservation_id)
+			WHERE rm.property_id = ? AND t.task_date = ?
+			ORDER BY rm.room_name, t.task_type`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, day)
+	if err != nil {
+		return tasks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanHousekeepingTask(rows)
+		if err != nil {
+			return tasks, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return tasks, err
+	}
+
+	return tasks, nil
+}
+
+// GetHousekeepingTask returns a task by id
+func (m *mysqlDBRepo) GetHousekeepingTask(id int) (models.HousekeepingTask, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + housekeepingTaskColumns + `
+			FROM housekeeping_tasks t
+			LEFT JOIN rooms rm ON (rm.id = t.room_id)
+			LEFT JOIN reservations r ON (r.id = t.reservation_id)
+			WHERE t.id = ?`
+
+	return scanHousekeepingTask(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// CompleteHousekeepingTask marks a task done by a staff member
+func (m *mysqlDBRepo) CompleteHousekeepingTask(id, userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE housekeeping_tasks SET status = 'done', completed_at = ?, completed_by = ?, updated_at = ?
+			WHERE id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), nullInt(userId), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// frontDeskReservations returns the reservations of a property matching where, with what the front desk
+// needs to check guests in and out
+func (m *mysqlDBRepo) frontDeskReservations(where string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `SELECT r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+			r.adults, r.children, coalesce(r.group_id, 0), r.checked_in_at, r.checked_out_at,
+			rm.id, rm.room_name, rm.housekeeping_status,
+			coalesce((SELECT sum(case when f.entry_type = 'payment' then -f.amount else f.amount end)
+			FROM folio_entries f WHERE f.reservation_id = r.id), 0) as balance_due
+			FROM reservations r
+			LEFT JOIN rooms rm ON (r.room_id = rm.id)
+			WHERE rm.property_id = ? AND r.cancelled_at IS NULL AND ` + where + `
+			ORDER BY rm.room_name, r.last_name, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		var checkedIn, checkedOut sql.NullTime
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomId,
+			&i.Adults,
+			&i.Children,
+			&i.GroupId,
+			&checkedIn,
+			&checkedOut,
+			&i.Room.ID,
+			&i.Room.RoomName,
+			&i.Room.HousekeepingStatus,
+			&i.BalanceDue,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		i.CheckedInAt = checkedIn.Time
+		i.CheckedOutAt = checkedOut.Time
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// ArrivalsForDay returns the reservations of a property arriving on day
+func (m *mysqlDBRepo) ArrivalsForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	return m.frontDeskReservations("r.start_date = ?", propertyId, day)
+}
+
+// DeparturesForDay returns the reservations of a property leaving on day
+func (m *mysqlDBRepo) DeparturesForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	return m.frontDeskReservations("r.end_date = ?", propertyId, day)
+}
+
+// InHouseForDay returns the reservations of a property staying the night of day
+func (m *mysqlDBRepo) InHouseForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	return m.frontDeskReservations("r.start_date <= ? AND r.end_date > ?", propertyId, day, day)
+}
+
+// CheckInReservation records when the guests of a reservation checked in
+func (m *mysqlDBRepo) CheckInReservation(id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE reservations SET checked_in_at = ?, updated_at = ? WHERE id = ?", at, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CheckOutReservation records when the guests of a reservation checked out
+func (m *mysqlDBRepo) CheckOutReservation(id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE reservations SET checked_out_at = ?, updated_at = ? WHERE id = ?", at, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReportStays returns the reservations of a property, cancelled ones included, with a night in period,
+// along with the net room revenue on their folios
+func (m *mysqlDBRepo) ReportStays(propertyId int, period dates.Range) ([]models.ReportStay, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var stays []models.ReportStay
+
+	query := `SELECT r.id, r.room_id, coalesce(r.group_id, 0), r.start_date, r.end_date, r.created_at, r.cancelled_at,
+			coalesce((SELECT sum(f.amount) FROM folio_entries f
+			WHERE f.reservation_id = r.id AND f.entry_type = 'charge' AND f.category IN ('room', 'discount')), 0)
+			FROM reservations r
+			LEFT JOIN rooms rm ON (rm.id = r.room_id)
+			WHERE rm.property_id = ? AND r.start_date < ? AND r.end_date > ?
+			ORDER BY r.start_date, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, period.End, period.Start)
+	if err != nil {
+		return stays, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.ReportStay
+		var cancelled sql.NullTime
+		err := rows.Scan(
+			&s.ReservationId,
+			&s.RoomId,
+			&s.GroupId,
+			&s.StartDate,
+			&s.EndDate,
+			&s.CreatedAt,
+			&cancelled,
+			&s.Revenue,
+		)
+		if err != nil {
+			return stays, err
+		}
+		s.CancelledAt = cancelled.Time
+		stays = append(stays, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stays, err
+	}
+
+	return stays, nil
+}
+
+// BlocksForProperty returns the blocks and out of order periods of the rooms of a property overlapping period
+func (m *mysqlDBRepo) BlocksForProperty(propertyId int, period dates.Range) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var blocks []models.RoomRestriction
+
+	query := `SELECT rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date
+			FROM room_restrictions rr
+			LEFT JOIN rooms rm ON (rm.id = rr.room_id)
+			WHERE rm.property_id = ? AND rr.reservation_id IS NULL AND rr.start_date < ? AND rr.end_date > ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, period.End, period.Start)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b models.RoomRestriction
+		err := rows.Scan(
+			&b.ID,
+			&b.RoomId,
+			&b.RestrictionId,
+			&b.StartDate,
+			&b.EndDate,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return blocks, err
+	}
+
+	return blocks, nil
+}
+
+const icalSourceColumns = `s.id, s.room_id, rm.room_name, rm.property_id, s.name, s.url, s.last_synced_at, s.last_status,
+	coalesce(s.last_message, ''), s.created_at, s.updated_at`
+
+// scanICalSource reads a row selected with icalSourceColumns
+func scanICalSource(row interface{ Scan(...interface{}) error }) (models.ICalSource, error) {
+	var s models.ICalSource
+	var syncedAt sql.NullTime
+
+	err := row.Scan(
+		&s.ID,
+		&s.RoomId,
+		&s.Room.RoomName,
+		&s.Room.PropertyId,
+		&s.Name,
+		&s.URL,
+		&syncedAt,
+		&s.LastStatus,
+		&s.LastMessage,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	s.Room.ID = s.RoomId
+	s.LastSyncedAt = syncedAt.Time
+
+	return s, err
+}
+
+// AllICalSources returns the outside calendars of the rooms of a property
+func (m *mysqlDBRepo) AllICalSources(propertyId int) ([]models.ICalSource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sources []models.ICalSource
+
+	query := `SELECT ` + icalSourceColumns + `
+			FROM ical_sources s
+			LEFT JOIN rooms rm ON (rm.id = s.room_id)
+			WHERE rm.property_id = ?
+			ORDER BY rm.room_name, s.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId)
+	if err != nil {
+		return sources, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanICalSource(rows)
+		if err != nil {
+			return sources, err
+		}
+		sources = append(sources, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sources, err
+	}
+
+	return sources, nil
+}
+
+// GetICalSourceByID returns an outside calendar
+func (m *mysqlDBRepo) GetICalSourceByID(id int) (models.ICalSource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + icalSourceColumns + `
+			FROM ical_sources s
+			LEFT JOIN rooms rm ON (rm.id = s.room_id)
+			WHERE s.id = ?`
+
+	return scanICalSource(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertICalSource adds an outside calendar to a room and returns its id
+func (m *mysqlDBRepo) InsertICalSource(s models.ICalSource) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO ical_sources (room_id, name, url, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, s.RoomId, s.Name, s.URL, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// DeleteICalSource removes an outside calendar and releases the blocks synced from it
+func (m *mysqlDBRepo) DeleteICalSource(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM room_restrictions WHERE ical_source_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM ical_sources WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ExternalBlocks returns the blocks synced from an outside calendar
+func (m *mysqlDBRepo) ExternalBlocks(sourceId int) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var blocks []models.RoomRestriction
+
+	query := `SELECT id, room_id, restriction_id, start_date, end_date, ical_source_id, external_uid
+			FROM room_restrictions WHERE ical_source_id = ?
+			ORDER BY start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, sourceId)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b models.RoomRestriction
+		err := rows.Scan(
+			&b.ID,
+			&b.RoomId,
+			&b.RestrictionId,
+			&b.StartDate,
+			&b.EndDate,
+			&b.ICalSourceId,
+			&b.ExternalUID,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return blocks, err
+	}
+
+	return blocks, nil
+}
+
+// ApplyCalendarSync adds, moves and removes the blocks of an outside calendar in one transaction
+func (m *mysqlDBRepo) ApplyCalendarSync(sourceId int, add, update []models.RoomRestriction, remove []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, b := range add {
+		_, err = tx.ExecContext(ctx, `INSERT INTO room_restrictions (start_date, end_date, room_id, restriction_id,
+			ical_source_id, external_uid, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			b.StartDate, b.EndDate, b.RoomId, b.RestrictionId, sourceId, b.ExternalUID, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, b := range update {
+		_, err = tx.ExecContext(ctx, `UPDATE room_restrictions SET start_date = ?, end_date = ?, updated_at = ?
+			WHERE id = ? AND ical_source_id = ?`,
+			b.StartDate, b.EndDate, time.Now(), b.ID, sourceId)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range remove {
+		_, err = tx.ExecContext(ctx, "DELETE FROM room_restrictions WHERE id = ? AND ical_source_id = ?", id, sourceId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// InsertICalSyncLog logs a sync and keeps its outcome on the source
+func (m *mysqlDBRepo) InsertICalSyncLog(entry models.ICalSyncLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO ical_sync_logs (ical_source_id, status, added, updated, removed, message,
+		created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ICalSourceId, entry.Status, entry.Added, entry.Updated, entry.Removed, nullString(entry.Message),
+		time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE ical_sources SET last_synced_at = ?, last_status = ?, last_message = ?,
+		updated_at = ? WHERE id = ?`,
+		time.Now(), entry.Status, nullString(entry.Message), time.Now(), entry.ICalSourceId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ICalSyncLogs returns the latest syncs of the outside calendars of a property, newest first
+func (m *mysqlDBRepo) ICalSyncLogs(propertyId, limit int) ([]models.ICalSyncLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var logs []models.ICalSyncLog
+
+	query := `SELECT l.id, l.ical_source_id, s.name, rm.room_name, l.status, l.added, l.updated, l.removed,
+			coalesce(l.message, ''), l.created_at
+			FROM ical_sync_logs l
+			LEFT JOIN ical_sources s ON (s.id = l.ical_source_id)
+			LEFT JOIN rooms rm ON (rm.id = s.room_id)
+			WHERE rm.property_id = ?
+			ORDER BY l.created_at DESC, l.id DESC
+			LIMIT ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyId, limit)
+	if err != nil {
+		return logs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.ICalSyncLog
+		err := rows.Scan(
+			&l.ID,
+			&l.ICalSourceId,
+			&l.SourceName,
+			&l.RoomName,
+			&l.Status,
+			&l.Added,
+			&l.Updated,
+			&l.Removed,
+			&l.Message,
+			&l.CreatedAt,
+		)
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return logs, err
+	}
+
+	return logs, nil
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, rate_limit, last_used_at, revoked_at, created_at, updated_at`
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	var usedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&k.ID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&scopes,
+		&k.RateLimit,
+		&usedAt,
+		&revokedAt,
+		&k.CreatedAt,
+		&k.UpdatedAt,
+	)
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	k.LastUsedAt = usedAt.Time
+	k.RevokedAt = revokedAt.Time
+
+	return k, err
+}
+
+// AllAPIKeys returns the API keys, newest first, revoked ones included
+func (m *mysqlDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var keys []models.APIKey
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByID returns an API key
+func (m *mysqlDBRepo) GetAPIKeyByID(id int) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id)
+	return scanAPIKey(row)
+}
+
+// GetAPIKeyByHash returns the API key with the hash given
+func (m *mysqlDBRepo) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, hash)
+	return scanAPIKey(row)
+}
+
+// InsertAPIKey stores a new API key
+func (m *mysqlDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `INSERT INTO api_keys (name, prefix, key_hash, scopes, rate_limit, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, k.Name, k.Prefix, k.KeyHash, strings.Join(k.Scopes, ","),
+		k.RateLimit, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// RevokeAPIKey stops an API key from being used. A key revoked before keeps its first revocation time
+func (m *mysqlDBRepo) RevokeAPIKey(id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `UPDATE api_keys SET revoked_at = ?, updated_at = ? WHERE id = ? AND revoked_at IS NULL`
+
+	_, err := m.DB.ExecContext(ctx, stmt, at, time.Now(), id)
+	return err
+}
+
+// TouchAPIKey records when an API key was last used
+func (m *mysqlDBRepo) TouchAPIKey(id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, at, id)
+	return err
+}
@@ -1,16 +1,17 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
 	"time"
 
+	"github.com/eldicela/bookings/internal/apikeys"
+	"github.com/eldicela/bookings/internal/dates"
 	"github.com/eldicela/bookings/internal/models"
+	"github.com/eldicela/bookings/internal/promo"
+	"github.com/eldicela/bookings/internal/repository"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
-}
-
 // InsertReservtion Inserts a reservation into database
 func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 
@@ -30,28 +31,52 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// testLastFreeDay is the last day rooms are free on, stays starting after it are booked out
+var testLastFreeDay = time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// testFailingDay is the arrival the availability searches fail for
+var testFailingDay = time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC)
+
 // SearchAvailabilityByDatesByRoomID Returns true if availability exist for roomId and false if no availability
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(stay dates.Range, roomId int) (bool, error) {
+	if stay.Start.Equal(testFailingDay) {
+		return false, errors.New("some error")
+	}
 
-	return false, nil
+	return !stay.Start.After(testLastFreeDay), nil
 }
 
 // SearchAvailabilityForAllRooms return a slice of available rooms, if any, for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(stay dates.Range, guests, propertyId int) ([]models.Room, error) {
 
 	var rooms []models.Room
 
+	if stay.Start.Equal(testFailingDay) {
+		return rooms, errors.New("some error")
+	}
+
+	if !stay.Start.After(testLastFreeDay) {
+		room, _ := m.GetRoomByID(1)
+		rooms = append(rooms, room)
+	}
+
 	return rooms, nil
 }
 
-// GetRoomById Gets a room by id
+// GetRoomById Gets a room by id. Rooms 1 to 3 belong to property 1, room 3 is always taken by the time
+// it is booked
 func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
 
-	if id > 2 {
+	if id > 3 {
 		return room, errors.New("some error")
 	}
 
+	room.ID = id
+	room.RoomName = "General's Quarters"
+	room.Price = 10000
+	room.PropertyId = 1
+
 	return room, nil
 }
 
@@ -70,32 +95,46 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, erro
 	return 1, "", nil
 }
 
-// AllReservations Returns a slice of all reservations
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-
-	var reservations []models.Reservation
+func (m *testDBRepo) EachReservation(propertyId int, f models.ReservationFilter, fn func(models.Reservation) error) error {
+	return nil
+}
 
-	return reservations, nil
+func (m *testDBRepo) ReservationsPage(propertyId int, f models.ReservationFilter, offset, limit int) ([]models.Reservation, int, error) {
+	return nil, 0, nil
 }
 
-// AllNewReservations Returns a slice of all reservations
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+// GetReservationById returns one reservation by ID
+func (m *testDBRepo) GetReservationById(id int) (models.Reservation, error) {
 
-	var reservations []models.Reservation
+	res := models.Reservation{ID: id, RoomId: 1}
+	res.Room, _ = m.GetRoomByID(1)
 
-	return reservations, nil
+	return res, nil
 }
 
-// GetReservationById returns one reservation by ID
-func (m *testDBRepo) GetReservationById(id int) (models.Reservation, error) {
+// GetReservationByManageToken returns the reservation a guest manage link points to
+func (m *testDBRepo) GetReservationByManageToken(token string) (models.Reservation, error) {
 
 	var res models.Reservation
 
+	if token == "" {
+		return res, errors.New("no reservation")
+	}
+
 	return res, nil
 }
 
-// UpdateReservation updates a user in the database
+// GetReservationByChannelRef returns the reservation booked from a channel booking
+func (m *testDBRepo) GetReservationByChannelRef(channel, ref string) (models.Reservation, error) {
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+// UpdateReservation updates a user in the database. Reservation 2 has been saved by someone else since
+// it was read
 func (m *testDBRepo) UpdateReservation(u models.Reservation) error {
+	if u.ID == 2 {
+		return &repository.ConflictError{Entity: "reservation", ID: u.ID, Version: u.Version}
+	}
 
 	return nil
 }
@@ -106,12 +145,16 @@ func (m *testDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
+func (m *testDBRepo) CancelReservation(id int, at time.Time) error {
+	return nil
+}
+
 func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 
 	return nil
 }
 
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+func (m *testDBRepo) AllRooms(propertyId int) ([]models.Room, error) {
 
 	var rooms []models.Room
 
@@ -120,7 +163,7 @@ func (m *testDBRepo) AllRooms() ([]models.Room, error) {
 }
 
 // GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+func (m *testDBRepo) GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error) {
 
 	var restrictions []models.RoomRestriction
 
@@ -128,9 +171,9 @@ func (m *testDBRepo) GetRestrictionsForRoomByDate(roomId int, start, end time.Ti
 }
 
 // InsertBlockForRoom inserts a room restriction
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) (int, error) {
 
-	return nil
+	return 1, nil
 }
 
 // InsertBlockForRoom deletes a room restriction
@@ -138,3 +181,489 @@ func (m *testDBRepo) DeleteBlockById(id int) error {
 
 	return nil
 }
+
+func (m *testDBRepo) InsertPayment(p models.Payment) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) GetPaymentsForReservation(reservationId int) ([]models.Payment, error) {
+	var payments []models.Payment
+
+	return payments, nil
+}
+
+func (m *testDBRepo) GetPaymentById(id int) (models.Payment, error) {
+	var p models.Payment
+
+	return p, nil
+}
+
+func (m *testDBRepo) UpdatePaymentStatus(transactionId, status string) error {
+	return nil
+}
+
+func (m *testDBRepo) RefundPayment(paymentId int, status string, e models.FolioEntry) error {
+	return nil
+}
+
+func (m *testDBRepo) InsertFolioEntry(e models.FolioEntry) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) GetFolioEntriesForReservation(reservationId int) ([]models.FolioEntry, error) {
+	var entries []models.FolioEntry
+
+	return entries, nil
+}
+
+func (m *testDBRepo) AllPromoCodes(propertyId int) ([]models.PromoCode, error) {
+	var codes []models.PromoCode
+
+	return codes, nil
+}
+
+func (m *testDBRepo) GetPromoCodeByCode(propertyId int, code string) (models.PromoCode, error) {
+	var p models.PromoCode
+
+	if code != "TEST" {
+		return p, promo.ErrUnknownCode
+	}
+
+	p.ID = 1
+	p.Code = code
+	p.DiscountType = promo.TypePercent
+	p.Amount = 10
+	p.Active = 1
+	p.PropertyId = 1
+
+	return p, nil
+}
+
+func (m *testDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdatePromoCodeActive(propertyId, id, active int) error {
+	return nil
+}
+
+func (m *testDBRepo) RedeemPromoCode(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) ReleasePromoCode(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) AllTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error) {
+	var rules []models.TaxFeeRule
+
+	return rules, nil
+}
+
+func (m *testDBRepo) ActiveTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error) {
+	var rules []models.TaxFeeRule
+
+	return rules, nil
+}
+
+func (m *testDBRepo) InsertTaxFeeRule(rule models.TaxFeeRule) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateTaxFeeRuleActive(propertyId, id, active int) error {
+	return nil
+}
+
+func (m *testDBRepo) TaxFeeReport(from, to time.Time, propertyId int) ([]models.TaxFeeTotal, error) {
+	var totals []models.TaxFeeTotal
+
+	return totals, nil
+}
+
+func (m *testDBRepo) AllRoomTypes(propertyId int) ([]models.RoomType, error) {
+	var types []models.RoomType
+
+	return types, nil
+}
+
+func (m *testDBRepo) InsertRoomType(t models.RoomType) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) GetRoomsByType(propertyId, roomTypeId int) ([]models.Room, error) {
+	var rooms []models.Room
+
+	if roomTypeId > 2 {
+		return rooms, errors.New("some error")
+	}
+
+	rooms = append(rooms, models.Room{ID: roomTypeId, RoomTypeId: roomTypeId})
+
+	return rooms, nil
+}
+
+func (m *testDBRepo) UpdateRoomType(roomId, roomTypeId int) error {
+	return nil
+}
+
+func (m *testDBRepo) UpdateRoomICalToken(roomId int, token string) error {
+	return nil
+}
+
+func (m *testDBRepo) ReassignReservation(res models.Reservation, roomId int) error {
+	if roomId > 2 {
+		return repository.ErrUnavailable
+	}
+	return nil
+}
+
+func (m *testDBRepo) AllProperties() ([]models.Property, error) {
+	var properties []models.Property
+
+	properties = append(properties, models.Property{ID: 1, Name: "Test Property", Slug: "test"})
+
+	return properties, nil
+}
+
+func (m *testDBRepo) PropertiesForUser(userId int) ([]models.Property, error) {
+	return m.AllProperties()
+}
+
+func (m *testDBRepo) GetPropertyByID(id int) (models.Property, error) {
+	if id > 1 {
+		return models.Property{}, errors.New("some error")
+	}
+
+	return models.Property{ID: 1, Name: "Test Property", Slug: "test", Email: "test@example.com"}, nil
+}
+
+func (m *testDBRepo) GetPropertyBySlug(slug string) (models.Property, error) {
+	if slug != "test" {
+		return models.Property{}, sql.ErrNoRows
+	}
+
+	return m.GetPropertyByID(1)
+}
+
+func (m *testDBRepo) GetDefaultProperty() (models.Property, error) {
+	return m.GetPropertyByID(1)
+}
+
+func (m *testDBRepo) InsertProperty(p models.Property) (int, error) {
+	return 2, nil
+}
+
+func (m *testDBRepo) UpdateProperty(p models.Property) error {
+	return nil
+}
+
+func (m *testDBRepo) AllUsers() ([]models.User, error) {
+	var users []models.User
+
+	return users, nil
+}
+
+func (m *testDBRepo) GetPropertyUserIds(propertyId int) ([]int, error) {
+	var ids []int
+
+	return ids, nil
+}
+
+func (m *testDBRepo) UpdatePropertyUsers(propertyId int, userIds []int) error {
+	return nil
+}
+
+func (m *testDBRepo) FindOrCreateGuest(g models.Guest) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) AllGuests(search string) ([]models.Guest, error) {
+	var list []models.Guest
+
+	return list, nil
+}
+
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	if id > 1 {
+		return models.Guest{}, sql.ErrNoRows
+	}
+
+	return models.Guest{ID: 1}, nil
+}
+
+func (m *testDBRepo) UpdateGuest(g models.Guest) error {
+	return nil
+}
+
+func (m *testDBRepo) GetReservationsForGuest(guestId int) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+func (m *testDBRepo) MergeGuests(keepId, duplicateId int) error {
+	return nil
+}
+
+func (m *testDBRepo) CreateReservations(name string, lines []models.Reservation, charges []models.FolioEntry, payments []models.Payment) ([]models.Reservation, error) {
+	booked := make([]models.Reservation, len(lines))
+	for i, res := range lines {
+		if res.RoomId > 2 {
+			return nil, repository.ErrUnavailable
+		}
+		res.ID = i + 1
+		booked[i] = res
+	}
+
+	return booked, nil
+}
+
+func (m *testDBRepo) ImportBookings(reservations []models.Reservation, blocks []models.RoomRestriction) ([]models.Reservation, []models.RoomRestriction, error) {
+	booked := make([]models.Reservation, len(reservations))
+	for i, res := range reservations {
+		if res.RoomId > 2 {
+			return nil, nil, repository.ErrUnavailable
+		}
+		res.ID = i + 1
+		booked[i] = res
+	}
+
+	return booked, blocks, nil
+}
+
+func (m *testDBRepo) AllReservationGroups(propertyId int) ([]models.ReservationGroup, error) {
+	var groups []models.ReservationGroup
+
+	return groups, nil
+}
+
+func (m *testDBRepo) GetReservationGroupByID(id int) (models.ReservationGroup, error) {
+	if id > 1 {
+		return models.ReservationGroup{}, sql.ErrNoRows
+	}
+
+	return models.ReservationGroup{ID: 1, PropertyId: 1}, nil
+}
+
+func (m *testDBRepo) UpdateReservationGroupName(id int, name string) error {
+	return nil
+}
+
+func (m *testDBRepo) UpdateReservationGroup(reservationId, groupId int) error {
+	return nil
+}
+
+func (m *testDBRepo) RemoveFromReservationGroup(reservationId, groupId int) error {
+	return nil
+}
+
+func (m *testDBRepo) InsertReservationNote(n models.ReservationNote) error {
+	return nil
+}
+
+func (m *testDBRepo) GetNotesForReservation(reservationId int) ([]models.ReservationNote, error) {
+	var notes []models.ReservationNote
+
+	return notes, nil
+}
+
+func (m *testDBRepo) InsertReservationEvent(e models.ReservationEvent) error {
+	return nil
+}
+
+func (m *testDBRepo) GetEventsForReservation(reservationId int) ([]models.ReservationEvent, error) {
+	var events []models.ReservationEvent
+
+	return events, nil
+}
+
+func (m *testDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	return nil
+}
+
+func (m *testDBRepo) AuditEntries(f models.AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+
+	return entries, nil
+}
+
+func (m *testDBRepo) AllRoomRestrictions() ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+
+	return restrictions, nil
+}
+
+func (m *testDBRepo) AllReservationStays() ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+func (m *testDBRepo) DeleteRoomRestriction(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) SetRoomStatus(room models.Room, block models.RoomRestriction) (int, error) {
+	if block.RoomId > 2 {
+		return 0, repository.ErrUnavailable
+	}
+	if block.RoomId == 0 {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateRoomStatus(room models.Room) error {
+	return nil
+}
+
+func (m *testDBRepo) GetStaysForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	var stays []models.Reservation
+
+	return stays, nil
+}
+
+func (m *testDBRepo) CreateHousekeepingTasks(tasks []models.HousekeepingTask) ([]models.HousekeepingTask, error) {
+	return tasks, nil
+}
+
+func (m *testDBRepo) HousekeepingTasksForDay(propertyId int, day time.Time) ([]models.HousekeepingTask, error) {
+	var tasks []models.HousekeepingTask
+
+	return tasks, nil
+}
+
+func (m *testDBRepo) GetHousekeepingTask(id int) (models.HousekeepingTask, error) {
+	if id > 1 {
+		return models.HousekeepingTask{}, sql.ErrNoRows
+	}
+
+	return models.HousekeepingTask{ID: 1, RoomId: 1, TaskType: "departure", Status: "open"}, nil
+}
+
+func (m *testDBRepo) CompleteHousekeepingTask(id, userId int) error {
+	return nil
+}
+
+func (m *testDBRepo) ArrivalsForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+func (m *testDBRepo) DeparturesForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+func (m *testDBRepo) InHouseForDay(propertyId int, day time.Time) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+func (m *testDBRepo) CheckInReservation(id int, at time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) CheckOutReservation(id int, at time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) ReportStays(propertyId int, period dates.Range) ([]models.ReportStay, error) {
+	var stays []models.ReportStay
+
+	return stays, nil
+}
+
+func (m *testDBRepo) BlocksForProperty(propertyId int, period dates.Range) ([]models.RoomRestriction, error) {
+	var blocks []models.RoomRestriction
+
+	return blocks, nil
+}
+
+func (m *testDBRepo) AllICalSources(propertyId int) ([]models.ICalSource, error) {
+	var sources []models.ICalSource
+
+	return sources, nil
+}
+
+func (m *testDBRepo) GetICalSourceByID(id int) (models.ICalSource, error) {
+	var s models.ICalSource
+
+	if id > 2 {
+		return s, errors.New("some error")
+	}
+
+	return s, nil
+}
+
+func (m *testDBRepo) InsertICalSource(s models.ICalSource) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteICalSource(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) ExternalBlocks(sourceId int) ([]models.RoomRestriction, error) {
+	var blocks []models.RoomRestriction
+
+	return blocks, nil
+}
+
+func (m *testDBRepo) ApplyCalendarSync(sourceId int, add, update []models.RoomRestriction, remove []int) error {
+	return nil
+}
+
+func (m *testDBRepo) InsertICalSyncLog(entry models.ICalSyncLog) error {
+	return nil
+}
+
+func (m *testDBRepo) ICalSyncLogs(propertyId, limit int) ([]models.ICalSyncLog, error) {
+	var logs []models.ICalSyncLog
+
+	return logs, nil
+}
+
+func (m *testDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+
+	return keys, nil
+}
+
+func (m *testDBRepo) GetAPIKeyByID(id int) (models.APIKey, error) {
+	var k models.APIKey
+
+	return k, nil
+}
+
+// GetAPIKeyByHash finds a key for each scope, the key being the name of its scope
+func (m *testDBRepo) GetAPIKeyByHash(hash string) (models.APIKey, error) {
+	var k models.APIKey
+
+	for i, scope := range apikeys.Scopes {
+		if hash == apikeys.Hash(scope) {
+			k.ID = i + 1
+			k.Name = scope
+			k.Scopes = []string{scope}
+			return k, nil
+		}
+	}
+
+	return k, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) RevokeAPIKey(id int, at time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) TouchAPIKey(id int, at time.Time) error {
+	return nil
+}
@@ -1,29 +1,148 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/eldicela/bookings/internal/dates"
 	"github.com/eldicela/bookings/internal/models"
 )
 
-type DatabaseRepo interface {
-	AllUsers() bool
+// ErrUnavailable is returned when a room is booked for a night it is already taken
+var ErrUnavailable = errors.New("room is not available for these dates")
+
+// ConflictError is returned when a record is saved from a stale copy, someone else having saved it
+// since Version was read
+type ConflictError struct {
+	Entity  string
+	ID      int
+	Version int
+}
 
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %d was changed by someone else since version %d", e.Entity, e.ID, e.Version)
+}
+
+type DatabaseRepo interface {
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	SearchAvailabilityByDatesByRoomID(stay dates.Range, roomId int) (bool, error)
+	SearchAvailabilityForAllRooms(stay dates.Range, guests, propertyId int) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
 	GetUserByID(id int) (models.User, error)
 	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	EachReservation(propertyId int, f models.ReservationFilter, fn func(models.Reservation) error) error
+	ReservationsPage(propertyId int, f models.ReservationFilter, offset, limit int) ([]models.Reservation, int, error)
 	GetReservationById(id int) (models.Reservation, error)
+	GetReservationByManageToken(token string) (models.Reservation, error)
+	GetReservationByChannelRef(channel, ref string) (models.Reservation, error)
 	UpdateReservation(u models.Reservation) error
 	DeleteReservation(id int) error
+	CancelReservation(id int, at time.Time) error
 	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
+	AllRooms(propertyId int) ([]models.Room, error)
+	GetRestrictionsForRoomByDate(roomId int, period dates.Range) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(id int, startDate time.Time) (int, error)
 	DeleteBlockById(id int) error
+
+	InsertPayment(p models.Payment) (int, error)
+	GetPaymentsForReservation(reservationId int) ([]models.Payment, error)
+	GetPaymentById(id int) (models.Payment, error)
+	UpdatePaymentStatus(transactionId, status string) error
+	RefundPayment(paymentId int, status string, e models.FolioEntry) error
+
+	InsertFolioEntry(e models.FolioEntry) (int, error)
+	GetFolioEntriesForReservation(reservationId int) ([]models.FolioEntry, error)
+
+	AllPromoCodes(propertyId int) ([]models.PromoCode, error)
+	GetPromoCodeByCode(propertyId int, code string) (models.PromoCode, error)
+	InsertPromoCode(p models.PromoCode) (int, error)
+	UpdatePromoCodeActive(propertyId, id, active int) error
+	RedeemPromoCode(id int) error
+	ReleasePromoCode(id int) error
+
+	AllTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error)
+	ActiveTaxFeeRules(propertyId int) ([]models.TaxFeeRule, error)
+	InsertTaxFeeRule(rule models.TaxFeeRule) (int, error)
+	UpdateTaxFeeRuleActive(propertyId, id, active int) error
+	TaxFeeReport(from, to time.Time, propertyId int) ([]models.TaxFeeTotal, error)
+
+	AllRoomTypes(propertyId int) ([]models.RoomType, error)
+	InsertRoomType(t models.RoomType) (int, error)
+	GetRoomsByType(propertyId, roomTypeId int) ([]models.Room, error)
+	UpdateRoomType(roomId, roomTypeId int) error
+	UpdateRoomICalToken(roomId int, token string) error
+	ReassignReservation(res models.Reservation, roomId int) error
+
+	AllProperties() ([]models.Property, error)
+	PropertiesForUser(userId int) ([]models.Property, error)
+	GetPropertyByID(id int) (models.Property, error)
+	GetPropertyBySlug(slug string) (models.Property, error)
+	GetDefaultProperty() (models.Property, error)
+	InsertProperty(p models.Property) (int, error)
+	UpdateProperty(p models.Property) error
+	AllUsers() ([]models.User, error)
+	GetPropertyUserIds(propertyId int) ([]int, error)
+	UpdatePropertyUsers(propertyId int, userIds []int) error
+
+	FindOrCreateGuest(g models.Guest) (int, error)
+	AllGuests(search string) ([]models.Guest, error)
+	GetGuestByID(id int) (models.Guest, error)
+	UpdateGuest(g models.Guest) error
+	GetReservationsForGuest(guestId int) ([]models.Reservation, error)
+	MergeGuests(keepId, duplicateId int) error
+
+	CreateReservations(name string, lines []models.Reservation, charges []models.FolioEntry, payments []models.Payment) ([]models.Reservation, error)
+	ImportBookings(reservations []models.Reservation, blocks []models.RoomRestriction) ([]models.Reservation, []models.RoomRestriction, error)
+	AllReservationGroups(propertyId int) ([]models.ReservationGroup, error)
+	GetReservationGroupByID(id int) (models.ReservationGroup, error)
+	UpdateReservationGroupName(id int, name string) error
+	UpdateReservationGroup(reservationId, groupId int) error
+	RemoveFromReservationGroup(reservationId, groupId int) error
+
+	InsertReservationNote(n models.ReservationNote) error
+	GetNotesForReservation(reservationId int) ([]models.ReservationNote, error)
+	InsertReservationEvent(e models.ReservationEvent) error
+	GetEventsForReservation(reservationId int) ([]models.ReservationEvent, error)
+
+	InsertAuditEntry(e models.AuditEntry) error
+	AuditEntries(f models.AuditFilter, limit, offset int) ([]models.AuditEntry, error)
+
+	AllRoomRestrictions() ([]models.RoomRestriction, error)
+	AllReservationStays() ([]models.Reservation, error)
+	DeleteRoomRestriction(id int) error
+
+	SetRoomStatus(room models.Room, block models.RoomRestriction) (int, error)
+	UpdateRoomStatus(room models.Room) error
+	GetStaysForDay(propertyId int, day time.Time) ([]models.Reservation, error)
+	CreateHousekeepingTasks(tasks []models.HousekeepingTask) ([]models.HousekeepingTask, error)
+	HousekeepingTasksForDay(propertyId int, day time.Time) ([]models.HousekeepingTask, error)
+	GetHousekeepingTask(id int) (models.HousekeepingTask, error)
+	CompleteHousekeepingTask(id, userId int) error
+
+	ArrivalsForDay(propertyId int, day time.Time) ([]models.Reservation, error)
+	DeparturesForDay(propertyId int, day time.Time) ([]models.Reservation, error)
+	InHouseForDay(propertyId int, day time.Time) ([]models.Reservation, error)
+	CheckInReservation(id int, at time.Time) error
+	CheckOutReservation(id int, at time.Time) error
+
+	ReportStays(propertyId int, period dates.Range) ([]models.ReportStay, error)
+	BlocksForProperty(propertyId int, period dates.Range) ([]models.RoomRestriction, error)
+
+	AllICalSources(propertyId int) ([]models.ICalSource, error)
+	GetICalSourceByID(id int) (models.ICalSource, error)
+	InsertICalSource(s models.ICalSource) (int, error)
+	DeleteICalSource(id int) error
+	ExternalBlocks(sourceId int) ([]models.RoomRestriction, error)
+	ApplyCalendarSync(sourceId int, add, update []models.RoomRestriction, remove []int) error
+	InsertICalSyncLog(entry models.ICalSyncLog) error
+	ICalSyncLogs(propertyId, limit int) ([]models.ICalSyncLog, error)
+
+	AllAPIKeys() ([]models.APIKey, error)
+	GetAPIKeyByID(id int) (models.APIKey, error)
+	GetAPIKeyByHash(hash string) (models.APIKey, error)
+	InsertAPIKey(k models.APIKey) (int, error)
+	RevokeAPIKey(id int, at time.Time) error
+	TouchAPIKey(id int, at time.Time) error
 }
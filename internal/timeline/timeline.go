@@ -0,0 +1,105 @@
+package timeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+// Event types
+const (
+	EventCreated   = "created"
+	EventEmailed   = "emailed"
+	EventEdited    = "edited"
+	EventRoom      = "room_changed"
+	EventProcessed = "processed"
+	EventFolio     = "folio"
+	EventRefund    = "refund"
+	EventGroup     = "group"
+	EventCheckIn   = "checked_in"
+	EventCheckOut  = "checked_out"
+	EventCancelled = "cancelled"
+)
+
+// Item is one entry of a reservation timeline, either a staff note or an event
+type Item struct {
+	When      time.Time
+	Author    string
+	Note      bool
+	EventType string
+	Text      string
+}
+
+// Build merges notes and events into a timeline, newest first. Entries without an author are
+// attributed to staff for notes and to the system for events
+func Build(notes []models.ReservationNote, events []models.ReservationEvent) []Item {
+	items := make([]Item, 0, len(notes)+len(events))
+
+	for _, n := range notes {
+		items = append(items, Item{When: n.CreatedAt, Author: authorOr(n.Author, "Staff"), Note: true, Text: n.Body})
+	}
+	for _, e := range events {
+		items = append(items, Item{When: e.CreatedAt, Author: authorOr(e.Author, "System"), EventType: e.EventType, Text: e.Description})
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].When.After(items[j].When)
+	})
+
+	return items
+}
+
+func authorOr(author, fallback string) string {
+	if strings.TrimSpace(author) == "" {
+		return fallback
+	}
+	return author
+}
+
+// Change is one field that differs between two copies of a reservation
+type Change struct {
+	Field string
+	From  string
+	To    string
+}
+
+// Diff returns the fields staff can edit that differ between two copies of a reservation
+func Diff(before, after models.Reservation) []Change {
+	var changes []Change
+
+	field := func(name, from, to string) {
+		if from != to {
+			changes = append(changes, Change{Field: name, From: from, To: to})
+		}
+	}
+
+	field("name", before.FirstName+" "+before.LastName, after.FirstName+" "+after.LastName)
+	field("email", before.Email, after.Email)
+	field("phone", before.Phone, after.Phone)
+	field("dates", before.Stay().String(), after.Stay().String())
+	field("guests", guests(before), guests(after))
+
+	return changes
+}
+
+// Changes describes what staff changed on a reservation, or returns "" when nothing did
+func Changes(before, after models.Reservation) string {
+	changes := Diff(before, after)
+	if len(changes) == 0 {
+		return ""
+	}
+
+	described := make([]string, len(changes))
+	for i, c := range changes {
+		described[i] = fmt.Sprintf("%s from %q to %q", c.Field, c.From, c.To)
+	}
+
+	return "Changed " + strings.Join(described, ", ")
+}
+
+func guests(res models.Reservation) string {
+	return fmt.Sprintf("%d adult(s), %d child(ren)", res.Adults, res.Children)
+}
@@ -0,0 +1,109 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eldicela/bookings/internal/models"
+)
+
+func TestBuild(t *testing.T) {
+	base := time.Date(2050, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	items := Build(
+		[]models.ReservationNote{
+			{Body: "Late arrival", Author: "Ana Lee", CreatedAt: base.Add(2 * time.Hour)},
+			{Body: "Wants a cot", CreatedAt: base.Add(-time.Hour)},
+		},
+		[]models.ReservationEvent{
+			{EventType: EventCreated, Description: "Booked online", CreatedAt: base},
+			{EventType: EventEmailed, Author: "Ana Lee", Description: "Confirmation emailed", CreatedAt: base.Add(time.Hour)},
+		},
+	)
+
+	if len(items) != 4 {
+		t.Fatalf("expected 4 items, got %d", len(items))
+	}
+
+	expected := []string{"Late arrival", "Confirmation emailed", "Booked online", "Wants a cot"}
+	for i, text := range expected {
+		if items[i].Text != text {
+			t.Errorf("item %d: expected %q, got %q", i, text, items[i].Text)
+		}
+	}
+
+	if !items[0].Note || items[1].Note {
+		t.Error("notes and events are mixed up")
+	}
+	if items[2].Author != "System" {
+		t.Errorf("expected event without author to be by System, got %q", items[2].Author)
+	}
+	if items[3].Author != "Staff" {
+		t.Errorf("expected note without author to be by Staff, got %q", items[3].Author)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	before := models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@here.com",
+		StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+
+	if got := Changes(before, before); got != "" {
+		t.Errorf("expected no changes, got %q", got)
+	}
+
+	after := before
+	after.Email = "js@here.com"
+	after.Children = 1
+
+	expected := `Changed email from "john@here.com" to "js@here.com", guests from "2 adult(s), 0 child(ren)" to "2 adult(s), 1 child(ren)"`
+	if got := Changes(before, after); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Phone:     "555 0100",
+		StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+	}
+
+	if changes := Diff(before, before); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+
+	after := before
+	after.LastName = "Smyth"
+	after.Phone = ""
+	after.EndDate = time.Date(2050, 1, 13, 0, 0, 0, 0, time.UTC)
+
+	changes := Diff(before, after)
+	expected := []string{"name", "phone", "dates"}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %v", len(expected), changes)
+	}
+	for i, field := range expected {
+		if changes[i].Field != field {
+			t.Errorf("change %d: expected %s, got %s", i, field, changes[i].Field)
+		}
+	}
+
+	if changes[0].From != "John Smith" || changes[0].To != "John Smyth" {
+		t.Errorf("wrong name change %+v", changes[0])
+	}
+	if changes[1].From != "555 0100" || changes[1].To != "" {
+		t.Errorf("wrong phone change %+v", changes[1])
+	}
+	if changes[2].From == changes[2].To {
+		t.Errorf("expected the dates to differ, got %+v", changes[2])
+	}
+}
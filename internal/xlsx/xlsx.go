@@ -0,0 +1,136 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrClosed is returned when writing a row after Close
+var ErrClosed = errors.New("xlsx: writer is closed")
+
+// Writer streams a single sheet workbook. Rows are written straight to the underlying writer, so a
+// sheet of any size is never held in memory
+type Writer struct {
+	zw     *zip.Writer
+	sheet  io.Writer
+	rows   int
+	closed bool
+}
+
+const contentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const rootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const workbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+const workbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const sheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const sheetEnd = `</sheetData></worksheet>`
+
+// NewWriter starts a workbook with one sheet called name on w
+func NewWriter(w io.Writer, name string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypes},
+		{"_rels/.rels", rootRels},
+		{"xl/_rels/workbook.xml.rels", workbookRels},
+		{"xl/workbook.xml", fmt.Sprintf(workbook, escape(name))},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, p.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(sheet, sheetStart); err != nil {
+		return nil, err
+	}
+
+	return &Writer{zw: zw, sheet: sheet}, nil
+}
+
+// Write adds a row. Cells flagged in numeric are written as numbers, the others as text. Empty
+// cells are left out
+func (w *Writer) Write(cells []string, numeric []bool) error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.rows++
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, w.rows)
+	for i, c := range cells {
+		if c == "" {
+			continue
+		}
+		ref := column(i) + fmt.Sprint(w.rows)
+		if i < len(numeric) && numeric[i] {
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, escape(c))
+		} else {
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escape(c))
+		}
+	}
+	b.WriteString(`</row>`)
+
+	_, err := io.WriteString(w.sheet, b.String())
+	return err
+}
+
+// Close ends the sheet and the workbook. It does not close the underlying writer
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if _, err := io.WriteString(w.sheet, sheetEnd); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// column returns the letters of the column with index i, counting from 0
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, "Reservations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write([]string{"ID", "Name"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write([]string{"12", "Smith & Sons <b>"}, []bool{true, false}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write([]string{"1"}, nil); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+
+	sheet := files["xl/worksheets/sheet1.xml"]
+	if !strings.Contains(sheet, `<c r="A2"><v>12</v></c>`) {
+		t.Errorf("expected a number cell, got %s", sheet)
+	}
+	if !strings.Contains(sheet, "Smith &amp; Sons &lt;b&gt;") {
+		t.Errorf("expected escaped text, got %s", sheet)
+	}
+	if !strings.HasSuffix(sheet, "</sheetData></worksheet>") {
+		t.Errorf("sheet not closed: %s", sheet)
+	}
+	if !strings.Contains(files["xl/workbook.xml"], `name="Reservations"`) {
+		t.Error("wrong sheet name")
+	}
+}
+
+func TestColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := column(i); got != want {
+			t.Errorf("column %d: expected %s, got %s", i, want, got)
+		}
+	}
+}
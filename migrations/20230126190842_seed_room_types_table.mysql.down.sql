@@ -0,0 +1,3 @@
+UPDATE `reservations` SET `room_type_id` = NULL;
+UPDATE `rooms` SET `room_type_id` = NULL;
+DELETE FROM `room_types`;
@@ -0,0 +1,4 @@
+INSERT INTO `room_types` (`name`,`description`,`created_at`,`updated_at`)
+SELECT `room_name`, '', NOW(), NOW() FROM `rooms`;
+UPDATE `rooms` r JOIN `room_types` t ON (t.`name` = r.`room_name`) SET r.`room_type_id` = t.`id`;
+UPDATE `reservations` res JOIN `rooms` r ON (r.`id` = res.`room_id`) SET res.`room_type_id` = r.`room_type_id`;
@@ -0,0 +1,3 @@
+UPDATE `room_types` SET `property_id` = NULL;
+UPDATE `rooms` SET `property_id` = NULL;
+DELETE FROM `properties`;
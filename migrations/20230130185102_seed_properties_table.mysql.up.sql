@@ -0,0 +1,6 @@
+INSERT INTO `properties` (`name`,`slug`,`email`,`phone`,`address`,`description`,`created_at`,`updated_at`)
+VALUES ('Fort Smythe Bed and Breakfast','fort-smythe','me@here.com','','','',NOW(),NOW());
+UPDATE `rooms` SET `property_id` = (SELECT `id` FROM `properties` WHERE `slug` = 'fort-smythe');
+UPDATE `room_types` SET `property_id` = (SELECT `id` FROM `properties` WHERE `slug` = 'fort-smythe');
+INSERT INTO `property_users` (`property_id`,`user_id`,`created_at`,`updated_at`)
+SELECT p.`id`, u.`id`, NOW(), NOW() FROM `properties` p, `users` u WHERE p.`slug` = 'fort-smythe';
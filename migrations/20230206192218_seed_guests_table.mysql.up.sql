@@ -0,0 +1,7 @@
+INSERT INTO `guests` (`first_name`,`last_name`,`email`,`phone`,`email_key`,`phone_key`,`created_at`,`updated_at`)
+SELECT `first_name`, `last_name`, `email`, `phone`, LOWER(TRIM(`email`)), '', NOW(), NOW() FROM `reservations`
+WHERE `id` IN (SELECT MAX(`id`) FROM `reservations` GROUP BY LOWER(TRIM(`email`)));
+UPDATE `guests` SET `phone_key` = REGEXP_REPLACE(`phone`, '[^0-9]', '');
+UPDATE `guests` SET `phone_key` = SUBSTRING(`phone_key`, 3) WHERE `phone_key` LIKE '00%';
+UPDATE `guests` SET `phone_key` = '' WHERE CHAR_LENGTH(`phone_key`) < 7;
+UPDATE `reservations` r JOIN `guests` g ON (g.`email_key` = LOWER(TRIM(r.`email`))) SET r.`guest_id` = g.`id`;
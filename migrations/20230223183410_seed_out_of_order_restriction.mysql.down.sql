@@ -0,0 +1 @@
+DELETE FROM restrictions WHERE id = 3;
@@ -0,0 +1 @@
+INSERT INTO `restrictions` (`id`,`restriction_name`,`created_at`,`updated_at`) VALUES (3,'Out of Order','2023-02-23 18:34:10','2023-02-23 18:34:10');
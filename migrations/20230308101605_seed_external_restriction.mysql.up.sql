@@ -0,0 +1 @@
+INSERT INTO `restrictions` (`id`,`restriction_name`,`created_at`,`updated_at`) VALUES (4,'External Booking','2023-03-08 10:16:05','2023-03-08 10:16:05');
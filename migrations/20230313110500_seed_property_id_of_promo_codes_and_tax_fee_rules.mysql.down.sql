@@ -0,0 +1,2 @@
+UPDATE `tax_fee_rules` SET `property_id` = NULL;
+UPDATE `promo_codes` SET `property_id` = NULL;
@@ -0,0 +1,2 @@
+UPDATE `promo_codes` SET `property_id` = (SELECT `id` FROM `properties` WHERE `slug` = 'fort-smythe');
+UPDATE `tax_fee_rules` SET `property_id` = (SELECT `id` FROM `properties` WHERE `slug` = 'fort-smythe');